@@ -0,0 +1,181 @@
+// Package driver is the database/sql driver of godb, it is registered as "godb".
+// The data source name is the file name of the database, an empty name open a
+// database in memory.
+//
+//	db, err := sql.Open("godb", "test.db")
+//	rows, err := db.Query("select id, name from users where id > ?", 10)
+//	rows, err := db.Query("select id from users where name = :name", sql.Named("name", "x"))
+//
+// The connections of all the sql.DB on the same file share one btree, the database
+// is closed with its last connection. A connection wait up to busyTimeout for the
+// transaction of another connection, or for the lock of another process, then the
+// statement fail with btree.ErrorBusy. The wait for another connection also stop when
+// the context of the statement is done.
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"godb/internal/btree"
+	"godb/internal/executor"
+	"os"
+	"sync"
+	"time"
+)
+
+// busyTimeout is how long a statement wait for the database locked by another
+// connection or another process.
+const busyTimeout = 5 * time.Second
+
+var (
+	ErrorIsolationLevel = errors.New("unsupported isolation level")
+)
+
+func init() {
+	sql.Register("godb", &Driver{})
+}
+
+// Driver open the connections to the godb databases.
+type Driver struct {
+	mu  sync.Mutex
+	dbs []*database // the open databases
+}
+
+// database is an open database shared by the connections.
+type database struct {
+	bs   *btree.Shared
+	info os.FileInfo // identify the database file, nil for the database in memory
+	refs int         // number of open connections
+}
+
+// Open open a connection to the database file name.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	db, err := d.database(name)
+	if err != nil {
+		d.mu.Unlock()
+		return nil, err
+	}
+	db.refs++
+	d.mu.Unlock()
+	// the executor read the schema, which wait for the transaction of another
+	// connection, so it is created without holding the lock of the driver
+	exec, err := executor.New(db.bs)
+	if err != nil {
+		d.release(db)
+		return nil, err
+	}
+	return &conn{driver: d, db: db, exec: exec}, nil
+}
+
+// database return the open database on the file, or open it. A file opened by several
+// names, through a link for instance, is a single database. The caller hold the lock.
+func (d *Driver) database(name string) (*database, error) {
+	var info os.FileInfo
+	if name != "" {
+		var err error
+		if info, err = os.Stat(name); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	for _, db := range d.dbs {
+		if name == "" && db.info == nil || info != nil && db.info != nil && os.SameFile(info, db.info) {
+			return db, nil
+		}
+	}
+	bs, err := btree.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	// the file is created if it does not exist yet
+	if name != "" {
+		if info, err = os.Stat(name); err != nil {
+			bs.Close()
+			return nil, err
+		}
+	}
+	bs.SetBusyTimeout(busyTimeout)
+	db := &database{bs: bs, info: info}
+	d.dbs = append(d.dbs, db)
+	return db, nil
+}
+
+// release close the database once its last connection is closed.
+func (d *Driver) release(db *database) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	db.refs--
+	if db.refs > 0 {
+		return nil
+	}
+	for i, other := range d.dbs {
+		if other == db {
+			d.dbs = append(d.dbs[:i], d.dbs[i+1:]...)
+			break
+		}
+	}
+	return db.bs.Close()
+}
+
+// conn is a connection of database/sql, it run the statements with its own executor.
+type conn struct {
+	driver *Driver
+	db     *database
+	exec   *executor.Executor
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	s, err := c.exec.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{s}, nil
+}
+
+// Close roll back the open transaction and close the connection.
+func (c *conn) Close() error {
+	err := c.exec.Close()
+	if releaseErr := c.driver.release(c.db); err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx start a transaction, the transactions of godb are serializable. A read-only
+// transaction read along with the other connections, the others are deferred.
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	level := sql.IsolationLevel(opts.Isolation)
+	if level != sql.LevelDefault && level != sql.LevelSerializable {
+		return nil, ErrorIsolationLevel
+	}
+	var err error
+	if opts.ReadOnly {
+		err = c.exec.BeginReadOnly(ctx)
+	} else {
+		_, err = c.exec.ExecuteContext(ctx, "begin")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tx{c}, nil
+}
+
+// tx is the explicit transaction of a connection.
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	_, err := t.conn.exec.Execute("commit")
+	return err
+}
+
+func (t *tx) Rollback() error {
+	return t.conn.exec.Rollback()
+}
@@ -0,0 +1,254 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"godb/internal/btree"
+	"godb/internal/executor"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func countUsers(t *testing.T, db *sql.DB) int {
+	var n int
+	rows, err := db.Query("select id from users")
+	assert.Nil(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		n++
+	}
+	assert.Nil(t, rows.Err())
+	return n
+}
+
+func TestDriver(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("godb", fileName)
+	assert.Nil(t, err)
+	_, err = db.Exec("create table users (id integer, name varchar(16))")
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		result, err := db.Exec("insert into users values (?, ?)", i-50, fmt.Sprintf("user%d", i))
+		assert.Nil(t, err)
+		n, err := result.RowsAffected()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), n)
+	}
+	_, err = db.Exec("insert into users values (?, ?)", 100, nil)
+	assert.Nil(t, err)
+
+	// the values are scanned as Go types, NULL as a nil value
+	var id int
+	var name sql.NullString
+	assert.Nil(t, db.QueryRow("select id, name from users where id = ?", -40).Scan(&id, &name))
+	assert.Equal(t, -40, id)
+	assert.Equal(t, sql.NullString{String: "user10", Valid: true}, name)
+	assert.Nil(t, db.QueryRow("select id, name from users where id = ?", 100).Scan(&id, &name))
+	assert.False(t, name.Valid)
+	rows, err := db.Query("select * from users where name = '?'")
+	assert.Nil(t, err)
+	types, err := rows.ColumnTypes()
+	assert.Nil(t, err)
+	assert.Equal(t, "INTEGER", types[0].DatabaseTypeName())
+	assert.Equal(t, "VARCHAR", types[1].DatabaseTypeName())
+	length, ok := types[1].Length()
+	assert.True(t, ok)
+	assert.Equal(t, int64(16), length)
+	assert.False(t, rows.Next())
+	assert.Nil(t, rows.Close())
+
+	// the arguments are checked
+	_, err = db.Exec("insert into users values (?, ?)", 1)
+	assert.NotNil(t, err)
+	_, err = db.Exec("insert into users values (?, ?)", 1<<40, "x")
+	assert.Equal(t, executor.ErrorValueOutOfRange, err)
+	_, err = db.Exec("insert into users values (?, ?)", 1, 1.5)
+	assert.Equal(t, executor.ErrorUnsupportedValue, err)
+
+	// a value is never taken as SQL, a named argument bind a :name parameter
+	_, err = db.Exec("insert into users values (:id, :name)", sql.Named("name", "x'; drop --"), sql.Named("id", 200))
+	assert.Nil(t, err)
+	assert.Nil(t, db.QueryRow("select name from users where id = :id", sql.Named("id", 200)).Scan(&name))
+	assert.Equal(t, "x'; drop --", name.String)
+	_, err = db.Exec("insert into users values (?, ?)", 201, "O'Brien")
+	assert.Nil(t, err)
+	assert.Nil(t, db.QueryRow("select id from users where name = ?", "O'Brien").Scan(&id))
+	assert.Equal(t, 201, id)
+	_, err = db.Exec("delete from users where id = ? or name = ?", 200, "O'Brien")
+	assert.Nil(t, err)
+
+	// a transaction is committed or rolled back as a unit
+	tx, err := db.Begin()
+	assert.Nil(t, err)
+	_, err = tx.Exec("delete from users where id < ?", 0)
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Rollback())
+	assert.Equal(t, 101, countUsers(t, db))
+	tx, err = db.Begin()
+	assert.Nil(t, err)
+	stmt, err := tx.Prepare("update users set name = ? where id = ?")
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = stmt.Exec("renamed", i)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, stmt.Close())
+	assert.Nil(t, tx.Commit())
+	rows, err = db.Query("select id from users where name = ?", "renamed")
+	assert.Nil(t, err)
+	var ids []int
+	for rows.Next() {
+		assert.Nil(t, rows.Scan(&id))
+		ids = append(ids, id)
+	}
+	assert.Nil(t, rows.Err())
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, ids)
+	_, err = db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadUncommitted})
+	assert.Equal(t, ErrorIsolationLevel, err)
+	tx, err = db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	assert.Nil(t, err)
+	assert.Nil(t, tx.QueryRow("select id from users where name = ?", "renamed").Scan(&id))
+	_, err = tx.Exec("delete from users")
+	assert.Equal(t, btree.ErrorReadOnly, err)
+	assert.Nil(t, tx.Rollback())
+	assert.Nil(t, db.Close())
+
+	// another sql.DB on the file see the committed rows
+	db, err = sql.Open("godb", fileName)
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Equal(t, 101, countUsers(t, db))
+}
+
+func TestDriverConcurrent(t *testing.T) {
+	db, err := sql.Open("godb", filepath.Join(t.TempDir(), "test.db"))
+	assert.Nil(t, err)
+	defer db.Close()
+	_, err = db.Exec("create table users (id integer, name varchar(16))")
+	assert.Nil(t, err)
+
+	// the pooled connections share the database
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				_, err := db.Exec("insert into users values (?, ?)", w*20+i, "user")
+				assert.Nil(t, err)
+				countUsers(t, db)
+			}
+		}(w)
+	}
+	wg.Wait()
+	assert.Equal(t, 160, countUsers(t, db))
+}
+
+func TestDriverLink(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "test.db")
+	db, err := sql.Open("godb", fileName)
+	assert.Nil(t, err)
+	defer db.Close()
+	_, err = db.Exec("create table users (id integer, name varchar(16))")
+	assert.Nil(t, err)
+	link := filepath.Join(dir, "link.db")
+	assert.Nil(t, os.Symlink(fileName, link))
+	other, err := sql.Open("godb", link)
+	assert.Nil(t, err)
+	defer other.Close()
+
+	// the file opened by another name is the same database, no insert is lost
+	var wg sync.WaitGroup
+	for i, db := range []*sql.DB{db, other} {
+		wg.Add(1)
+		go func(i int, db *sql.DB) {
+			defer wg.Done()
+			for j := 0; j < 300; j++ {
+				_, err := db.Exec("insert into users values (?, ?)", i*300+j, "user")
+				assert.Nil(t, err)
+			}
+		}(i, db)
+	}
+	wg.Wait()
+	assert.Equal(t, 600, countUsers(t, db))
+	assert.Equal(t, 600, countUsers(t, other))
+	d := db.Driver().(*Driver)
+	d.mu.Lock()
+	assert.Equal(t, 1, len(d.dbs))
+	d.mu.Unlock()
+}
+
+func TestDriverOpenDuringTransaction(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("godb", filepath.Join(dir, "a.db"))
+	assert.Nil(t, err)
+	defer db.Close()
+	_, err = db.Exec("create table users (id integer)")
+	assert.Nil(t, err)
+	tx, err := db.Begin()
+	assert.Nil(t, err)
+	_, err = tx.Exec("insert into users values (1)")
+	assert.Nil(t, err)
+
+	// a new connection to the file wait for the transaction
+	opened := make(chan error)
+	go func() {
+		conn, err := db.Conn(context.Background())
+		if err == nil {
+			err = conn.Close()
+		}
+		opened <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// but the other files can still be opened
+	other, err := sql.Open("godb", filepath.Join(dir, "b.db"))
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, other.PingContext(ctx))
+	assert.Nil(t, other.Close())
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, <-opened)
+}
+
+func TestDriverContext(t *testing.T) {
+	db, err := sql.Open("godb", filepath.Join(t.TempDir(), "test.db"))
+	assert.Nil(t, err)
+	defer db.Close()
+	_, err = db.Exec("create table users (id integer)")
+	assert.Nil(t, err)
+	other, err := db.Conn(context.Background())
+	assert.Nil(t, err)
+	defer other.Close()
+	tx, err := db.Begin()
+	assert.Nil(t, err)
+	_, err = tx.Exec("insert into users values (1)")
+	assert.Nil(t, err)
+
+	// the wait for the transaction stop at the deadline, before the busy timeout
+	deadline := func() context.Context {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		t.Cleanup(cancel)
+		return ctx
+	}
+	start := time.Now()
+	_, err = other.ExecContext(deadline(), "insert into users values (?)", 2)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	_, err = other.QueryContext(deadline(), "select id from users where id > ?", 0)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	_, err = other.BeginTx(deadline(), nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+	assert.Less(t, time.Since(start), busyTimeout)
+	assert.Nil(t, tx.Commit())
+	_, err = other.ExecContext(context.Background(), "insert into users values (?)", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, countUsers(t, db))
+}
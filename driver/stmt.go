@@ -0,0 +1,141 @@
+package driver
+
+import (
+	"context"
+	"database/sql/driver"
+	"godb/internal/executor"
+	"godb/internal/parser"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// stmt is a prepared statement of a connection. The arguments are bound to its
+// parameters, `?`, `?NNN` or `:name`, a sql.Named argument bind `:name`.
+type stmt struct {
+	stmt *executor.Stmt
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return s.stmt.NumParams()
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if err := s.bind(args); err != nil {
+		return nil, err
+	}
+	result, err := s.stmt.ExecContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(result.RowsAffected), nil
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := s.bind(args); err != nil {
+		return nil, err
+	}
+	result, err := s.stmt.ExecContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{result: result}, nil
+}
+
+// bind bind the arguments by name or by position, the other parameters are NULL.
+func (s *stmt) bind(args []driver.NamedValue) error {
+	s.stmt.ClearBindings()
+	for _, arg := range args {
+		var err error
+		if arg.Name != "" {
+			err = s.stmt.BindName(":"+arg.Name, arg.Value)
+		} else {
+			err = s.stmt.Bind(arg.Ordinal, arg.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+// rows is the result of a query. An integer column is scanned as int64, a varchar
+// column as string.
+type rows struct {
+	result *executor.Result
+	pos    int // index of the next row
+}
+
+func (r *rows) Columns() []string {
+	return r.result.Columns
+}
+
+func (r *rows) Close() error {
+	r.pos = len(r.result.Rows)
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.result.Rows) {
+		return io.EOF
+	}
+	for i, value := range r.result.Rows[r.pos] {
+		dest[i] = goValue(value)
+	}
+	r.pos++
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName return INTEGER or VARCHAR.
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return strings.ToUpper(r.result.Types[index].Type().String())
+}
+
+// ColumnTypeScanType return the Go type the values of the column are scanned as.
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	if r.result.Types[index].Type() == parser.VarTypeVarchar {
+		return reflect.TypeOf("")
+	}
+	return reflect.TypeOf(int64(0))
+}
+
+// ColumnTypeLength return the max length of a varchar column.
+func (r *rows) ColumnTypeLength(index int) (int64, bool) {
+	ct := r.result.Types[index]
+	if ct.Type() != parser.VarTypeVarchar {
+		return 0, false
+	}
+	return int64(ct.Len()), true
+}
+
+// goValue return the Go value of a column value.
+func goValue(value parser.ColumnValue) driver.Value {
+	switch value.Type() {
+	case parser.VarTypeNull:
+		return nil
+	case parser.VarTypeInteger:
+		return int64(value.Int())
+	default:
+		return string(value.Value())
+	}
+}
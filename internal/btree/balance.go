@@ -0,0 +1,176 @@
+package btree
+
+// balanceNonRoot redistribute the cells of the child at index childIdx of the parent
+// page among the child and up to two of its siblings. The number of sibling pages may
+// grow when the child overflows, or shrink when the cells fit into fewer pages.
+// The divider cells in the parent page are updated to match the new siblings. The
+// parent page may overflow after the balance, the caller need to balance it too.
+//
+// Table b-tree and index b-tree both keep all the entries in leaf pages. The divider
+// cell of a leaf page is a copy of the largest key on that page. Non-leaf pages pull
+// the divider cells down when they are balanced, and push one cell up between every
+// two new siblings.
+func (bs *Shared) balanceNonRoot(parent *MemPage, childIdx uint16) error {
+	// choose up to three siblings around the child
+	first := 0
+	if childIdx > 0 {
+		first = int(childIdx) - 1
+	}
+	last := first + 2
+	if last > int(parent.CellNum) {
+		last = int(parent.CellNum)
+	}
+	if last-first < 2 {
+		first = last - 2
+		if first < 0 {
+			first = 0
+		}
+	}
+	nOld := last - first + 1
+	old := make([]*MemPage, nOld)
+	for k := range old {
+		mem, err := bs.GetPage(parent.GetKthChild(uint16(first+k)), PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+		if err != nil {
+			return err
+		}
+		if !mem.IsInit {
+			return ErrorCorruptedPage
+		}
+		old[k] = mem
+	}
+	flags := old[0].RawData[old[0].HeaderOffset]
+	isLeaf := old[0].IsLeaf
+
+	// gather all the cells of the siblings in key order.
+	// the dividers between non-leaf siblings are pulled down from the parent.
+	var cells []Cell
+	for k, mem := range old {
+		cells = append(cells, mem.allCells()...)
+		if !isLeaf && k < nOld-1 {
+			divider := cloneCell(parent.getKthLocalCell(uint16(first + k)))
+			divider.LeftChildPageNo = mem.GetRightChild()
+			cells = append(cells, divider)
+		}
+	}
+	rightMost := old[nOld-1].GetRightChild()
+
+	counts := distributeCells(cells, int(old[0].usableSpace()), isLeaf)
+	nNew := len(counts)
+
+	// reuse the old pages first, allocate new pages if more pages are needed
+	newPages := make([]*MemPage, nNew)
+	for k := 0; k < nNew; k++ {
+		if k < nOld {
+			newPages[k] = old[k]
+			continue
+		}
+		mem, err := bs.AllocateNewPage()
+		if err != nil {
+			return err
+		}
+		newPages[k] = mem
+	}
+
+	// fill the new pages and build the new divider cells
+	var dividers []Cell
+	idx := 0
+	for k, mem := range newPages {
+		pageCells := cells[idx : idx+counts[k]]
+		idx += counts[k]
+		var right PageNumber = 0
+		if !isLeaf {
+			if k < nNew-1 {
+				divider := cells[idx]
+				idx++
+				right = divider.LeftChildPageNo
+				divider.LeftChildPageNo = mem.PageNo
+				dividers = append(dividers, divider)
+			} else {
+				right = rightMost
+			}
+		} else if k < nNew-1 {
+			dividers = append(dividers, newDivider(mem, pageCells[len(pageCells)-1]))
+		}
+		if err := mem.assemble(flags, pageCells, right); err != nil {
+			return err
+		}
+	}
+	// the pages no longer used go to the freelist
+	for k := nNew; k < nOld; k++ {
+		if err := bs.FreePage(old[k].PageNo); err != nil {
+			return err
+		}
+	}
+
+	// replace the old dividers in the parent with the new ones
+	parentCells := parent.allCells()
+	merged := make([]Cell, 0, len(parentCells)+nNew)
+	merged = append(merged, parentCells[:first]...)
+	merged = append(merged, dividers...)
+	merged = append(merged, parentCells[last:]...)
+	parentRight := parent.GetRightChild()
+	// the pointer after the last new divider point to the last new page
+	if pos := first + nNew - 1; pos == len(merged) {
+		parentRight = newPages[nNew-1].PageNo
+	} else {
+		merged[pos].LeftChildPageNo = newPages[nNew-1].PageNo
+	}
+	return parent.assemble(parent.RawData[parent.HeaderOffset], merged, parentRight)
+}
+
+// newDivider build the divider cell for a leaf page whose largest cell is last.
+func newDivider(leaf *MemPage, last Cell) Cell {
+	var divider Cell
+	if leaf.IsDataPage {
+		// a table b-tree divider only need the integer key
+		divider = NewCell(last.Key, nil)
+	} else {
+		// an index b-tree divider need a copy of the whole key
+		divider = NewCell(last.Key, append([]byte{}, last.Payload...))
+	}
+	divider.LeftChildPageNo = leaf.PageNo
+	return divider
+}
+
+// distributeCells decide how many cells go to each new sibling page.
+// A non-leaf page give one cell to the parent between every two siblings.
+func distributeCells(cells []Cell, usable int, isLeaf bool) []int {
+	// fill the pages from left to right
+	var counts []int
+	used, n, total := 0, 0, 0
+	for i := 0; i < len(cells); i++ {
+		size := int(cells[i].CellSize()) + 2
+		total += size
+		if n > 0 && used+size > usable {
+			counts = append(counts, n)
+			used, n = 0, 0
+			if !isLeaf {
+				// the cell become a divider in the parent
+				continue
+			}
+		}
+		used += size
+		n++
+	}
+	counts = append(counts, n)
+	if !isLeaf || len(counts) == 1 {
+		return counts
+	}
+	// spread the cells evenly so that the last page is not almost empty
+	target := (total + len(counts) - 1) / len(counts)
+	even := make([]int, 0, len(counts))
+	used, n = 0, 0
+	for i := 0; i < len(cells); i++ {
+		size := int(cells[i].CellSize()) + 2
+		if n > 0 && len(even) < len(counts)-1 && (used >= target || used+size > usable) {
+			even = append(even, n)
+			used, n = 0, 0
+		}
+		used += size
+		n++
+	}
+	if used > usable {
+		return counts
+	}
+	return append(even, n)
+}
@@ -2,30 +2,64 @@ package btree
 
 import (
 	"errors"
+	"sync"
+	"time"
 )
 
 var (
 	ErrorInvalidPageNumber = errors.New("invalid page number")
 	ErrorCorruptedPage     = errors.New("page corrupted")
 	ErrorInvalidFlags      = errors.New("invalid flags")
+	ErrorDuplicateKey      = errors.New("duplicate key")
+	ErrorKeyNotFound       = errors.New("key not found")
+	ErrorKeyTooLarge       = errors.New("index key too large")
 )
 
 type Btree interface {
 	Insert(key uint32, data []byte) error
+	Delete(key uint32) error
+	InsertKey(key []byte, rowid uint32) error
+	DeleteKey(key []byte) error
+	Cursor() BtCursor
+	Iterate(start, end *Bound, dir Direction) (*Iterator, error)
+	GetRootPageNo() PageNumber
 }
 
 type BtCursor interface {
 	Insert(key uint32, data []byte) error
+	Delete(key uint32) error
 	MoveToRoot() error
-	MoveTo(key uint32)
+	MoveTo(key uint32) (int8, error)
+	// InsertKey, DeleteKey and MoveToKey work on an index btree, whose entries are
+	// ordered by the whole payload instead of the integer key.
+	InsertKey(key []byte, rowid uint32) error
+	DeleteKey(key []byte) error
+	MoveToKey(key []byte) (int8, error)
 	MoveNext() error
+	MovePrev() error
 	MoveToParent() error
-	MovetoChild(pageNo PageNumber)
+	MoveToChild(pageNo PageNumber) error
+	MoveToLeftMost() error
+	MoveToRightMost() error
+	// First and Last move the cursor to the smallest and the largest entry.
+	First() error
+	Last() error
+	// SeekGE and SeekKeyGE move the cursor to the first entry not smaller than the key.
+	SeekGE(key uint32) error
+	SeekKeyGE(key []byte) error
 	CompareKey(key uint32) int8
+	CompareBytes(key []byte) (int8, error)
+	Eof() bool
+	Key() uint32
+	Data() ([]byte, error)
+	// Close unpin the pages referenced by the cursor.
+	Close()
 }
 
 type btree struct {
-	Shared *Shared // shared content
+	Shared     *Shared    // shared content
+	RootPageNo PageNumber // root page number of the btree
+	Compare    Comparator // order of the keys of an index btree
 }
 
 type btCursor struct {
@@ -35,25 +69,218 @@ type btCursor struct {
 	RootPageNo        PageNumber // btree root page number
 	LastCompareResult int8       // last compare result
 	PStack            []*MemPage // stack for parents of current page
+	EOF               bool       // true if the cursor has moved past the last entry
+	Saved             bool       // true if the position is saved and the cursor must seek it again
+	SavedKey          uint32     // the integer key of the saved entry
+	SavedPayload      []byte     // the payload of the saved entry of an index btree
+	SkipNext          bool       // true if the saved entry is gone and the cursor already stand on the next one
 }
 
-// Shared is the sharable content of the btree
+// Shared is the sharable content of the btree. It is not safe for concurrent use by
+// itself, the goroutines share it through their own Conn.
 type Shared struct {
-	Pager      Pager      // the page cache
-	PageOne    MemPage    // first page of the database, always in memory
-	BtCursor   []BtCursor // current opened cursor on the btree
-	NumPage    uint32     // number of page in the database
-	UsableSize uint32     // the usable bytes on each page associate with the btree
-}
-
-//	func (bt *btree) Insert(key uint32, data []byte) error {
-//		pte, err := bt.Shared.Pager.FetchPage(bt.rootPageNo, pager.PAGE_CACHE_CREAT|pager.PAGE_CACHE_FETCH)
-//		if err != nil {
-//			return err
-//		}
-//		root := pte.Data
-//		loc := root.BinarySearchKey(key)
-//	}
+	Pager      Pager          // the page cache
+	PageOne    *MemPage       // first page of the database, always in memory
+	Header     DatabaseHeader // the database header stored on page one
+	BtCursor   []BtCursor     // current opened cursor on the btree
+	NumPage    uint32         // number of page in the database
+	UsableSize uint32         // the usable bytes on each page associate with the btree
+
+	// the transactions of the connections, guarded by the latch
+	latch       sync.Mutex    // held by the connection using the content above
+	readers     int           // number of open read transactions
+	writer      *Conn         // the connection with the write transaction, or waiting to start it
+	txnDone     chan struct{} // closed when a transaction ends, nil if no connection wait
+	busyTimeout time.Duration // how long a connection wait for the transaction of another one
+}
+
+// NewShared create the btree shared content on top of the pager.
+func NewShared(pgr Pager) *Shared {
+	bs := new(Shared)
+	bs.Pager = pgr
+	bs.NumPage = uint32(pgr.GetPageNumber())
+	bs.UsableSize = PageSize
+	return bs
+}
+
+// Open open the database file and load the database header from page one.
+// An empty file is initialized as a new database. If fileName is empty, the
+// database lives in memory only.
+func Open(fileName string) (*Shared, error) {
+	pgr, err := OpenPager(fileName)
+	if err != nil {
+		return nil, err
+	}
+	bs := NewShared(pgr)
+	// page one is loaded by the first lock
+	if err := bs.Lock(LockShared); err != nil {
+		pgr.Close()
+		return nil, err
+	}
+	if err := bs.Unlock(); err != nil {
+		pgr.Close()
+		return nil, err
+	}
+	return bs, nil
+}
+
+// loadPageOne read and validate the database header. If the database is empty,
+// page one is created with a fresh header and an empty table leaf page.
+func (bs *Shared) loadPageOne() error {
+	isNew := bs.Pager.GetPageNumber() == 0
+	pageOne, err := bs.GetPage(1, PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+	if err != nil {
+		return err
+	}
+	bs.PageOne = pageOne
+	if isNew {
+		bs.Header = NewDatabaseHeader()
+		if err := pageOne.ZeroPage(PAGE_DATA | PAGE_LEAF_DATA | PAGE_LEAF); err != nil {
+			return err
+		}
+		bs.NumPage = 1
+		if err := bs.writeHeader(); err != nil {
+			return err
+		}
+		return bs.Flush()
+	}
+	hdr, err := ParseHeader(pageOne.RawData)
+	if err != nil {
+		return err
+	}
+	if !pageOne.IsInit {
+		return ErrorCorruptedPage
+	}
+	bs.Header = hdr
+	// the page count in the header is trusted only if it matches the file
+	if hdr.NumPage != 0 && hdr.NumPage <= bs.NumPage {
+		bs.NumPage = hdr.NumPage
+	}
+	return nil
+}
+
+// writeHeader write the in memory header back to page one.
+func (bs *Shared) writeHeader() error {
+	if bs.PageOne == nil {
+		return nil
+	}
+	if err := bs.PageOne.markDirty(); err != nil {
+		return err
+	}
+	bs.Header.NumPage = bs.NumPage
+	// the change counter is increased by the pager on commit
+	bs.Header.ChangeCounter = changeCounter(bs.PageOne.RawData)
+	bs.Header.Write(bs.PageOne.RawData)
+	return nil
+}
+
+// ChangeSchema increase the schema cookie, so that the cached schema is known to be stale.
+func (bs *Shared) ChangeSchema() error {
+	bs.Header.SchemaCookie++
+	return bs.writeHeader()
+}
+
+// Flush write all the modified pages back to the database file.
+func (bs *Shared) Flush() error {
+	return bs.Pager.Flush()
+}
+
+// Commit make all the changes since the last commit durable.
+func (bs *Shared) Commit() error {
+	return bs.Pager.Commit()
+}
+
+// SetJournalMode switch the database between the rollback journal and the write-ahead log.
+// The mode is recorded in the database header, so the database is opened in the same mode.
+func (bs *Shared) SetJournalMode(mode uint8) error {
+	if mode != JournalModeDelete && mode != JournalModeWal {
+		return ErrorUnsupportedVersion
+	}
+	if err := bs.Commit(); err != nil {
+		return err
+	}
+	if mode == JournalModeDelete {
+		// leave WAL mode first so that the header is written to the database file
+		if err := bs.Pager.SetJournalMode(mode); err != nil {
+			return err
+		}
+	}
+	bs.Header.JournalMode = mode
+	if err := bs.writeHeader(); err != nil {
+		return err
+	}
+	if err := bs.Commit(); err != nil {
+		return err
+	}
+	return bs.Pager.SetJournalMode(mode)
+}
+
+// Rollback discard all the changes since the last commit, and load page one again.
+// The cursors opened before must be moved to the root page before they are used.
+func (bs *Shared) Rollback() error {
+	if err := bs.Pager.Rollback(); err != nil {
+		return err
+	}
+	bs.NumPage = uint32(bs.Pager.GetPageNumber())
+	return bs.loadPageOne()
+}
+
+// Begin start a write transaction before any page is modified.
+func (bs *Shared) Begin() error {
+	return bs.Pager.Begin()
+}
+
+// Savepoint open a savepoint nested in the savepoints opened before.
+func (bs *Shared) Savepoint() error {
+	return bs.Pager.Savepoint()
+}
+
+// RollbackTo discard all the changes since the nth savepoint was opened, counted from
+// the outermost one. The savepoint stay open, the savepoints opened after it are closed.
+func (bs *Shared) RollbackTo(n int) error {
+	if err := bs.Pager.RollbackTo(n); err != nil {
+		return err
+	}
+	bs.NumPage = uint32(bs.Pager.GetPageNumber())
+	return bs.loadPageOne()
+}
+
+// ReleaseSavepoint close the nth savepoint and the savepoints opened after it, their
+// changes are kept.
+func (bs *Shared) ReleaseSavepoint(n int) error {
+	return bs.Pager.ReleaseSavepoint(n)
+}
+
+// Lock raise the lock on the database file to level. Page one is loaded again if
+// another process changed the database since the lock was last held, the cursors
+// opened before must be moved to the root page before they are used.
+func (bs *Shared) Lock(level LockLevel) error {
+	if err := bs.Pager.Lock(level); err != nil {
+		return err
+	}
+	if _, err := bs.Pager.GetPageCache().FetchPage(1, PAGE_CACHE_FETCH); err != ErrorCacheMiss {
+		return err
+	}
+	bs.NumPage = uint32(bs.Pager.GetPageNumber())
+	return bs.loadPageOne()
+}
+
+// Unlock release the lock on the database file once the write transaction is over.
+func (bs *Shared) Unlock() error {
+	return bs.Pager.Unlock()
+}
+
+// SetBusyTimeout set how long a lock held by another process, or a transaction of
+// another connection, is waited for before ErrorBusy is returned.
+func (bs *Shared) SetBusyTimeout(timeout time.Duration) {
+	bs.Pager.SetBusyTimeout(timeout)
+	bs.busyTimeout = timeout
+}
+
+// Close flush the modified pages and close the database file.
+func (bs *Shared) Close() error {
+	return bs.Pager.Close()
+}
 
 // GetPage get a page from the pager.
 func (bs *Shared) GetPage(pageNo PageNumber, flags uint8) (*MemPage, error) {
@@ -66,39 +293,222 @@ func (bs *Shared) GetPage(pageNo PageNumber, flags uint8) (*MemPage, error) {
 }
 
 // AllocateNewPage will allocate a new page from the database file.
+// A page on the freelist is reused first, the database file only grows when the freelist is empty.
 func (bs *Shared) AllocateNewPage() (*MemPage, error) {
-	// FIXME: currently, the AllocateNewPage always allocate a new page rather than use free list
-	// because three are no free page management.
-	bs.NumPage += 1
-	mem, err := bs.GetPage(PageNumber(bs.NumPage), PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+	mem, err := bs.allocateFreePage()
+	if err != nil {
+		return nil, err
+	}
+	if mem != nil {
+		return mem, nil
+	}
+	bs.NumPage = uint32(bs.Pager.GetPageNumber()) + 1
+	mem, err = bs.GetPage(PageNumber(bs.NumPage), PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
 	if err != nil {
 		return nil, err
 	}
+	if err := bs.writeHeader(); err != nil {
+		return nil, err
+	}
 	return mem, nil
 }
 
+// CreateBtree allocate a root page for a new btree. flags must be PAGE_DATA|PAGE_LEAF_DATA
+// for a table b-tree, or PAGE_INDEX for an index b-tree.
+func (bs *Shared) CreateBtree(flags uint8) (PageNumber, error) {
+	if !checkFlags(flags) || (flags&PAGE_LEAF) > 0 {
+		return 0, ErrorInvalidFlags
+	}
+	root, err := bs.AllocateNewPage()
+	if err != nil {
+		return 0, err
+	}
+	if err := root.ZeroPage(flags | PAGE_LEAF); err != nil {
+		return 0, err
+	}
+	return root.PageNo, nil
+}
+
+// OpenBtree return the btree whose root page is rootPageNo. The keys of an index
+// btree are compared by CompareBinary.
+func (bs *Shared) OpenBtree(rootPageNo PageNumber) Btree {
+	return bs.OpenIndex(rootPageNo, CompareBinary)
+}
+
+// OpenIndex return the index btree whose root page is rootPageNo, the keys are
+// compared by cmp, or CompareBinary if cmp is nil.
+func (bs *Shared) OpenIndex(rootPageNo PageNumber, cmp Comparator) Btree {
+	if cmp == nil {
+		cmp = CompareBinary
+	}
+	return &btree{Shared: bs, RootPageNo: rootPageNo, Compare: cmp}
+}
+
+func (bt *btree) GetRootPageNo() PageNumber {
+	return bt.RootPageNo
+}
+
+// Cursor open a new cursor on the btree. The pages referenced by the cursor are
+// pinned in the page cache until the cursor is closed.
+func (bt *btree) Cursor() BtCursor {
+	btc := &btCursor{Btree: bt, RootPageNo: bt.RootPageNo}
+	bt.Shared.BtCursor = append(bt.Shared.BtCursor, btc)
+	return btc
+}
+
+func (bt *btree) Insert(key uint32, data []byte) error {
+	cursor := bt.Cursor()
+	defer cursor.Close()
+	return cursor.Insert(key, data)
+}
+
+func (bt *btree) Delete(key uint32) error {
+	cursor := bt.Cursor()
+	defer cursor.Close()
+	return cursor.Delete(key)
+}
+
+func (bt *btree) InsertKey(key []byte, rowid uint32) error {
+	cursor := bt.Cursor()
+	defer cursor.Close()
+	return cursor.InsertKey(key, rowid)
+}
+
+func (bt *btree) DeleteKey(key []byte) error {
+	cursor := bt.Cursor()
+	defer cursor.Close()
+	return cursor.DeleteKey(key)
+}
+
+// Close remove the cursor from the open cursors of the shared content.
+func (btc *btCursor) Close() {
+	bs := btc.Btree.Shared
+	for i, cursor := range bs.BtCursor {
+		if cursor == BtCursor(btc) {
+			bs.BtCursor = append(bs.BtCursor[:i], bs.BtCursor[i+1:]...)
+			break
+		}
+	}
+	btc.Mem = nil
+	btc.PStack = nil
+}
+
+// saveCursors save the position of the other cursors on the same btree before the
+// cursor modify it, because the entries they point to may move to other pages.
+func (bs *Shared) saveCursors(btc *btCursor) {
+	for _, cursor := range bs.BtCursor {
+		other := cursor.(*btCursor)
+		if other != btc && other.RootPageNo == btc.RootPageNo {
+			other.savePosition()
+		}
+	}
+}
+
+// savePosition remember the entry the cursor point to and release its pages. The
+// entry is looked up again when the cursor is used next.
+func (btc *btCursor) savePosition() {
+	if btc.Saved {
+		return
+	}
+	if !btc.Eof() {
+		btc.Saved = true
+		btc.SavedKey = btc.Mem.GetKthKey(btc.CellIndex)
+		btc.SavedPayload = nil
+		if !btc.Mem.IsDataPage {
+			// an index key is never spilled to overflow pages
+			payload, _ := btc.Mem.GetKthCellContent(btc.CellIndex)
+			btc.SavedPayload = append([]byte{}, payload...)
+		}
+	}
+	btc.Mem = nil
+	btc.PStack = nil
+}
+
+// restorePosition move the cursor back to its saved entry. If the entry is gone, the
+// cursor stop on the entry after it and SkipNext is set, so that the next MoveNext
+// stay there.
+func (btc *btCursor) restorePosition() error {
+	if !btc.Saved {
+		return nil
+	}
+	var loc int8
+	var err error
+	if btc.SavedPayload != nil {
+		loc, err = btc.MoveToKey(btc.SavedPayload)
+	} else {
+		loc, err = btc.MoveTo(btc.SavedKey)
+	}
+	if err != nil || loc == 0 {
+		return err
+	}
+	if err := btc.seekGE(loc); err != nil {
+		return err
+	}
+	btc.SkipNext = true
+	return nil
+}
+
+// isPinned return true if the page is referenced by an open cursor.
+func (bs *Shared) isPinned(pageNo PageNumber) bool {
+	for _, cursor := range bs.BtCursor {
+		btc := cursor.(*btCursor)
+		if btc.Mem != nil && btc.Mem.PageNo == pageNo {
+			return true
+		}
+		for _, mem := range btc.PStack {
+			if mem.PageNo == pageNo {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (btc *btCursor) Insert(key uint32, data []byte) error {
 	// move to the proper position
 	loc, err := btc.MoveTo(key)
 	if err != nil {
 		return err
 	}
-	cell := NewCell(key, data)
-	if loc == 0 { // if loc == 0, then the cursor is in the key itself
-		cell.LeftChildPageNo = btc.Mem.GetKthLeftPageNumber(btc.CellIndex)
-	} else if loc > 0 && btc.Mem.CellNum > 0 {
-		// the cursor point to a value bigger than the key.
-		// The key will insert on the left side
+	return btc.insertAt(loc, key, data)
+}
 
-	} else if loc < 0 {
+// InsertKey insert an entry into an index btree. The key is the payload of the cell,
+// the rowid is stored as the integer key of the cell. An index key is never spilled
+// to overflow pages, because the dividers copied from it must hold the whole key.
+func (btc *btCursor) InsertKey(key []byte, rowid uint32) error {
+	if len(key) > maxLocal {
+		return ErrorKeyTooLarge
+	}
+	loc, err := btc.MoveToKey(key)
+	if err != nil {
+		return err
+	}
+	return btc.insertAt(loc, rowid, key)
+}
+
+// insertAt insert the entry next to the cell the cursor point to, loc is the
+// compare result returned by MoveTo.
+func (btc *btCursor) insertAt(loc int8, key uint32, data []byte) error {
+	if loc == 0 { // if loc == 0, then the cursor is in the key itself
+		return ErrorDuplicateKey
+	}
+	btc.Btree.Shared.saveCursors(btc)
+	// a large payload is spilled to overflow pages
+	cell, err := btc.Btree.Shared.newCell(key, data)
+	if err != nil {
+		return err
+	}
+	if loc < 0 {
 		// the cursor point to a value smaller than the key,
 		// The key will insert on the right side
 		btc.CellIndex++
 	}
-	// TODO: finish the cursor insert
+	// otherwise the cursor point to a value bigger than the key or the page is empty.
+	// The key will insert on the left side
 	err = btc.Mem.InsertCellFast(cell, btc.CellIndex)
 	if err != nil {
-		return nil
+		return err
 	}
 	// insert produce at least one overflow cell, which means the page is full.
 	// the page thus need a balance.
@@ -111,88 +521,226 @@ func (btc *btCursor) Insert(key uint32, data []byte) error {
 	return nil
 }
 
-// balance the page the cursor currently point to
-func (btc *btCursor) balance() error {
-	mem := btc.Mem
-	if len(mem.OverflowCell) == 0 &&
-		mem.FreeBytes*3 <= 4096*2 {
-		// if page has no overflow cell and page has enough space,
-		// there is no need to balance.
+// Delete remove the entry with the key from the btree.
+// If the page become less than one third full, the page is merged or redistributed
+// with its siblings. If no balance is needed, the cursor point to the entry after
+// the deleted one.
+func (btc *btCursor) Delete(key uint32) error {
+	loc, err := btc.MoveTo(key)
+	if err != nil {
+		return err
+	}
+	return btc.deleteAt(loc)
+}
+
+// DeleteKey remove the entry with the key from an index btree.
+func (btc *btCursor) DeleteKey(key []byte) error {
+	loc, err := btc.MoveToKey(key)
+	if err != nil {
+		return err
+	}
+	return btc.deleteAt(loc)
+}
+
+// deleteAt remove the cell the cursor point to, loc is the compare result returned by MoveTo.
+func (btc *btCursor) deleteAt(loc int8) error {
+	if loc != 0 {
+		return ErrorKeyNotFound
+	}
+	btc.Btree.Shared.saveCursors(btc)
+	// the overflow pages of the cell are freed with the cell
+	err := btc.Btree.Shared.freeOverflow(btc.Mem.getKthLocalCell(btc.CellIndex))
+	if err != nil {
+		return err
+	}
+	err = btc.Mem.DropCell(btc.CellIndex)
+	if err != nil {
+		return err
+	}
+	if len(btc.PStack) > 0 && btc.Mem.needBalance() {
+		return btc.balance()
+	}
+	if btc.Mem.CellNum == 0 {
+		// only the root page is left without any cell, the btree is empty
+		btc.EOF = true
 		return nil
-	} else if len(btc.PStack) == 0 {
-		// the root page need balance
-		rightChild, err := mem.BalanceDeep()
-		if err != nil {
+	}
+	if btc.CellIndex >= btc.Mem.CellNum {
+		// the deleted entry was the last one on the page
+		btc.CellIndex--
+		return btc.MoveNext()
+	}
+	return nil
+}
+
+// needBalance return true if the page has overflow cells or the page is less than one third full.
+func (mem *MemPage) needBalance() bool {
+	return len(mem.OverflowCell) > 0 ||
+		int(mem.FreeBytes)*3 > int(mem.usableSpace())*2
+}
+
+// balanceShallower is used when the root page is a non-leaf page without any cell.
+// The content of its only child is copied into the root page and the child is freed,
+// so the btree become one level shallower.
+func (mem *MemPage) balanceShallower() error {
+	bs := mem.BShared
+	child, err := bs.GetPage(mem.GetRightChild(), PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+	if err != nil {
+		return err
+	}
+	if !child.IsInit {
+		return ErrorCorruptedPage
+	}
+	// page one has less space than the child, the root is kept when the child can not fit
+	used := child.usableSpace() - child.FreeBytes
+	flags := child.RawData[child.HeaderOffset]
+	if child.IsLeaf {
+		if used > PageSize-mem.HeaderOffset-8 {
+			return nil
+		}
+	} else if used > PageSize-mem.HeaderOffset-12 {
+		return nil
+	}
+	if err := mem.assemble(flags, child.allCells(), child.GetRightChild()); err != nil {
+		return err
+	}
+	return bs.FreePage(child.PageNo)
+}
+
+// balance the page the cursor currently point to, then balance every parent on the
+// stack that overflow because of the new divider cells.
+// The cursor is moved back to the root page after balance.
+func (btc *btCursor) balance() error {
+	bs := btc.Btree.Shared
+	for {
+		mem := btc.Mem
+		if len(btc.PStack) == 0 && len(mem.OverflowCell) == 0 {
+			// the root page lost its last divider, the btree become shallower
+			if !mem.IsLeaf && mem.CellNum == 0 {
+				if err := mem.balanceShallower(); err != nil {
+					return err
+				}
+			}
+			break
+		} else if !mem.needBalance() {
+			// if page has no overflow cell and page has enough content,
+			// there is no need to balance.
+			break
+		} else if len(btc.PStack) == 0 {
+			// the root page need balance. move the root content to a child and balance the child.
+			child, err := mem.BalanceDeep()
+			if err != nil {
+				return err
+			}
+			btc.PStack = append(btc.PStack, mem)
+			btc.Mem = child
+			continue
+		}
+		parent := btc.PStack[len(btc.PStack)-1]
+		idx := parent.ChildIndex(mem.PageNo)
+		if idx < 0 {
+			return ErrorCorruptedPage
+		}
+		if err := bs.balanceNonRoot(parent, uint16(idx)); err != nil {
 			return err
 		}
-		btc.PStack = append(btc.PStack, rightChild)
+		btc.PStack = btc.PStack[:len(btc.PStack)-1]
+		btc.Mem = parent
 	}
-	return nil
+	return btc.MoveToRoot()
 }
 
 // MoveToRoot move to the root page of the btree
 func (btc *btCursor) MoveToRoot() error {
+	// a cursor operation start here, the pages fetched before are not used any more
+	btc.Btree.Shared.Pager.GetPageCache().Release()
 	// get the root page
 	rootMem, err := btc.Btree.Shared.GetPage(btc.RootPageNo, PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
 	if err != nil {
 		return err
 	}
+	if !rootMem.IsInit {
+		return ErrorCorruptedPage
+	}
 	btc.Mem = rootMem
 	btc.CellIndex = 0
+	btc.EOF = false
+	btc.Saved = false
+	btc.SkipNext = false
 	// clean the parents stack
 	btc.PStack = nil
 	return nil
 }
 
-// MoveTo move the cursor to a proper position relate to the key.
+// MoveTo move the cursor to a proper position relate to the key. The cursor always
+// stop on a leaf page, because all the entries are stored in leaf pages.
 // return value > 0 if cursor point to a value bigger than the search key or cursor on an empty page
 // return value = 0 if cursor point to exact the same key
 // return value < 0 if cursor point to a value smaller than the search key
 func (btc *btCursor) MoveTo(key uint32) (int8, error) {
+	return btc.moveTo(func() (int8, error) {
+		return btc.CompareKey(key), nil
+	})
+}
+
+// MoveToKey is the same as MoveTo, but the entries of an index btree are compared
+// by their payload with the comparator of the btree.
+func (btc *btCursor) MoveToKey(key []byte) (int8, error) {
+	return btc.moveTo(func() (int8, error) {
+		return btc.CompareBytes(key)
+	})
+}
+
+// moveTo binary search every page from the root down to a leaf. compare compare
+// the search key with the cell the cursor point to, the same as CompareKey.
+func (btc *btCursor) moveTo(compare func() (int8, error)) (int8, error) {
 	// reset the cursor to root page, the CellIndex is set to 0.
 	err := btc.MoveToRoot()
 	if err != nil {
 		return -2, err
 	}
-	// the page is empty, directly return
-	if btc.Mem.CellNum == 0 {
-		return 1, nil
-	}
-	var lo int32 = 0
-	var hi = int32(btc.Mem.CellNum) - 1
-	var child PageNumber = 0
-	var c int8 = -1
-	// binary search the content index array
 	for {
+		// binary search the first cell whose key is not smaller than the search key
+		var lo int32 = 0
+		var hi = int32(btc.Mem.CellNum) - 1
 		for lo <= hi {
 			btc.CellIndex = uint16(lo + (hi-lo)/2)
-			c = btc.CompareKey(key)
-			// if c > 0, which means cursorKey < key
+			c, err := compare()
+			if err != nil {
+				return -2, err
+			}
+			// if c > 0, which means cursorKey > key
 			if c > 0 {
 				hi = int32(btc.CellIndex) - 1
 			} else if c == 0 {
-				btc.LastCompareResult = c
-				return c, nil
+				lo = int32(btc.CellIndex)
+				break
 			} else {
 				lo = int32(btc.CellIndex) + 1
 			}
 		}
-		// the key is bigger than all the key in the page, move to the right child
-		if lo >= int32(btc.Mem.CellNum) {
-			child = btc.Mem.GetRightChild()
-			// if no right child, then the cursor is point to a value than smaller than the key
-			if child == 0 {
-				return -1, nil
+		if btc.Mem.IsLeaf {
+			// the page is empty, directly return
+			if btc.Mem.CellNum == 0 {
+				btc.CellIndex = 0
+				btc.LastCompareResult = 1
+				return 1, nil
 			}
-		} else {
-			// otherwise the cursor stop at the key that exactly bigger then the search key
-			child = btc.Mem.GetKthLeftPageNumber(uint16(lo))
-		}
-		if child == 0 {
-			btc.LastCompareResult = c
-			return c, nil
+			if lo >= int32(btc.Mem.CellNum) {
+				// the key is bigger than all the key in the page
+				btc.CellIndex = btc.Mem.CellNum - 1
+			} else {
+				btc.CellIndex = uint16(lo)
+			}
+			btc.LastCompareResult, err = compare()
+			if err != nil {
+				return -2, err
+			}
+			return btc.LastCompareResult, nil
 		}
-		err := btc.MoveToChild(child)
+		// a divider is the largest key of its left child. if the key is bigger than
+		// all the dividers, move to the right child
+		err := btc.MoveToChild(btc.Mem.GetKthChild(uint16(lo)))
 		if err != nil {
 			return -2, err
 		}
@@ -201,79 +749,189 @@ func (btc *btCursor) MoveTo(key uint32) (int8, error) {
 
 func (btc *btCursor) MoveToChild(pageNo PageNumber) error {
 	// get the child page
-	childMem, err := btc.Btree.Shared.Pager.FetchPage(pageNo, PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+	childMem, err := btc.Btree.Shared.GetPage(pageNo, PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
 	if err != nil {
 		return err
 	}
+	if !childMem.IsInit {
+		return ErrorCorruptedPage
+	}
 	// before switch to the child page, push the current page into stack
 	btc.PStack = append(btc.PStack, btc.Mem)
 	btc.CellIndex = 0
-	btc.Mem = childMem.Data
+	btc.Mem = childMem
 	return nil
 }
 
+// MoveNext move the cursor to the next entry. If there is no more entry, Eof
+// return true after MoveNext.
 func (btc *btCursor) MoveNext() error {
+	if err := btc.restorePosition(); err != nil {
+		return err
+	}
+	if btc.SkipNext {
+		btc.SkipNext = false
+		return nil
+	}
+	if btc.EOF {
+		return nil
+	}
+	btc.Btree.Shared.Pager.GetPageCache().Release()
 	btc.CellIndex++
-	// check if the cursor has reached the end
-	if btc.CellIndex >= btc.Mem.CellNum {
-		rh := btc.Mem.GetRightChild()
-		// if the right child exist
-		if rh != 0 {
-			err := btc.MoveToChild(rh)
+	// check if the cursor has reached the end of the leaf page
+	for btc.CellIndex >= btc.Mem.CellNum {
+		// The cursor need to move to parent page before advance
+		for {
+			// if the parent stack is empty, then the cursor can not advance anymore
+			if len(btc.PStack) == 0 {
+				btc.EOF = true
+				return nil
+			}
+			// move to the parent page
+			err := btc.MoveToParent()
 			if err != nil {
 				return err
 			}
-			err = btc.MoveToLeftMost()
-			if err != nil {
-				return nil
+			// if the cursor not come from the right child of the parent page, the next child exists
+			if btc.CellIndex < btc.Mem.CellNum {
+				break
 			}
+		}
+		btc.CellIndex++
+		err := btc.MoveToChild(btc.Mem.GetKthChild(btc.CellIndex))
+		if err != nil {
+			return err
+		}
+		err = btc.MoveToLeftMost()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MovePrev move the cursor to the previous entry. If there is no more entry, Eof
+// return true after MovePrev.
+func (btc *btCursor) MovePrev() error {
+	if err := btc.restorePosition(); err != nil {
+		return err
+	}
+	if btc.SkipNext {
+		// the entry before the saved one is the one before the next entry
+		btc.SkipNext = false
+		if btc.EOF {
+			return btc.Last()
+		}
+	}
+	if btc.EOF {
+		return nil
+	}
+	btc.Btree.Shared.Pager.GetPageCache().Release()
+	if btc.CellIndex > 0 {
+		btc.CellIndex--
+		return nil
+	}
+	// the cursor is on the first entry of the leaf page, climb up until the current
+	// page is not the leftmost child of its parent
+	for {
+		if len(btc.PStack) == 0 {
+			btc.EOF = true
 			return nil
-			// otherwise the cursor is in a leaf page. The cursor need to move to parent page before advance
-		} else {
-			for {
-				// if the parent stack is empty, then the cursor can not advance anymore
-				if len(btc.PStack) == 0 {
-					return nil
-				}
-				// move to the parent page
-				err := btc.MoveToParent()
-				if err != nil {
-					return err
-				}
-				// if the cursor not come from a right page of an internal page, the cursor is in correct position
-				if btc.CellIndex < btc.Mem.CellNum {
-					break
-				}
-			}
-			return btc.MoveNext()
+		}
+		if err := btc.MoveToParent(); err != nil {
+			return err
+		}
+		if btc.CellIndex > 0 {
+			break
 		}
 	}
-	err := btc.MoveToLeftMost()
-	if err != nil {
+	btc.CellIndex--
+	if err := btc.MoveToChild(btc.Mem.GetKthChild(btc.CellIndex)); err != nil {
 		return err
 	}
-	return nil
+	return btc.MoveToRightMost()
 }
 
+// MoveToLeftMost move the cursor to the leftmost entry in the subtree of the current cell.
 func (btc *btCursor) MoveToLeftMost() error {
-	for {
-		leftPageNo := btc.Mem.GetKthLeftPageNumber(btc.CellIndex)
-		if leftPageNo != 0 {
-			err := btc.MoveToChild(leftPageNo)
-			if err != nil {
-				return err
-			}
-		} else {
-			break
+	for !btc.Mem.IsLeaf {
+		err := btc.MoveToChild(btc.Mem.GetKthChild(btc.CellIndex))
+		if err != nil {
+			return err
 		}
 	}
+	// only an empty root page has no cell
+	if btc.Mem.CellNum == 0 {
+		btc.EOF = true
+	}
+	return nil
+}
+
+// MoveToRightMost move the cursor to the rightmost entry in the subtree of the current page.
+func (btc *btCursor) MoveToRightMost() error {
+	for !btc.Mem.IsLeaf {
+		btc.CellIndex = btc.Mem.CellNum
+		if err := btc.MoveToChild(btc.Mem.GetRightChild()); err != nil {
+			return err
+		}
+	}
+	// only an empty root page has no cell
+	if btc.Mem.CellNum == 0 {
+		btc.EOF = true
+		return nil
+	}
+	btc.CellIndex = btc.Mem.CellNum - 1
+	return nil
+}
+
+func (btc *btCursor) First() error {
+	if err := btc.MoveToRoot(); err != nil {
+		return err
+	}
+	return btc.MoveToLeftMost()
+}
+
+func (btc *btCursor) Last() error {
+	if err := btc.MoveToRoot(); err != nil {
+		return err
+	}
+	return btc.MoveToRightMost()
+}
+
+func (btc *btCursor) SeekGE(key uint32) error {
+	loc, err := btc.MoveTo(key)
+	if err != nil {
+		return err
+	}
+	return btc.seekGE(loc)
+}
+
+func (btc *btCursor) SeekKeyGE(key []byte) error {
+	loc, err := btc.MoveToKey(key)
+	if err != nil {
+		return err
+	}
+	return btc.seekGE(loc)
+}
+
+// seekGE move the cursor from where MoveTo stop to the first entry not smaller than
+// the search key. MoveTo stop on the last entry of the leaf page if all the entries
+// of the page are smaller than the key, then the entry needed is the next one.
+func (btc *btCursor) seekGE(loc int8) error {
+	if btc.Mem.CellNum == 0 {
+		btc.EOF = true
+		return nil
+	}
+	if loc < 0 {
+		return btc.MoveNext()
+	}
 	return nil
 }
 
 // CompareKey compare key to the key that cursor current point to. > 0 if
 // cursorKey > key; = 0 if cursorKey = key; < 0 if cursorKey < key.
 func (btc *btCursor) CompareKey(key uint32) int8 {
-	cursorKey := btc.Mem.GetKthKey(btc.CellIndex)
+	cursorKey := btc.Key()
 	if cursorKey > key {
 		return 1
 	} else if cursorKey == key {
@@ -283,7 +941,54 @@ func (btc *btCursor) CompareKey(key uint32) int8 {
 	}
 }
 
-// MoveToParent move the cursor to the root
+// CompareBytes compare key to the payload of the cell that cursor current point to,
+// with the comparator of the btree. The result is the same as CompareKey.
+func (btc *btCursor) CompareBytes(key []byte) (int8, error) {
+	cursorKey := btc.SavedPayload
+	if !btc.Saved {
+		var err error
+		if cursorKey, err = btc.Mem.GetKthCellContent(btc.CellIndex); err != nil {
+			return 0, err
+		}
+	}
+	c := btc.Btree.Compare(cursorKey, key)
+	if c > 0 {
+		return 1, nil
+	} else if c == 0 {
+		return 0, nil
+	}
+	return -1, nil
+}
+
+// Eof return true if the cursor does not point to any entry.
+func (btc *btCursor) Eof() bool {
+	if btc.Saved {
+		return false
+	}
+	return btc.EOF || btc.Mem == nil || btc.CellIndex >= btc.Mem.CellNum
+}
+
+// Key return the key of the entry the cursor point to.
+func (btc *btCursor) Key() uint32 {
+	if btc.Saved {
+		return btc.SavedKey
+	}
+	return btc.Mem.GetKthKey(btc.CellIndex)
+}
+
+// Data return the whole payload of the entry the cursor point to. return
+// ErrorKeyNotFound if the entry is deleted by another cursor.
+func (btc *btCursor) Data() ([]byte, error) {
+	if err := btc.restorePosition(); err != nil {
+		return nil, err
+	}
+	if btc.SkipNext {
+		return nil, ErrorKeyNotFound
+	}
+	return btc.Mem.GetKthCellContent(btc.CellIndex)
+}
+
+// MoveToParent move the cursor to the parent page
 // the caller should guarantee there has at least one parent in the stack
 func (btc *btCursor) MoveToParent() error {
 	parent := btc.PStack[len(btc.PStack)-1]
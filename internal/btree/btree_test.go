@@ -1,8 +1,14 @@
 package btree
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"godb/internal/utils"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -51,11 +57,275 @@ func TestSerialInsert(t *testing.T) {
 	cursor.Insert(11, []byte{0x7, 0x8, 0x7})        // 7
 	cursor.Insert(13, []byte{0x13, 0x8, 0x9, 0x13}) // 8
 	cursor.Insert(4, []byte{0x4, 0x8, 0x9, 0x15})   // 3
-	cellOne := cursor.Mem.GetKthCell(0)
-	cellTwo := cursor.Mem.GetKthCell(3)
-	cellThree := cursor.Mem.GetKthCell(8)
+	cellOne, _ := cursor.Mem.GetKthCell(0)
+	cellTwo, _ := cursor.Mem.GetKthCell(3)
+	cellThree, _ := cursor.Mem.GetKthCell(8)
 	DumpToFile(*cursor.Mem)
 	assert.Equal(t, cellOne.Payload, []byte{0x1, 0x2, 0x3})
 	assert.Equal(t, cellTwo.Payload, []byte{0x4, 0x8, 0x9, 0x15})
 	assert.Equal(t, cellThree.Payload, []byte{0x13, 0x8, 0x9, 0x13})
 }
+
+// collectKeys walk the whole btree with a cursor and return the keys in order.
+func collectKeys(t *testing.T, bt Btree) []uint32 {
+	cursor := bt.Cursor()
+	defer cursor.Close()
+	assert.Nil(t, cursor.MoveToRoot())
+	assert.Nil(t, cursor.MoveToLeftMost())
+	var keys []uint32
+	for !cursor.Eof() {
+		keys = append(keys, cursor.Key())
+		assert.Nil(t, cursor.MoveNext())
+	}
+	return keys
+}
+
+func payloadOf(key uint32) []byte {
+	payload := make([]byte, 40+key%60)
+	for i := range payload {
+		payload[i] = byte(key + uint32(i))
+	}
+	return payload
+}
+
+func TestBalanceInsert(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	bs, err := Open(fileName)
+	assert.Nil(t, err)
+	root, err := bs.CreateBtree(PAGE_DATA | PAGE_LEAF_DATA)
+	assert.Nil(t, err)
+	bt := bs.OpenBtree(root)
+	n := 5000
+	rnd := rand.New(rand.NewSource(1))
+	for _, i := range rnd.Perm(n) {
+		assert.Nil(t, bt.Insert(uint32(i), payloadOf(uint32(i))))
+	}
+	assert.Equal(t, ErrorDuplicateKey, bt.Insert(10, []byte{0x1}))
+	assert.Nil(t, bs.Close())
+
+	bs, err = Open(fileName)
+	assert.Nil(t, err)
+	defer bs.Close()
+	bt = bs.OpenBtree(root)
+	keys := collectKeys(t, bt)
+	assert.Equal(t, n, len(keys))
+	for i, key := range keys {
+		assert.Equal(t, uint32(i), key)
+	}
+	cursor := bt.Cursor()
+	for i := 0; i < n; i += 7 {
+		loc, err := cursor.MoveTo(uint32(i))
+		assert.Nil(t, err)
+		assert.Equal(t, int8(0), loc)
+		data, err := cursor.Data()
+		assert.Nil(t, err)
+		assert.Equal(t, payloadOf(uint32(i)), data)
+	}
+	loc, err := cursor.MoveTo(uint32(n + 10))
+	assert.Nil(t, err)
+	assert.Equal(t, int8(-1), loc)
+}
+
+func TestBalanceSerialInsert(t *testing.T) {
+	bs, err := Open("")
+	assert.Nil(t, err)
+	// the schema table on page one is used as the root, so the root has a smaller usable space
+	bt := bs.OpenBtree(1)
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, bt.Insert(uint32(i), payloadOf(uint32(i))))
+	}
+	keys := collectKeys(t, bt)
+	assert.Equal(t, 3000, len(keys))
+	for i, key := range keys {
+		assert.Equal(t, uint32(i), key)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	bs, err := Open("")
+	assert.Nil(t, err)
+	root, err := bs.CreateBtree(PAGE_DATA | PAGE_LEAF_DATA)
+	assert.Nil(t, err)
+	bt := bs.OpenBtree(root)
+	n := 5000
+	rnd := rand.New(rand.NewSource(2))
+	for _, i := range rnd.Perm(n) {
+		assert.Nil(t, bt.Insert(uint32(i), payloadOf(uint32(i))))
+	}
+	pages := bs.NumPage
+	deleted := map[uint32]bool{}
+	for _, i := range rnd.Perm(n)[:n-500] {
+		assert.Nil(t, bt.Delete(uint32(i)))
+		deleted[uint32(i)] = true
+	}
+	assert.Equal(t, ErrorKeyNotFound, bt.Delete(uint32(n+1)))
+	keys := collectKeys(t, bt)
+	assert.Equal(t, 500, len(keys))
+	var expected []uint32
+	for i := 0; i < n; i++ {
+		if !deleted[uint32(i)] {
+			expected = append(expected, uint32(i))
+		}
+	}
+	assert.Equal(t, expected, keys)
+	// the emptied pages are put on the freelist
+	assert.True(t, bs.Header.FreelistCount > pages/2)
+
+	for _, key := range keys {
+		assert.Nil(t, bt.Delete(key))
+	}
+	assert.Equal(t, 0, len(collectKeys(t, bt)))
+	rootPage, err := bs.GetPage(root, PAGE_CACHE_FETCH)
+	assert.Nil(t, err)
+	assert.True(t, rootPage.IsLeaf)
+	assert.Equal(t, uint16(0), rootPage.CellNum)
+	assert.Equal(t, pages-2, bs.Header.FreelistCount)
+
+	// the cursor is at the end once the last entry is deleted
+	assert.Nil(t, bt.Insert(1, payloadOf(1)))
+	cursor := bt.Cursor()
+	defer cursor.Close()
+	assert.Nil(t, cursor.Delete(1))
+	assert.True(t, cursor.Eof())
+	assert.Equal(t, uint16(0), cursor.(*btCursor).CellIndex)
+	assert.Nil(t, cursor.MoveNext())
+	assert.True(t, cursor.Eof())
+}
+
+func TestDropCellReuseSpace(t *testing.T) {
+	mem, err := NewMemPage(2, PAGE_DATA|PAGE_LEAF|PAGE_LEAF_DATA)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, mem.InsertCellFast(NewCell(uint32(i), make([]byte, 100)), uint16(i)))
+	}
+	free := mem.FreeBytes
+	assert.Nil(t, mem.DropCell(3))
+	assert.Nil(t, mem.DropCell(3))
+	assert.Equal(t, free+2*(2+112), mem.FreeBytes)
+	// the two dropped cells are merged into one free block
+	first := utils.GetUint16(mem.RawData[3:])
+	assert.NotEqual(t, uint16(0), first)
+	assert.Equal(t, uint16(224), utils.GetUint16(mem.RawData[first+2:]))
+	assert.Nil(t, mem.InsertCellFast(NewCell(3, make([]byte, 100)), 3))
+	assert.Equal(t, uint32(3), mem.GetKthKey(3))
+	assert.Equal(t, uint32(5), mem.GetKthKey(4))
+	// fill the page until the free space is fragmented
+	for i := 10; mem.FreeBytes >= 2+112; i++ {
+		assert.Nil(t, mem.InsertCellFast(NewCell(uint32(i), make([]byte, 100)), mem.CellNum))
+	}
+	assert.Equal(t, 0, len(mem.OverflowCell))
+	assert.Nil(t, mem.ComputeFreeBytes())
+}
+
+func TestOverflowPayload(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	bs, err := Open(fileName)
+	assert.Nil(t, err)
+	root, err := bs.CreateBtree(PAGE_DATA | PAGE_LEAF_DATA)
+	assert.Nil(t, err)
+	bt := bs.OpenBtree(root)
+	big := func(key uint32, size int) []byte {
+		payload := make([]byte, size)
+		for i := range payload {
+			payload[i] = byte(int(key) * i)
+		}
+		return payload
+	}
+	sizes := map[uint32]int{}
+	for i := uint32(0); i < 200; i++ {
+		sizes[i] = int(i%5)*3000 + int(i%3)*700
+		assert.Nil(t, bt.Insert(i, big(i, sizes[i])))
+	}
+	assert.Nil(t, bs.Close())
+
+	bs, err = Open(fileName)
+	assert.Nil(t, err)
+	defer bs.Close()
+	bt = bs.OpenBtree(root)
+	cursor := bt.Cursor()
+	for i := uint32(0); i < 200; i++ {
+		loc, err := cursor.MoveTo(i)
+		assert.Nil(t, err)
+		assert.Equal(t, int8(0), loc)
+		data, err := cursor.Data()
+		assert.Nil(t, err)
+		assert.Equal(t, big(i, sizes[i]), data)
+	}
+	// deleting the cells give all the overflow pages back to the freelist
+	for i := uint32(0); i < 200; i++ {
+		assert.Nil(t, bt.Delete(i))
+	}
+	assert.Equal(t, bs.NumPage-2, bs.Header.FreelistCount)
+}
+
+func indexKeyOf(i int) []byte {
+	return []byte(fmt.Sprintf("key-%05d-%s", i, strings.Repeat("x", i%50)))
+}
+
+func TestIndexBtree(t *testing.T) {
+	bs, err := Open("")
+	assert.Nil(t, err)
+	root, err := bs.CreateBtree(PAGE_INDEX)
+	assert.Nil(t, err)
+	bt := bs.OpenBtree(root)
+	n := 3000
+	rnd := rand.New(rand.NewSource(3))
+	for _, i := range rnd.Perm(n) {
+		assert.Nil(t, bt.InsertKey(indexKeyOf(i), uint32(i)))
+	}
+	assert.Equal(t, ErrorDuplicateKey, bt.InsertKey(indexKeyOf(10), 10))
+	assert.Equal(t, ErrorKeyTooLarge, bt.InsertKey(make([]byte, PageSize), 1))
+	// the entries are ordered by the key bytes, and carry the rowid as the integer key
+	assert.Equal(t, n, len(collectKeys(t, bt)))
+	cursor := bt.Cursor()
+	defer cursor.Close()
+	for i := 0; i < n; i += 11 {
+		loc, err := cursor.MoveToKey(indexKeyOf(i))
+		assert.Nil(t, err)
+		assert.Equal(t, int8(0), loc)
+		assert.Equal(t, uint32(i), cursor.Key())
+	}
+	// a prefix stop on the first entry not smaller than the prefix
+	loc, err := cursor.MoveToKey([]byte("key-01234"))
+	assert.Nil(t, err)
+	if loc < 0 {
+		assert.Nil(t, cursor.MoveNext())
+	}
+	assert.Equal(t, uint32(1234), cursor.Key())
+
+	for _, i := range rnd.Perm(n)[:n-100] {
+		assert.Nil(t, bt.DeleteKey(indexKeyOf(i)))
+	}
+	assert.Equal(t, ErrorKeyNotFound, bt.DeleteKey([]byte("missing")))
+	keys := collectKeys(t, bt)
+	assert.Equal(t, 100, len(keys))
+	for i := 1; i < len(keys); i++ {
+		assert.True(t, bytes.Compare(indexKeyOf(int(keys[i-1])), indexKeyOf(int(keys[i]))) < 0)
+	}
+}
+
+func TestIndexComparator(t *testing.T) {
+	bs, err := Open("")
+	assert.Nil(t, err)
+	root, err := bs.CreateBtree(PAGE_INDEX)
+	assert.Nil(t, err)
+	// the keys are compared with the ASCII letters folded to lower case
+	bt := bs.OpenIndex(root, func(a, b []byte) int {
+		return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b))
+	})
+	words := []string{"delta", "Alpha", "charlie", "Bravo", "echo"}
+	for i, word := range words {
+		assert.Nil(t, bt.InsertKey([]byte(word), uint32(i)))
+	}
+	// the keys equal under the comparator are duplicates
+	assert.Equal(t, ErrorDuplicateKey, bt.InsertKey([]byte("ALPHA"), 9))
+	assert.Equal(t, []uint32{1, 3, 2, 0, 4}, collectKeys(t, bt))
+	cursor := bt.Cursor()
+	defer cursor.Close()
+	loc, err := cursor.MoveToKey([]byte("CHARLIE"))
+	assert.Nil(t, err)
+	assert.Equal(t, int8(0), loc)
+	assert.Equal(t, uint32(2), cursor.Key())
+	assert.Nil(t, bt.DeleteKey([]byte("bravo")))
+	assert.Equal(t, []uint32{1, 2, 0, 4}, collectKeys(t, bt))
+}
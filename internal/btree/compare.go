@@ -0,0 +1,14 @@
+package btree
+
+import "bytes"
+
+// Comparator compare two keys of an index btree. It return < 0 if a < b, 0 if a == b
+// and > 0 if a > b. The entries of an index btree are kept in the order of its
+// comparator, so a btree must always be opened with the same comparator.
+type Comparator func(a, b []byte) int
+
+// CompareBinary compare the keys byte by byte, the same as memcmp. It is the default
+// comparator of an index btree.
+func CompareBinary(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
@@ -0,0 +1,532 @@
+package btree
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Conn is a connection to the shared btree content. Any number of connections can be
+// opened on the same Shared and used from different goroutines, but a connection is
+// used by one goroutine at a time.
+//
+// Every operation of a connection hold the latch of the Shared, thus the page cache,
+// the page allocation and the list of the open cursors are used by one goroutine at
+// a time. A transaction hold the database longer: the read transactions run together,
+// and a write transaction run alone. The other connections wait until the write
+// transaction ends, so they never see the changes not committed yet. A deferred
+// transaction is a read transaction until its first write, then it wait for the other
+// readers to leave. A connection wait for the transaction of another one until the
+// busy timeout expire, then it fail with ErrorBusy, or until the context of the
+// operation is done. An operation outside a transaction run in a transaction of its
+// own.
+type Conn struct {
+	Shared *Shared
+	txn    connTxn
+}
+
+// TxnMode is the kind of the transaction a connection begin.
+type TxnMode uint8
+
+const (
+	TxnRead     TxnMode = iota // a read transaction, it can not write
+	TxnDeferred                // a read transaction until its first write
+	TxnWrite                   // a write transaction from the start
+)
+
+type connTxn uint8
+
+const (
+	txnNone connTxn = iota
+	txnRead
+	txnDeferred
+	txnWrite
+)
+
+var (
+	ErrorTransactionOpen = errors.New("connection already in a transaction")
+	ErrorReadOnly        = errors.New("cannot write in a read transaction")
+)
+
+// Conn open a new connection on the shared content.
+func (bs *Shared) Conn() *Conn {
+	return &Conn{Shared: bs}
+}
+
+func (c *Conn) enter() {
+	c.Shared.latch.Lock()
+}
+
+func (c *Conn) leave() {
+	c.Shared.latch.Unlock()
+}
+
+// InTransaction return true if the connection has an open transaction.
+func (c *Conn) InTransaction() bool {
+	return c.txn != txnNone
+}
+
+// Begin start a transaction, see BeginContext.
+func (c *Conn) Begin(mode TxnMode) error {
+	return c.BeginContext(context.Background(), mode)
+}
+
+// BeginContext start a transaction. It wait until the write transaction of another
+// connection ends, and a write transaction also wait for the readers. A writer waiting
+// for the readers keep the new readers out. The first reader take the shared lock on
+// the database file for all the readers.
+func (c *Conn) BeginContext(ctx context.Context, mode TxnMode) error {
+	if c.txn != txnNone {
+		return ErrorTransactionOpen
+	}
+	bs := c.Shared
+	write := mode == TxnWrite
+	c.enter()
+	defer c.leave()
+	if err := c.wait(ctx, func() (bool, error) { return c.tryBegin(write), nil }); err != nil {
+		c.cancelWrite()
+		return err
+	}
+	if write || bs.readers == 0 {
+		if err := bs.Lock(LockShared); err != nil {
+			c.endTxn(write)
+			return err
+		}
+	}
+	switch mode {
+	case TxnWrite:
+		c.txn = txnWrite
+	case TxnDeferred:
+		bs.readers++
+		c.txn = txnDeferred
+	default:
+		bs.readers++
+		c.txn = txnRead
+	}
+	return nil
+}
+
+// upgrade turn the deferred transaction into a write transaction once the other
+// readers leave. If another writer is waiting for this reader, waiting for that writer
+// would never end, so ErrorBusy is returned at once.
+func (c *Conn) upgrade(ctx context.Context) error {
+	bs := c.Shared
+	c.enter()
+	defer c.leave()
+	err := c.wait(ctx, func() (bool, error) {
+		if bs.writer != nil && bs.writer != c {
+			return false, ErrorBusy
+		}
+		bs.writer = c
+		return bs.readers == 1, nil
+	})
+	if err != nil {
+		c.cancelWrite()
+		return err
+	}
+	bs.readers--
+	c.txn = txnWrite
+	return nil
+}
+
+// tryBegin return true if no other connection hold a conflicting transaction, a writer
+// is registered until the readers leave. The caller hold the latch.
+func (c *Conn) tryBegin(write bool) bool {
+	bs := c.Shared
+	if bs.writer != nil && bs.writer != c {
+		return false
+	}
+	if !write {
+		return true
+	}
+	bs.writer = c
+	return bs.readers == 0
+}
+
+// wait call try until it succeed. The latch is held by the caller, it is released
+// while waiting for a transaction of another connection to end. ErrorBusy is returned
+// once the busy timeout expire, the error of ctx once it is done.
+func (c *Conn) wait(ctx context.Context, try func() (bool, error)) error {
+	bs := c.Shared
+	var timeout <-chan time.Time
+	for {
+		ok, err := try()
+		if ok || err != nil {
+			return err
+		}
+		if timeout == nil {
+			if bs.busyTimeout <= 0 {
+				return ErrorBusy
+			}
+			timer := time.NewTimer(bs.busyTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		if bs.txnDone == nil {
+			bs.txnDone = make(chan struct{})
+		}
+		done := bs.txnDone
+		c.leave()
+		select {
+		case <-done:
+			c.enter()
+		case <-timeout:
+			c.enter()
+			return ErrorBusy
+		case <-ctx.Done():
+			c.enter()
+			return ctx.Err()
+		}
+	}
+}
+
+// cancelWrite stop waiting for the readers to start a write transaction. The caller
+// hold the latch.
+func (c *Conn) cancelWrite() {
+	if c.Shared.writer == c && c.txn != txnWrite {
+		c.Shared.writer = nil
+		c.Shared.notify()
+	}
+}
+
+// notify wake up the connections waiting for a transaction to end. The caller hold
+// the latch.
+func (bs *Shared) notify() {
+	if bs.txnDone != nil {
+		close(bs.txnDone)
+		bs.txnDone = nil
+	}
+}
+
+// Commit end the transaction. The changes of a write transaction are committed, the
+// transaction stay open if the commit fails.
+func (c *Conn) Commit() error {
+	if c.txn == txnNone {
+		return nil
+	}
+	bs := c.Shared
+	c.enter()
+	defer c.leave()
+	if c.txn == txnWrite {
+		if err := bs.Commit(); err != nil {
+			return err
+		}
+	}
+	return c.release()
+}
+
+// Rollback end the transaction, the changes of a write transaction are discarded.
+func (c *Conn) Rollback() error {
+	if c.txn == txnNone {
+		return nil
+	}
+	c.enter()
+	defer c.leave()
+	var err error
+	if c.txn == txnWrite {
+		err = c.Shared.Rollback()
+	}
+	if releaseErr := c.release(); err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+// release end the transaction and unlock the database file once the last reader leave.
+// The caller hold the latch.
+func (c *Conn) release() error {
+	bs := c.Shared
+	write := c.txn == txnWrite
+	var err error
+	if !write {
+		bs.readers--
+	}
+	if write || bs.readers == 0 {
+		err = bs.Unlock()
+	}
+	c.endTxn(write)
+	return err
+}
+
+// endTxn end the transaction. The caller hold the latch.
+func (c *Conn) endTxn(write bool) {
+	c.txn = txnNone
+	if write {
+		c.Shared.writer = nil
+	}
+	c.Shared.notify()
+}
+
+// Do run fn, see DoContext.
+func (c *Conn) Do(write bool, fn func() error) error {
+	return c.DoContext(context.Background(), write, fn)
+}
+
+// DoContext run fn with the latch held, in the transaction of the connection or in a
+// transaction of its own. fn can use the Shared and its btrees directly, but must
+// not use the connection. A write is refused in a read transaction, and turn a
+// deferred transaction into a write transaction.
+//
+// The transaction of its own is rolled back if fn fails or panics.
+func (c *Conn) DoContext(ctx context.Context, write bool, fn func() error) error {
+	if c.txn == txnNone {
+		mode := TxnRead
+		if write {
+			mode = TxnWrite
+		}
+		if err := c.BeginContext(ctx, mode); err != nil {
+			return err
+		}
+		committed := false
+		defer func() {
+			if !committed {
+				c.Rollback()
+			}
+		}()
+		if err := c.run(fn); err != nil {
+			return err
+		}
+		if err := c.Commit(); err != nil {
+			return err
+		}
+		committed = true
+		return nil
+	}
+	if write && c.txn == txnRead {
+		return ErrorReadOnly
+	}
+	if write && c.txn == txnDeferred {
+		if err := c.upgrade(ctx); err != nil {
+			return err
+		}
+	}
+	return c.run(fn)
+}
+
+// run run fn with the latch held, the latch is released even if fn panics.
+func (c *Conn) run(fn func() error) error {
+	c.enter()
+	defer c.leave()
+	return fn()
+}
+
+// SetBusyTimeout set how long a busy lock is retried, see Shared.SetBusyTimeout.
+func (c *Conn) SetBusyTimeout(timeout time.Duration) {
+	c.enter()
+	defer c.leave()
+	c.Shared.SetBusyTimeout(timeout)
+}
+
+// Close roll back the open transaction.
+func (c *Conn) Close() error {
+	return c.Rollback()
+}
+
+// CreateBtree create an empty btree, see Shared.CreateBtree.
+func (c *Conn) CreateBtree(flags uint8) (PageNumber, error) {
+	var root PageNumber
+	err := c.Do(true, func() error {
+		var err error
+		root, err = c.Shared.CreateBtree(flags)
+		return err
+	})
+	return root, err
+}
+
+// DropBtree free all the pages of the btree, see Shared.DropBtree.
+func (c *Conn) DropBtree(root PageNumber) error {
+	return c.Do(true, func() error {
+		return c.Shared.DropBtree(root)
+	})
+}
+
+// OpenBtree open the table btree through the connection.
+func (c *Conn) OpenBtree(rootPageNo PageNumber) Btree {
+	return &connBtree{c, c.Shared.OpenBtree(rootPageNo).(*btree)}
+}
+
+// OpenIndex open the index btree through the connection.
+func (c *Conn) OpenIndex(rootPageNo PageNumber, cmp Comparator) Btree {
+	return &connBtree{c, c.Shared.OpenIndex(rootPageNo, cmp).(*btree)}
+}
+
+// connBtree is a btree used through a connection, every operation hold the latch.
+type connBtree struct {
+	conn *Conn
+	bt   *btree
+}
+
+func (cb *connBtree) Insert(key uint32, data []byte) error {
+	return cb.conn.Do(true, func() error { return cb.bt.Insert(key, data) })
+}
+
+func (cb *connBtree) Delete(key uint32) error {
+	return cb.conn.Do(true, func() error { return cb.bt.Delete(key) })
+}
+
+func (cb *connBtree) InsertKey(key []byte, rowid uint32) error {
+	return cb.conn.Do(true, func() error { return cb.bt.InsertKey(key, rowid) })
+}
+
+func (cb *connBtree) DeleteKey(key []byte) error {
+	return cb.conn.Do(true, func() error { return cb.bt.DeleteKey(key) })
+}
+
+func (cb *connBtree) Cursor() BtCursor {
+	cb.conn.enter()
+	defer cb.conn.leave()
+	return &connCursor{cb.conn, cb.bt.Cursor().(*btCursor)}
+}
+
+func (cb *connBtree) Iterate(start, end *Bound, dir Direction) (*Iterator, error) {
+	var it *Iterator
+	err := cb.conn.Do(false, func() error {
+		var err error
+		it, err = cb.bt.Iterate(start, end, dir)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	it.conn = cb.conn
+	return it, nil
+}
+
+func (cb *connBtree) GetRootPageNo() PageNumber {
+	return cb.bt.RootPageNo
+}
+
+// connCursor is a cursor used through a connection, every operation hold the latch.
+// The position of the cursor is saved when another cursor modify the btree.
+type connCursor struct {
+	conn *Conn
+	btc  *btCursor
+}
+
+func (cc *connCursor) read(fn func() error) error {
+	return cc.conn.Do(false, fn)
+}
+
+func (cc *connCursor) write(fn func() error) error {
+	return cc.conn.Do(true, fn)
+}
+
+func (cc *connCursor) Insert(key uint32, data []byte) error {
+	return cc.write(func() error { return cc.btc.Insert(key, data) })
+}
+
+func (cc *connCursor) Delete(key uint32) error {
+	return cc.write(func() error { return cc.btc.Delete(key) })
+}
+
+func (cc *connCursor) InsertKey(key []byte, rowid uint32) error {
+	return cc.write(func() error { return cc.btc.InsertKey(key, rowid) })
+}
+
+func (cc *connCursor) DeleteKey(key []byte) error {
+	return cc.write(func() error { return cc.btc.DeleteKey(key) })
+}
+
+func (cc *connCursor) MoveToRoot() error {
+	return cc.read(cc.btc.MoveToRoot)
+}
+
+func (cc *connCursor) MoveTo(key uint32) (int8, error) {
+	var loc int8
+	err := cc.read(func() error {
+		var err error
+		loc, err = cc.btc.MoveTo(key)
+		return err
+	})
+	return loc, err
+}
+
+func (cc *connCursor) MoveToKey(key []byte) (int8, error) {
+	var loc int8
+	err := cc.read(func() error {
+		var err error
+		loc, err = cc.btc.MoveToKey(key)
+		return err
+	})
+	return loc, err
+}
+
+func (cc *connCursor) MoveNext() error {
+	return cc.read(cc.btc.MoveNext)
+}
+
+func (cc *connCursor) MovePrev() error {
+	return cc.read(cc.btc.MovePrev)
+}
+
+func (cc *connCursor) MoveToParent() error {
+	return cc.read(cc.btc.MoveToParent)
+}
+
+func (cc *connCursor) MoveToChild(pageNo PageNumber) error {
+	return cc.read(func() error { return cc.btc.MoveToChild(pageNo) })
+}
+
+func (cc *connCursor) MoveToLeftMost() error {
+	return cc.read(cc.btc.MoveToLeftMost)
+}
+
+func (cc *connCursor) MoveToRightMost() error {
+	return cc.read(cc.btc.MoveToRightMost)
+}
+
+func (cc *connCursor) First() error {
+	return cc.read(cc.btc.First)
+}
+
+func (cc *connCursor) Last() error {
+	return cc.read(cc.btc.Last)
+}
+
+func (cc *connCursor) SeekGE(key uint32) error {
+	return cc.read(func() error { return cc.btc.SeekGE(key) })
+}
+
+func (cc *connCursor) SeekKeyGE(key []byte) error {
+	return cc.read(func() error { return cc.btc.SeekKeyGE(key) })
+}
+
+func (cc *connCursor) CompareKey(key uint32) int8 {
+	cc.conn.enter()
+	defer cc.conn.leave()
+	return cc.btc.CompareKey(key)
+}
+
+func (cc *connCursor) CompareBytes(key []byte) (int8, error) {
+	cc.conn.enter()
+	defer cc.conn.leave()
+	return cc.btc.CompareBytes(key)
+}
+
+func (cc *connCursor) Eof() bool {
+	cc.conn.enter()
+	defer cc.conn.leave()
+	return cc.btc.Eof()
+}
+
+func (cc *connCursor) Key() uint32 {
+	cc.conn.enter()
+	defer cc.conn.leave()
+	return cc.btc.Key()
+}
+
+func (cc *connCursor) Data() ([]byte, error) {
+	var data []byte
+	err := cc.read(func() error {
+		var err error
+		data, err = cc.btc.Data()
+		return err
+	})
+	return data, err
+}
+
+func (cc *connCursor) Close() {
+	cc.conn.enter()
+	defer cc.conn.leave()
+	cc.btc.Close()
+}
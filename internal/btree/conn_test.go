@@ -0,0 +1,216 @@
+package btree
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnConcurrent(t *testing.T) {
+	bs, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	assert.Nil(t, err)
+	defer bs.Close()
+	root, err := bs.Conn().CreateBtree(PAGE_DATA | PAGE_LEAF_DATA)
+	assert.Nil(t, err)
+	bs.SetBusyTimeout(10 * time.Second)
+
+	// every writer commit its entries by batches of 10, a reader never see a batch
+	// in part
+	var wg sync.WaitGroup
+	writers, batches := 4, 20
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			conn := bs.Conn()
+			bt := conn.OpenBtree(root)
+			for b := 0; b < batches; b++ {
+				assert.Nil(t, conn.Begin(TxnWrite))
+				for i := 0; i < 10; i++ {
+					key := uint32((w*batches+b)*10 + i)
+					assert.Nil(t, bt.Insert(key, payloadOf(key)))
+				}
+				assert.Nil(t, conn.Commit())
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := bs.Conn()
+			bt := conn.OpenBtree(root)
+			for i := 0; i < 20; i++ {
+				assert.Nil(t, conn.Begin(TxnRead))
+				keys := collectKeys(t, bt)
+				assert.Nil(t, conn.Commit())
+				assert.Equal(t, 0, len(keys)%10)
+				for j := 1; j < len(keys); j++ {
+					assert.Less(t, keys[j-1], keys[j])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assertKeys(t, bs.Conn().OpenBtree(root), writers*batches*10)
+}
+
+func TestConnReadOnly(t *testing.T) {
+	bs, err := Open("")
+	assert.Nil(t, err)
+	conn := bs.Conn()
+	root, err := conn.CreateBtree(PAGE_DATA | PAGE_LEAF_DATA)
+	assert.Nil(t, err)
+	bt := conn.OpenBtree(root)
+	assert.Nil(t, conn.Begin(TxnRead))
+	assert.Equal(t, ErrorTransactionOpen, conn.Begin(TxnWrite))
+	assert.Equal(t, ErrorReadOnly, bt.Insert(1, payloadOf(1)))
+	assert.Nil(t, conn.Commit())
+	assert.Nil(t, bt.Insert(1, payloadOf(1)))
+	assert.Equal(t, []uint32{1}, collectKeys(t, bt))
+}
+
+func TestConnBusy(t *testing.T) {
+	bs, err := Open("")
+	assert.Nil(t, err)
+	one, two, three := bs.Conn(), bs.Conn(), bs.Conn()
+
+	// the transaction of another connection is waited for until the busy timeout expire
+	assert.Nil(t, one.Begin(TxnWrite))
+	assert.Equal(t, ErrorBusy, two.Begin(TxnRead))
+	bs.SetBusyTimeout(20 * time.Millisecond)
+	start := time.Now()
+	assert.Equal(t, ErrorBusy, two.Begin(TxnRead))
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	bs.SetBusyTimeout(10 * time.Second)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		one.Commit()
+	}()
+	assert.Nil(t, two.Begin(TxnRead))
+
+	// a writer waiting for the readers keep the new readers out
+	began := make(chan error)
+	go func() { began <- one.Begin(TxnWrite) }()
+	time.Sleep(20 * time.Millisecond)
+	three.SetBusyTimeout(0)
+	assert.Equal(t, ErrorBusy, three.Begin(TxnRead))
+	assert.Nil(t, two.Commit())
+	assert.Nil(t, <-began)
+	assert.Nil(t, one.Rollback())
+	assert.Nil(t, three.Begin(TxnRead))
+	assert.Nil(t, three.Commit())
+}
+
+func TestConnDeferred(t *testing.T) {
+	bs, err := Open("")
+	assert.Nil(t, err)
+	root := setupCommitted(t, bs, 10)
+	one, two := bs.Conn(), bs.Conn()
+	bt, otherBt := one.OpenBtree(root), two.OpenBtree(root)
+
+	// a deferred transaction read along with the others until its first write
+	assert.Nil(t, one.Begin(TxnDeferred))
+	assert.Nil(t, two.Begin(TxnRead))
+	assertKeys(t, bt, 10)
+	assert.Equal(t, ErrorBusy, bt.Insert(10, payloadOf(10)))
+	assert.Nil(t, two.Commit())
+	assert.Nil(t, bt.Insert(10, payloadOf(10)))
+	assert.Equal(t, ErrorBusy, two.Begin(TxnRead))
+	assert.Nil(t, one.Commit())
+	assertKeys(t, otherBt, 11)
+
+	// two readers both waiting to write would wait for each other, one of them fail
+	bs.SetBusyTimeout(10 * time.Second)
+	assert.Nil(t, one.Begin(TxnDeferred))
+	assert.Nil(t, two.Begin(TxnDeferred))
+	assertKeys(t, otherBt, 11)
+	inserted := make(chan error)
+	go func() { inserted <- bt.Insert(11, payloadOf(11)) }()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, ErrorBusy, otherBt.Insert(11, payloadOf(11)))
+	assert.Nil(t, two.Rollback())
+	assert.Nil(t, <-inserted)
+	assert.Nil(t, one.Commit())
+	assertKeys(t, otherBt, 12)
+}
+
+func TestConnPanic(t *testing.T) {
+	bs, err := Open("")
+	assert.Nil(t, err)
+	root := setupCommitted(t, bs, 10)
+	conn := bs.Conn()
+	bt := conn.OpenBtree(root)
+
+	// a panic in a transaction of its own roll it back and release the locks
+	assert.Panics(t, func() {
+		conn.Do(true, func() error {
+			assert.Nil(t, bs.OpenBtree(root).Insert(10, payloadOf(10)))
+			panic("boom")
+		})
+	})
+	assert.False(t, conn.InTransaction())
+	assert.Nil(t, conn.Rollback())
+	assertKeys(t, bs.Conn().OpenBtree(root), 10)
+
+	// a panic in an explicit transaction release the latch, the transaction stay open
+	assert.Nil(t, conn.Begin(TxnWrite))
+	assert.Nil(t, bt.Insert(10, payloadOf(10)))
+	assert.Panics(t, func() {
+		conn.Do(true, func() error { panic("boom") })
+	})
+	assert.True(t, conn.InTransaction())
+	assert.Nil(t, conn.Rollback())
+	assertKeys(t, bs.Conn().OpenBtree(root), 10)
+}
+
+func TestCursorSavePosition(t *testing.T) {
+	bs, err := Open("")
+	assert.Nil(t, err)
+	root := setupCommitted(t, bs, 100)
+	one, two := bs.Conn(), bs.Conn()
+	cursor := one.OpenBtree(root).Cursor()
+	defer cursor.Close()
+	bt := two.OpenBtree(root)
+
+	// the cursor find its entry again after the other connection split the pages
+	_, err = cursor.MoveTo(40)
+	assert.Nil(t, err)
+	for i := 1000; i < 3000; i++ {
+		assert.Nil(t, bt.Insert(uint32(i), payloadOf(uint32(i))))
+	}
+	assert.Equal(t, uint32(40), cursor.Key())
+	data, err := cursor.Data()
+	assert.Nil(t, err)
+	assert.Equal(t, payloadOf(40), data)
+	assert.Nil(t, cursor.MoveNext())
+	assert.Equal(t, uint32(41), cursor.Key())
+
+	// the entry is deleted under the cursor, the cursor move on to the next one
+	assert.Nil(t, bt.Delete(41))
+	assert.Nil(t, bt.Delete(42))
+	_, err = cursor.Data()
+	assert.Equal(t, ErrorKeyNotFound, err)
+	assert.Nil(t, cursor.MoveNext())
+	assert.Equal(t, uint32(43), cursor.Key())
+	assert.Nil(t, bt.Delete(43))
+	assert.Nil(t, cursor.MovePrev())
+	assert.Equal(t, uint32(40), cursor.Key())
+
+	// an iterator see the changes ahead of it
+	it, err := one.OpenBtree(root).Iterate(&Bound{IntKey: 90, Inclusive: true}, nil, Forward)
+	assert.Nil(t, err)
+	defer it.Close()
+	assert.Equal(t, uint32(90), it.Key())
+	assert.Nil(t, bt.Delete(91))
+	assert.Nil(t, bt.Delete(92))
+	assert.Nil(t, bt.Insert(91, payloadOf(91)))
+	var keys []uint32
+	for ; err == nil && it.Valid() && it.Key() < 100; err = it.Next() {
+		keys = append(keys, it.Key())
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{90, 91, 93, 94, 95, 96, 97, 98, 99}, keys)
+}
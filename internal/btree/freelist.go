@@ -0,0 +1,147 @@
+package btree
+
+import (
+	"errors"
+	"godb/internal/utils"
+)
+
+// The freelist is a linked list of trunk pages. Each trunk page holds the page
+// numbers of some free leaf pages. The head of the list is recorded in the
+// database header.
+//
+// Freelist trunk page layout:
+//
+// OFFSET	SIZE	DATA
+//    0       4     next trunk page number, 0 if this is the last trunk
+//    4       4     number of leaf page numbers K stored on this trunk
+//    8      4*K    leaf page numbers
+
+// maxTrunkLeaf is the max number of leaf page numbers a trunk page can hold.
+const maxTrunkLeaf = (PageSize - 8) / 4
+
+var (
+	ErrorFreePage = errors.New("page can not be freed")
+)
+
+// allocateFreePage take a page from the freelist. return nil if the freelist is empty.
+func (bs *Shared) allocateFreePage() (*MemPage, error) {
+	if bs.Header.FreelistHead == 0 {
+		return nil, nil
+	}
+	trunk, err := bs.GetPage(bs.Header.FreelistHead, PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+	if err != nil {
+		return nil, err
+	}
+	var pageNo PageNumber
+	numLeaf := utils.GetUint32(trunk.RawData[4:])
+	if numLeaf > 0 {
+		// take the last leaf page out of the trunk
+		if err := trunk.markDirty(); err != nil {
+			return nil, err
+		}
+		pageNo = PageNumber(utils.GetUint32(trunk.RawData[4+4*numLeaf:]))
+		utils.SetUint32(trunk.RawData[4:], numLeaf-1)
+	} else {
+		// the trunk has no leaf, reuse the trunk itself
+		pageNo = trunk.PageNo
+		bs.Header.FreelistHead = PageNumber(utils.GetUint32(trunk.RawData[0:]))
+	}
+	if pageNo == 0 || pageNo == 1 || uint32(pageNo) > bs.NumPage {
+		return nil, ErrorCorruptedPage
+	}
+	bs.Header.FreelistCount--
+	if err := bs.writeHeader(); err != nil {
+		return nil, err
+	}
+	mem, err := bs.GetPage(pageNo, PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+	if err != nil {
+		return nil, err
+	}
+	// the content of a free page is meaningless, the caller need to set it up
+	mem.IsInit = false
+	return mem, nil
+}
+
+// FreePage put the page on the freelist so that AllocateNewPage can reuse it.
+// The caller must guarantee the page is no longer referenced by any btree.
+func (bs *Shared) FreePage(pageNo PageNumber) error {
+	if pageNo <= 1 || uint32(pageNo) > bs.NumPage {
+		return ErrorFreePage
+	}
+	mem, err := bs.GetPage(pageNo, PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+	if err != nil {
+		return err
+	}
+	if bs.Header.FreelistHead != 0 {
+		trunk, err := bs.GetPage(bs.Header.FreelistHead, PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+		if err != nil {
+			return err
+		}
+		numLeaf := utils.GetUint32(trunk.RawData[4:])
+		if numLeaf < maxTrunkLeaf {
+			// there is room on the head trunk, record the page as a leaf
+			if err := trunk.markDirty(); err != nil {
+				return err
+			}
+			utils.SetUint32(trunk.RawData[8+4*numLeaf:], uint32(pageNo))
+			utils.SetUint32(trunk.RawData[4:], numLeaf+1)
+			mem.IsInit = false
+			bs.Header.FreelistCount++
+			return bs.writeHeader()
+		}
+	}
+	// the head trunk is full or missing, the freed page become the new head trunk
+	if err := mem.markDirty(); err != nil {
+		return err
+	}
+	copy(mem.RawData, make([]byte, PageSize))
+	utils.SetUint32(mem.RawData[0:], uint32(bs.Header.FreelistHead))
+	utils.SetUint32(mem.RawData[4:], 0)
+	mem.IsInit = false
+	bs.Header.FreelistHead = pageNo
+	bs.Header.FreelistCount++
+	return bs.writeHeader()
+}
+
+// DropBtree put all the pages of the btree on the freelist, including the root page
+// and the overflow pages. The btree must not be used after it is dropped.
+func (bs *Shared) DropBtree(root PageNumber) error {
+	if root <= 1 {
+		return ErrorFreePage
+	}
+	pages := []PageNumber{root}
+	for len(pages) > 0 {
+		pageNo := pages[len(pages)-1]
+		pages = pages[:len(pages)-1]
+		mem, err := bs.GetPage(pageNo, PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+		if err != nil {
+			return err
+		}
+		if !mem.IsInit {
+			return ErrorCorruptedPage
+		}
+		// everything needed is read from the page before it is reused by the freelist
+		var overflow []Cell
+		for k := uint16(0); k < mem.CellNum; k++ {
+			if !mem.IsLeaf {
+				pages = append(pages, mem.GetKthChild(k))
+			} else if cell := mem.getKthLocalCell(k); cell.OverflowPageNo != 0 {
+				overflow = append(overflow, cell)
+			}
+		}
+		if !mem.IsLeaf {
+			pages = append(pages, mem.GetRightChild())
+		}
+		for _, cell := range overflow {
+			if err := bs.freeOverflow(cell); err != nil {
+				return err
+			}
+		}
+		if err := bs.FreePage(pageNo); err != nil {
+			return err
+		}
+		// no page is held any more, the pages fetched so far can be evicted
+		bs.Pager.GetPageCache().Release()
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package btree
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestFreelistReuse(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	bs, err := Open(fileName)
+	assert.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		_, err := bs.AllocateNewPage()
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, uint32(6), bs.NumPage)
+	assert.Nil(t, bs.FreePage(3))
+	assert.Nil(t, bs.FreePage(5))
+	assert.Equal(t, ErrorFreePage, bs.FreePage(1))
+	assert.Equal(t, uint32(2), bs.Header.FreelistCount)
+	assert.Nil(t, bs.Close())
+
+	bs, err = Open(fileName)
+	assert.Nil(t, err)
+	defer bs.Close()
+	assert.Equal(t, PageNumber(3), bs.Header.FreelistHead)
+	assert.Equal(t, uint32(2), bs.Header.FreelistCount)
+	reused := map[PageNumber]bool{}
+	for i := 0; i < 2; i++ {
+		mem, err := bs.AllocateNewPage()
+		assert.Nil(t, err)
+		reused[mem.PageNo] = true
+	}
+	assert.Equal(t, map[PageNumber]bool{3: true, 5: true}, reused)
+	assert.Equal(t, uint32(0), bs.Header.FreelistCount)
+	assert.Equal(t, PageNumber(0), bs.Header.FreelistHead)
+	mem, err := bs.AllocateNewPage()
+	assert.Nil(t, err)
+	assert.Equal(t, PageNumber(7), mem.PageNo)
+}
+
+func TestFreelistManyTrunks(t *testing.T) {
+	bs, err := Open("")
+	assert.Nil(t, err)
+	n := maxTrunkLeaf + 10
+	for i := 0; i < n; i++ {
+		_, err := bs.AllocateNewPage()
+		assert.Nil(t, err)
+	}
+	for i := 2; i <= n+1; i++ {
+		assert.Nil(t, bs.FreePage(PageNumber(i)))
+	}
+	assert.Equal(t, uint32(n), bs.Header.FreelistCount)
+	for i := 0; i < n; i++ {
+		_, err := bs.AllocateNewPage()
+		assert.Nil(t, err)
+	}
+	// every page comes from the freelist, the database does not grow
+	assert.Equal(t, uint32(n+1), bs.NumPage)
+	assert.Equal(t, uint32(0), bs.Header.FreelistCount)
+}
+
+func TestDropBtree(t *testing.T) {
+	bs, err := Open("")
+	assert.Nil(t, err)
+	defer bs.Close()
+	root, err := bs.CreateBtree(PAGE_DATA | PAGE_LEAF_DATA)
+	assert.Nil(t, err)
+	bt := bs.OpenBtree(root)
+	for i := uint32(0); i < 2000; i++ {
+		payload := payloadOf(i)
+		if i%100 == 0 {
+			// a payload spilled to overflow pages
+			payload = make([]byte, 3*PageSize)
+		}
+		assert.Nil(t, bt.Insert(i, payload))
+	}
+	numPage := bs.NumPage
+	assert.Nil(t, bs.DropBtree(root))
+	// every page but page one is on the freelist
+	assert.Equal(t, numPage-1, bs.Header.FreelistCount)
+	assert.Equal(t, ErrorFreePage, bs.DropBtree(1))
+
+	// the pages are reused by a new btree
+	root, err = bs.CreateBtree(PAGE_DATA | PAGE_LEAF_DATA)
+	assert.Nil(t, err)
+	bt = bs.OpenBtree(root)
+	for i := uint32(0); i < 2000; i++ {
+		assert.Nil(t, bt.Insert(i, payloadOf(i)))
+	}
+	assert.Equal(t, numPage, bs.NumPage)
+	assertKeys(t, bt, 2000)
+}
@@ -0,0 +1,135 @@
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"godb/internal/utils"
+)
+
+// Database header layout, stored in the first 100 bytes of page 1:
+//
+// OFFSET	SIZE	DATA
+//    0      16     magic string "godb format 1\000"
+//   16       2     page size
+//   18       1     file format write version
+//   19       1     file format read version
+//   20       4     number of pages in the database file
+//   24       4     page number of the first freelist trunk page
+//   28       4     number of freelist pages
+//   32       4     schema cookie, changed every time the schema changes
+//   36       4     text encoding
+//   40       1     journal mode
+//   41       3     reserved for expansion, must be zero
+//   44       4     file change counter, increased by every commit
+//   48      52     reserved for expansion, must be zero
+
+// DatabaseHeaderSize is the number of bytes reserved for the database header on page 1.
+const DatabaseHeaderSize = 100
+
+// FormatVersion is the file format version this godb reads and writes.
+const FormatVersion uint8 = 1
+
+// The text encoding of the database.
+const (
+	TextEncodingUTF8 uint32 = 1
+)
+
+// The journal mode of the database.
+const (
+	JournalModeDelete uint8 = 0 // rollback journal, deleted on commit
+	JournalModeWal    uint8 = 1 // write-ahead log
+)
+
+// journalModeOffset is the offset of the journal mode in the database header.
+const journalModeOffset = 40
+
+// changeCounterOffset is the offset of the file change counter in the database header.
+const changeCounterOffset = 44
+
+var magicString = []byte("godb format 1\000")
+
+var (
+	ErrorNotADatabase       = errors.New("file is not a database")
+	ErrorUnsupportedVersion = errors.New("unsupported file format version")
+	ErrorUnsupportedPage    = errors.New("unsupported page size")
+)
+
+// DatabaseHeader is the in memory form of the database header.
+type DatabaseHeader struct {
+	PageSize      uint16     // size of a page in bytes
+	WriteVersion  uint8      // file format write version
+	ReadVersion   uint8      // file format read version
+	NumPage       uint32     // number of pages in the database file
+	FreelistHead  PageNumber // first freelist trunk page, 0 if the freelist is empty
+	FreelistCount uint32     // number of freelist pages
+	SchemaCookie  uint32     // schema cookie
+	TextEncoding  uint32     // text encoding, only TextEncodingUTF8 is supported
+	JournalMode   uint8      // JournalModeDelete or JournalModeWal
+	ChangeCounter uint32     // file change counter
+}
+
+// NewDatabaseHeader return the header of an empty database.
+func NewDatabaseHeader() DatabaseHeader {
+	return DatabaseHeader{
+		PageSize:     PageSize,
+		WriteVersion: FormatVersion,
+		ReadVersion:  FormatVersion,
+		NumPage:      1,
+		TextEncoding: TextEncodingUTF8,
+	}
+}
+
+// ParseHeader decode and validate the database header stored at the beginning of raw.
+func ParseHeader(raw []byte) (DatabaseHeader, error) {
+	var hdr DatabaseHeader
+	if len(raw) < DatabaseHeaderSize || !bytes.Equal(raw[:len(magicString)], magicString) {
+		return hdr, ErrorNotADatabase
+	}
+	hdr.PageSize = utils.GetUint16(raw[16:])
+	hdr.WriteVersion = raw[18]
+	hdr.ReadVersion = raw[19]
+	hdr.NumPage = utils.GetUint32(raw[20:])
+	hdr.FreelistHead = PageNumber(utils.GetUint32(raw[24:]))
+	hdr.FreelistCount = utils.GetUint32(raw[28:])
+	hdr.SchemaCookie = utils.GetUint32(raw[32:])
+	hdr.TextEncoding = utils.GetUint32(raw[36:])
+	hdr.JournalMode = raw[journalModeOffset]
+	hdr.ChangeCounter = changeCounter(raw)
+	if hdr.ReadVersion > FormatVersion || hdr.WriteVersion > FormatVersion {
+		return hdr, ErrorUnsupportedVersion
+	}
+	if hdr.PageSize != PageSize {
+		return hdr, ErrorUnsupportedPage
+	}
+	if hdr.TextEncoding != TextEncodingUTF8 {
+		return hdr, ErrorNotADatabase
+	}
+	if hdr.JournalMode != JournalModeDelete && hdr.JournalMode != JournalModeWal {
+		return hdr, ErrorUnsupportedVersion
+	}
+	return hdr, nil
+}
+
+// Write encode the header into the first DatabaseHeaderSize bytes of raw.
+func (hdr *DatabaseHeader) Write(raw []byte) {
+	copy(raw[:DatabaseHeaderSize], make([]byte, DatabaseHeaderSize))
+	copy(raw, magicString)
+	utils.SetUint16(raw[16:], hdr.PageSize)
+	raw[18] = hdr.WriteVersion
+	raw[19] = hdr.ReadVersion
+	utils.SetUint32(raw[20:], hdr.NumPage)
+	utils.SetUint32(raw[24:], uint32(hdr.FreelistHead))
+	utils.SetUint32(raw[28:], hdr.FreelistCount)
+	utils.SetUint32(raw[32:], hdr.SchemaCookie)
+	utils.SetUint32(raw[36:], hdr.TextEncoding)
+	raw[journalModeOffset] = hdr.JournalMode
+	setChangeCounter(raw, hdr.ChangeCounter)
+}
+
+func changeCounter(raw []byte) uint32 {
+	return utils.GetUint32(raw[changeCounterOffset:])
+}
+
+func setChangeCounter(raw []byte, counter uint32) {
+	utils.SetUint32(raw[changeCounterOffset:], counter)
+}
@@ -0,0 +1,56 @@
+package btree
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	raw := make([]byte, PageSize)
+	hdr := NewDatabaseHeader()
+	hdr.NumPage = 7
+	hdr.FreelistHead = 3
+	hdr.FreelistCount = 2
+	hdr.SchemaCookie = 11
+	hdr.Write(raw)
+	parsed, err := ParseHeader(raw)
+	assert.Nil(t, err)
+	assert.Equal(t, hdr, parsed)
+}
+
+func TestOpenDatabase(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	bs, err := Open(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), bs.NumPage)
+	assert.True(t, bs.PageOne.IsLeaf)
+	assert.Nil(t, bs.Close())
+
+	bs, err = Open(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), bs.Header.NumPage)
+	assert.Equal(t, TextEncodingUTF8, bs.Header.TextEncoding)
+	assert.Equal(t, uint16(DatabaseHeaderSize), bs.PageOne.HeaderOffset)
+	assert.Nil(t, bs.Close())
+}
+
+func TestOpenInvalidDatabase(t *testing.T) {
+	dir := t.TempDir()
+	// a file that is not a godb database
+	fileName := filepath.Join(dir, "garbage.db")
+	assert.Nil(t, os.WriteFile(fileName, make([]byte, PageSize), 0644))
+	_, err := Open(fileName)
+	assert.Equal(t, ErrorNotADatabase, err)
+
+	// a database written by a newer godb
+	fileName = filepath.Join(dir, "newer.db")
+	raw := make([]byte, PageSize)
+	hdr := NewDatabaseHeader()
+	hdr.ReadVersion = FormatVersion + 1
+	hdr.Write(raw)
+	assert.Nil(t, os.WriteFile(fileName, raw, 0644))
+	_, err = Open(fileName)
+	assert.Equal(t, ErrorUnsupportedVersion, err)
+}
@@ -0,0 +1,193 @@
+package btree
+
+// Direction is the order an iterator walk the entries in.
+type Direction int8
+
+const (
+	Forward  Direction = 1  // ascending order of the keys
+	Backward Direction = -1 // descending order of the keys
+)
+
+// Bound is one end of the range of an iterator.
+type Bound struct {
+	Key       []byte // the key in an index btree, nil in a table btree
+	IntKey    uint32 // the key in a table btree
+	Inclusive bool   // true if the entry equal to the key is in the range
+}
+
+// compare compare the entry the cursor point to with the bound, the same as CompareKey.
+func (b *Bound) compare(btc *btCursor) (int8, error) {
+	if b.Key != nil {
+		return btc.CompareBytes(b.Key)
+	}
+	return btc.CompareKey(b.IntKey), nil
+}
+
+// moveTo move the cursor to the bound, the same as MoveTo.
+func (b *Bound) moveTo(btc *btCursor) (int8, error) {
+	if b.Key != nil {
+		return btc.MoveToKey(b.Key)
+	}
+	return btc.MoveTo(b.IntKey)
+}
+
+// Iterator walk the entries of a btree between two bounds, in either direction.
+// The iterator keep its position when the btree is modified meanwhile, the entries
+// inserted after the position are seen and the deleted ones are not.
+//
+//	it, err := bt.Iterate(start, end, Forward)
+//	for ; err == nil && it.Valid(); err = it.Next() {
+//		... it.Key(), it.Data() ...
+//	}
+type Iterator struct {
+	cursor *btCursor
+	start  *Bound // the smaller end of the range, nil if there is no lower bound
+	end    *Bound // the larger end of the range, nil if there is no upper bound
+	dir    Direction
+	done   bool  // true if the iterator has moved out of the range
+	conn   *Conn // the connection of the btree, nil if the btree is used directly
+}
+
+// Iterate return an iterator on the entries from start to end. A nil bound leave the
+// range open at that end. A Forward iterator start from the smallest entry in the
+// range, a Backward iterator start from the largest one.
+func (bt *btree) Iterate(start, end *Bound, dir Direction) (*Iterator, error) {
+	it := &Iterator{cursor: bt.Cursor().(*btCursor), start: start, end: end, dir: dir}
+	if err := it.seek(); err != nil {
+		it.Close()
+		return nil, err
+	}
+	return it, nil
+}
+
+// seek move the cursor to the first entry in the range in the direction.
+func (it *Iterator) seek() error {
+	btc := it.cursor
+	if it.dir == Forward {
+		if it.start == nil {
+			if err := btc.First(); err != nil {
+				return err
+			}
+			return it.check()
+		}
+		loc, err := it.start.moveTo(btc)
+		if err != nil {
+			return err
+		}
+		if err := btc.seekGE(loc); err != nil {
+			return err
+		}
+		if !btc.Eof() && loc == 0 && !it.start.Inclusive {
+			if err := btc.MoveNext(); err != nil {
+				return err
+			}
+		}
+		return it.check()
+	}
+	if it.end == nil {
+		if err := btc.Last(); err != nil {
+			return err
+		}
+		return it.check()
+	}
+	loc, err := it.end.moveTo(btc)
+	if err != nil {
+		return err
+	}
+	if btc.Mem.CellNum == 0 {
+		btc.EOF = true
+		return it.check()
+	}
+	// MoveTo stop on the first entry bigger than the key, or on the last entry
+	// smaller than the key, the entry needed is the last one not bigger than the key
+	if loc > 0 || (loc == 0 && !it.end.Inclusive) {
+		if err := btc.MovePrev(); err != nil {
+			return err
+		}
+	}
+	return it.check()
+}
+
+// check end the iteration if the cursor has moved out of the range.
+func (it *Iterator) check() error {
+	btc := it.cursor
+	if btc.Eof() {
+		it.done = true
+		return nil
+	}
+	if it.dir == Forward && it.end != nil {
+		c, err := it.end.compare(btc)
+		if err != nil {
+			return err
+		}
+		it.done = c > 0 || (c == 0 && !it.end.Inclusive)
+	} else if it.dir == Backward && it.start != nil {
+		c, err := it.start.compare(btc)
+		if err != nil {
+			return err
+		}
+		it.done = c < 0 || (c == 0 && !it.start.Inclusive)
+	}
+	return nil
+}
+
+// Valid return true if the iterator point to an entry in the range.
+func (it *Iterator) Valid() bool {
+	return !it.done
+}
+
+// Next move the iterator to the next entry in the direction.
+func (it *Iterator) Next() error {
+	if it.conn != nil {
+		return it.conn.Do(false, it.next)
+	}
+	return it.next()
+}
+
+func (it *Iterator) next() error {
+	if it.done {
+		return nil
+	}
+	var err error
+	if it.dir == Forward {
+		err = it.cursor.MoveNext()
+	} else {
+		err = it.cursor.MovePrev()
+	}
+	if err != nil {
+		return err
+	}
+	return it.check()
+}
+
+// Key return the integer key of the entry, the rowid in an index btree.
+func (it *Iterator) Key() uint32 {
+	if it.conn != nil {
+		it.conn.enter()
+		defer it.conn.leave()
+	}
+	return it.cursor.Key()
+}
+
+// Data return the payload of the entry, the key in an index btree.
+func (it *Iterator) Data() ([]byte, error) {
+	if it.conn != nil {
+		var data []byte
+		err := it.conn.Do(false, func() error {
+			var err error
+			data, err = it.cursor.Data()
+			return err
+		})
+		return data, err
+	}
+	return it.cursor.Data()
+}
+
+// Close close the cursor of the iterator.
+func (it *Iterator) Close() {
+	if it.conn != nil {
+		it.conn.enter()
+		defer it.conn.leave()
+	}
+	it.cursor.Close()
+}
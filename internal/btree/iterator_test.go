@@ -0,0 +1,111 @@
+package btree
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func iterateKeys(t *testing.T, bt Btree, start, end *Bound, dir Direction) []uint32 {
+	it, err := bt.Iterate(start, end, dir)
+	assert.Nil(t, err)
+	defer it.Close()
+	var keys []uint32
+	for ; err == nil && it.Valid(); err = it.Next() {
+		keys = append(keys, it.Key())
+	}
+	assert.Nil(t, err)
+	return keys
+}
+
+func TestMovePrev(t *testing.T) {
+	bs, err := Open("")
+	assert.Nil(t, err)
+	root, err := bs.CreateBtree(PAGE_DATA | PAGE_LEAF_DATA)
+	assert.Nil(t, err)
+	bt := bs.OpenBtree(root)
+	cursor := bt.Cursor()
+	defer cursor.Close()
+	// an empty btree has no first or last entry
+	assert.Nil(t, cursor.Last())
+	assert.True(t, cursor.Eof())
+	n := 3000
+	for _, i := range rand.New(rand.NewSource(4)).Perm(n) {
+		assert.Nil(t, bt.Insert(uint32(i*2), payloadOf(uint32(i))))
+	}
+	assert.Nil(t, cursor.Last())
+	for i := n - 1; i >= 0; i-- {
+		assert.False(t, cursor.Eof())
+		assert.Equal(t, uint32(i*2), cursor.Key())
+		assert.Nil(t, cursor.MovePrev())
+	}
+	assert.True(t, cursor.Eof())
+	assert.Nil(t, cursor.First())
+	assert.Equal(t, uint32(0), cursor.Key())
+	for i := 0; i < n*2; i += 13 {
+		assert.Nil(t, cursor.SeekGE(uint32(i)))
+		assert.Equal(t, uint32((i+1)/2*2), cursor.Key())
+	}
+	assert.Nil(t, cursor.SeekGE(uint32(n*2)))
+	assert.True(t, cursor.Eof())
+}
+
+func TestIterator(t *testing.T) {
+	bs, err := Open("")
+	assert.Nil(t, err)
+	root, err := bs.CreateBtree(PAGE_DATA | PAGE_LEAF_DATA)
+	assert.Nil(t, err)
+	bt := bs.OpenBtree(root)
+	n := 2000
+	for _, i := range rand.New(rand.NewSource(5)).Perm(n) {
+		assert.Nil(t, bt.Insert(uint32(i*2), payloadOf(uint32(i))))
+	}
+	expect := func(lo, hi uint32, dir Direction) []uint32 {
+		var keys []uint32
+		for key := lo; key <= hi && key < uint32(n*2); key++ {
+			if key%2 == 0 {
+				keys = append(keys, key)
+			}
+		}
+		if dir == Backward {
+			for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+				keys[i], keys[j] = keys[j], keys[i]
+			}
+		}
+		return keys
+	}
+	assert.Equal(t, expect(0, uint32(n*2), Forward), iterateKeys(t, bt, nil, nil, Forward))
+	assert.Equal(t, expect(0, uint32(n*2), Backward), iterateKeys(t, bt, nil, nil, Backward))
+	rnd := rand.New(rand.NewSource(6))
+	for i := 0; i < 200; i++ {
+		lo, hi := uint32(rnd.Intn(n*2+10)), uint32(rnd.Intn(n*2+10)+1)
+		for _, dir := range []Direction{Forward, Backward} {
+			start, end := &Bound{IntKey: lo, Inclusive: true}, &Bound{IntKey: hi, Inclusive: true}
+			assert.Equal(t, expect(lo, hi, dir), iterateKeys(t, bt, start, end, dir))
+			// an exclusive bound leave out the key itself
+			start.Inclusive, end.Inclusive = false, false
+			assert.Equal(t, expect(lo+1, hi-1, dir), iterateKeys(t, bt, start, end, dir))
+			assert.Equal(t, expect(lo, uint32(n*2), dir), iterateKeys(t, bt, &Bound{IntKey: lo, Inclusive: true}, nil, dir))
+			assert.Equal(t, expect(0, hi, dir), iterateKeys(t, bt, nil, &Bound{IntKey: hi, Inclusive: true}, dir))
+		}
+	}
+}
+
+func TestIndexIterator(t *testing.T) {
+	bs, err := Open("")
+	assert.Nil(t, err)
+	root, err := bs.CreateBtree(PAGE_INDEX)
+	assert.Nil(t, err)
+	bt := bs.OpenBtree(root)
+	n := 1000
+	for _, i := range rand.New(rand.NewSource(7)).Perm(n) {
+		assert.Nil(t, bt.InsertKey(indexKeyOf(i), uint32(i)))
+	}
+	start := &Bound{Key: []byte("key-00100"), Inclusive: true}
+	end := &Bound{Key: []byte("key-00200"), Inclusive: true}
+	keys := iterateKeys(t, bt, start, end, Backward)
+	// the key of the entry 200 is bigger than its prefix
+	assert.Equal(t, 100, len(keys))
+	assert.Equal(t, uint32(199), keys[0])
+	assert.Equal(t, uint32(100), keys[99])
+}
@@ -0,0 +1,232 @@
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"godb/internal/utils"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+)
+
+// The rollback journal keeps the original content of every page modified by the
+// current write transaction. The journal is synced before any modified page is
+// written to the database file, and deleted when the transaction commits. A journal
+// found when the database is opened is a hot journal left by a crash, it is played
+// back to restore the database to the state before the crashed transaction.
+//
+// Journal layout:
+//
+// OFFSET	SIZE	DATA
+//    0       8     magic string "godbjrnl"
+//    8       4     number of page records, 0 if the journal is not synced yet
+//   12       4     number of pages in the database before the transaction
+//   16       4     checksum nonce
+//   20       -     page records
+//
+// Page record layout:
+//
+// OFFSET	SIZE	DATA
+//    0       4     page number
+//    4    PageSize original page content
+// 4+PageSize 4     checksum of the page number and the page content
+
+const journalHeaderSize = 20
+const journalRecordSize = PageSize + 8
+
+var journalMagic = []byte("godbjrnl")
+
+var (
+	ErrorCorruptedJournal = errors.New("journal corrupted")
+)
+
+// journalName return the name of the rollback journal of the database file.
+func journalName(fileName string) string {
+	return fileName + "-journal"
+}
+
+// beginWrite start a write transaction if there is no one. Only the process holding
+// the reserved lock can write.
+func (pgr *pager) beginWrite() error {
+	if pgr.InWriteTxn {
+		return nil
+	}
+	if err := pgr.lock(LockReserved); err != nil {
+		return err
+	}
+	pgr.InWriteTxn = true
+	pgr.OrigPageNumber = pgr.PageNumber
+	pgr.Journaled = make(map[PageNumber]bool)
+	pgr.OrigPages = make(map[PageNumber][]byte)
+	pgr.JournalCount = 0
+	return nil
+}
+
+// journalPage save the original content of the page before it is modified.
+// A page beyond the original end of the database does not need to be saved,
+// it is truncated on rollback.
+func (pgr *pager) journalPage(pageNo PageNumber, raw []byte) error {
+	if pgr.Journaled[pageNo] || pageNo > pgr.OrigPageNumber {
+		return nil
+	}
+	if pgr.File == nil {
+		// an in-memory database keeps the original content in memory
+		pgr.OrigPages[pageNo] = append([]byte{}, raw[:PageSize]...)
+		pgr.Journaled[pageNo] = true
+		return nil
+	}
+	if pgr.Journal == nil {
+		if err := pgr.openJournal(); err != nil {
+			return err
+		}
+	}
+	record := make([]byte, journalRecordSize)
+	utils.SetUint32(record[0:], uint32(pageNo))
+	copy(record[4:], raw[:PageSize])
+	utils.SetUint32(record[4+PageSize:], pgr.journalChecksum(record[:4+PageSize]))
+	offset := int64(journalHeaderSize) + int64(pgr.JournalCount)*journalRecordSize
+	if _, err := pgr.Journal.WriteAt(record, offset); err != nil {
+		return err
+	}
+	pgr.JournalCount++
+	pgr.Journaled[pageNo] = true
+	pgr.JournalSynced = false
+	return nil
+}
+
+// openJournal create the journal file and write a header without any record.
+func (pgr *pager) openJournal() error {
+	f, err := os.OpenFile(journalName(pgr.FileName), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	pgr.Journal = f
+	pgr.JournalNonce = rand.Uint32()
+	return pgr.writeJournalHeader(0)
+}
+
+func (pgr *pager) writeJournalHeader(count uint32) error {
+	hdr := make([]byte, journalHeaderSize)
+	copy(hdr, journalMagic)
+	utils.SetUint32(hdr[8:], count)
+	utils.SetUint32(hdr[12:], uint32(pgr.OrigPageNumber))
+	utils.SetUint32(hdr[16:], pgr.JournalNonce)
+	_, err := pgr.Journal.WriteAt(hdr, 0)
+	return err
+}
+
+// syncJournal make the journal durable. It must be called before any page of the
+// current transaction is written to the database file.
+func (pgr *pager) syncJournal() error {
+	if pgr.Journal == nil || pgr.JournalSynced {
+		return nil
+	}
+	// the records are synced before the header so that a valid record count
+	// never refers to a record that is not on the disk
+	if err := pgr.Journal.Sync(); err != nil {
+		return err
+	}
+	if err := pgr.writeJournalHeader(pgr.JournalCount); err != nil {
+		return err
+	}
+	if err := pgr.Journal.Sync(); err != nil {
+		return err
+	}
+	pgr.JournalSynced = true
+	return nil
+}
+
+func (pgr *pager) journalChecksum(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data) ^ pgr.JournalNonce
+}
+
+// endWrite finish the write transaction and delete the journal. The savepoints end
+// with the transaction, and the lock go back to shared.
+func (pgr *pager) endWrite() error {
+	pgr.InWriteTxn = false
+	pgr.Savepoints = nil
+	pgr.Journaled = nil
+	pgr.OrigPages = nil
+	pgr.JournalCount = 0
+	pgr.JournalSynced = false
+	if pgr.Journal != nil {
+		err := pgr.Journal.Close()
+		pgr.Journal = nil
+		if err != nil {
+			return err
+		}
+		// deleting the journal is the commit point of the transaction
+		if err := os.Remove(journalName(pgr.FileName)); err != nil {
+			return err
+		}
+	}
+	return pgr.unlock(LockShared)
+}
+
+// playbackJournal write the original pages in the journal back to the database file
+// and truncate the database to its original size. A journal without a valid record
+// count was never synced, thus the database file was not modified.
+func (pgr *pager) playbackJournal(journal *os.File) error {
+	hdr := make([]byte, journalHeaderSize)
+	n, err := journal.ReadAt(hdr, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n < journalHeaderSize || !bytes.Equal(hdr[:8], journalMagic) {
+		return nil
+	}
+	count := utils.GetUint32(hdr[8:])
+	if count == 0 {
+		return nil
+	}
+	origPageNumber := PageNumber(utils.GetUint32(hdr[12:]))
+	nonce := pgr.JournalNonce
+	pgr.JournalNonce = utils.GetUint32(hdr[16:])
+	defer func() { pgr.JournalNonce = nonce }()
+	record := make([]byte, journalRecordSize)
+	for i := uint32(0); i < count; i++ {
+		offset := int64(journalHeaderSize) + int64(i)*journalRecordSize
+		if _, err := journal.ReadAt(record, offset); err != nil {
+			return ErrorCorruptedJournal
+		}
+		pageNo := PageNumber(utils.GetUint32(record[0:]))
+		if utils.GetUint32(record[4+PageSize:]) != pgr.journalChecksum(record[:4+PageSize]) {
+			return ErrorCorruptedJournal
+		}
+		if err := pgr.writePage(pageNo, record[4:4+PageSize]); err != nil {
+			return err
+		}
+	}
+	if err := pgr.File.Truncate(int64(origPageNumber) * PageSize); err != nil {
+		return err
+	}
+	pgr.FileSize = int64(origPageNumber) * PageSize
+	pgr.PageNumber = origPageNumber
+	return pgr.File.Sync()
+}
+
+// recoverHotJournal play back the journal left by a crashed transaction, if any.
+// The caller hold the shared lock, the lock is exclusive while the journal is played back.
+func (pgr *pager) recoverHotJournal() error {
+	if hot, err := pgr.isHotJournal(); err != nil || !hot {
+		return err
+	}
+	if err := pgr.waitLock(LockExclusive); err != nil {
+		return err
+	}
+	defer pgr.unlock(LockShared)
+	// another process may have played it back meanwhile
+	journal, err := os.Open(journalName(pgr.FileName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	err = pgr.playbackJournal(journal)
+	journal.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(journalName(pgr.FileName))
+}
@@ -0,0 +1,98 @@
+package btree
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupCommitted create a table with n committed entries and return its root page.
+func setupCommitted(t *testing.T, bs *Shared, n int) PageNumber {
+	root, err := bs.CreateBtree(PAGE_DATA | PAGE_LEAF_DATA)
+	assert.Nil(t, err)
+	bt := bs.OpenBtree(root)
+	for i := 0; i < n; i++ {
+		assert.Nil(t, bt.Insert(uint32(i), payloadOf(uint32(i))))
+	}
+	assert.Nil(t, bs.Commit())
+	return root
+}
+
+// modify insert and delete entries without commit.
+func modify(t *testing.T, bt Btree, n int) {
+	for i := n; i < n+2000; i++ {
+		assert.Nil(t, bt.Insert(uint32(i), payloadOf(uint32(i))))
+	}
+	for i := 0; i < n; i += 2 {
+		assert.Nil(t, bt.Delete(uint32(i)))
+	}
+}
+
+// crash close the database file as if the process died, its locks are gone.
+func crash(t *testing.T, pgr *pager) {
+	inodesMu.Lock()
+	for i, node := range inodes {
+		if node == pgr.Inode {
+			inodes = append(inodes[:i], inodes[i+1:]...)
+			break
+		}
+	}
+	inodesMu.Unlock()
+	assert.Nil(t, pgr.File.Close())
+}
+
+func assertKeys(t *testing.T, bt Btree, n int) {
+	keys := collectKeys(t, bt)
+	assert.Equal(t, n, len(keys))
+	for i, key := range keys {
+		assert.Equal(t, uint32(i), key)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	for _, fileName := range []string{filepath.Join(t.TempDir(), "test.db"), ""} {
+		bs, err := Open(fileName)
+		assert.Nil(t, err)
+		root := setupCommitted(t, bs, 100)
+		numPage := bs.NumPage
+		bt := bs.OpenBtree(root)
+		modify(t, bt, 100)
+		assert.NotEqual(t, numPage, bs.NumPage)
+		assert.Nil(t, bs.Rollback())
+		assert.Equal(t, numPage, bs.NumPage)
+		assertKeys(t, bt, 100)
+		if fileName != "" {
+			_, err := os.Stat(journalName(fileName))
+			assert.True(t, os.IsNotExist(err))
+		}
+		assert.Nil(t, bs.Close())
+	}
+}
+
+func TestHotJournal(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	bs, err := Open(fileName)
+	assert.Nil(t, err)
+	root := setupCommitted(t, bs, 100)
+	modify(t, bs.OpenBtree(root), 100)
+
+	// crash in the middle of commit: the journal is synced and some of the
+	// dirty pages are written, but the journal is not deleted.
+	pgr := bs.Pager.(*pager)
+	assert.Nil(t, pgr.syncJournal())
+	for i, pce := range pgr.PageCache.DirtyPages() {
+		if i%2 == 0 {
+			assert.Nil(t, pgr.writePage(pce.PageNo, pce.Data.RawData))
+		}
+	}
+	assert.Nil(t, pgr.Journal.Close())
+	crash(t, pgr)
+
+	bs, err = Open(fileName)
+	assert.Nil(t, err)
+	defer bs.Close()
+	assertKeys(t, bs.OpenBtree(root), 100)
+	_, err = os.Stat(journalName(fileName))
+	assert.True(t, os.IsNotExist(err))
+}
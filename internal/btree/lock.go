@@ -0,0 +1,373 @@
+package btree
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Several processes may open the same database file. They agree on who reads and
+// who writes with advisory fcntl locks on a few bytes far beyond the data, those
+// bytes are never read or written. The lock of a pager goes through these levels:
+//
+//	LockNone      the pager hold no lock and must not read the database file
+//	LockShared    any number of readers
+//	LockReserved  one writer preparing its changes, the readers still come and go
+//	LockPending   the writer wait for the readers to leave, no new reader come
+//	LockExclusive the writer write the database file, there is no reader at all
+//
+// A reader take a read lock on the pending byte before it take the read lock on the
+// shared range, thus no reader can start while a writer hold the pending byte. The
+// reserved byte is write locked by the writer. The exclusive lock is a write lock on
+// the whole shared range.
+//
+// The fcntl locks belong to the process, not to the file descriptor. Two pagers of
+// the same process on the same file would not block each other, and closing any of
+// them would release the locks of both. The pagers of a process thus share an inode
+// per database file, whatever the name the file is opened by. The inode hold the lock
+// of the process: the fcntl locks are taken by the first reader and released by the
+// last one, and a single pager at a time hold a lock above LockShared. The file of a
+// closed pager is kept open until the process hold no lock on the database file.
+
+// LockLevel is the level of the lock a pager hold on the database file.
+type LockLevel uint8
+
+const (
+	LockNone LockLevel = iota
+	LockShared
+	LockReserved
+	LockPending
+	LockExclusive
+)
+
+// the offsets of the lock bytes in the database file
+const (
+	pendingByte  = 0x40000000
+	reservedByte = pendingByte + 1
+	sharedFirst  = pendingByte + 2
+	sharedSize   = 510
+)
+
+// the types of the byte range locks
+const (
+	lockUnlock = iota
+	lockRead
+	lockWrite
+)
+
+// maxBusyDelay is the longest sleep between two attempts to take a busy lock.
+const maxBusyDelay = 100 * time.Millisecond
+
+var (
+	ErrorBusy = errors.New("database is locked")
+)
+
+// inode is the lock of the process on a database file.
+type inode struct {
+	info   os.FileInfo // identify the database file
+	refs   int         // number of pagers open on the file
+	shared int         // number of pagers holding at least LockShared
+	level  LockLevel   // the highest lock held by a pager of the process
+	unused []*os.File  // files of the closed pagers, closed once no lock is held
+}
+
+var (
+	inodesMu sync.Mutex // protect inodes and the content of every inode
+	inodes   []*inode
+)
+
+// openInode return the inode of the database file, it is shared by all the pagers of
+// the process.
+func openInode(info os.FileInfo) *inode {
+	inodesMu.Lock()
+	defer inodesMu.Unlock()
+	for _, node := range inodes {
+		if os.SameFile(node.info, info) {
+			node.refs++
+			return node
+		}
+	}
+	node := &inode{info: info, refs: 1}
+	inodes = append(inodes, node)
+	return node
+}
+
+// closeFile release the lock and close the database file. The file is kept open while
+// another pager of the process hold a lock, closing it would release the lock.
+func (pgr *pager) closeFile() error {
+	err := pgr.unlock(LockNone)
+	f, node := pgr.File, pgr.Inode
+	pgr.File, pgr.Inode = nil, nil
+	inodesMu.Lock()
+	defer inodesMu.Unlock()
+	node.refs--
+	if node.shared > 0 {
+		node.unused = append(node.unused, f)
+		return err
+	}
+	if node.refs == 0 {
+		for i, other := range inodes {
+			if other == node {
+				inodes = append(inodes[:i], inodes[i+1:]...)
+				break
+			}
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// lock raise the lock on the database file to level. The first lock after LockNone
+// play back a hot journal and drop the cached pages if another process changed the
+// database meanwhile. A busy lock is retried until the busy timeout expire.
+func (pgr *pager) lock(level LockLevel) error {
+	if pgr.File == nil || pgr.Locked >= level {
+		return nil
+	}
+	if pgr.Locked == LockNone {
+		if err := pgr.waitLock(LockShared); err != nil {
+			return err
+		}
+		if err := pgr.recoverHotJournal(); err != nil {
+			pgr.unlock(LockNone)
+			return err
+		}
+		if err := pgr.refresh(); err != nil {
+			pgr.unlock(LockNone)
+			return err
+		}
+	}
+	if pgr.Locked >= level {
+		return nil
+	}
+	wasReserved := pgr.Locked >= LockReserved
+	if err := pgr.waitLock(level); err != nil {
+		return err
+	}
+	// in WAL mode a writer commit while the readers hold their shared lock. A reader
+	// whose snapshot is older than the log can not write, its changes would be lost.
+	if !wasReserved && pgr.Wal != nil {
+		changed, err := pgr.walChanged()
+		if err == nil && changed {
+			err = ErrorBusy
+		}
+		if err != nil {
+			pgr.unlock(LockShared)
+			return err
+		}
+	}
+	return nil
+}
+
+// waitLock raise the lock to level, it sleep and try again while the lock is busy.
+func (pgr *pager) waitLock(level LockLevel) error {
+	deadline := time.Now().Add(pgr.BusyTimeout)
+	delay := time.Millisecond
+	for {
+		ok, err := pgr.tryLock(level)
+		if ok || err != nil {
+			return err
+		}
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			return ErrorBusy
+		}
+		if delay > remain {
+			delay = remain
+		}
+		time.Sleep(delay)
+		if delay < maxBusyDelay {
+			delay *= 2
+		}
+	}
+}
+
+// tryLock raise the lock to level without waiting. return false if another process,
+// or another pager of the process, hold a conflicting lock. The levels reached before
+// the conflict are kept.
+func (pgr *pager) tryLock(level LockLevel) (bool, error) {
+	f, node := pgr.File, pgr.Inode
+	inodesMu.Lock()
+	defer inodesMu.Unlock()
+	// another pager of the process is a writer, it let the readers in until it wait
+	// for the exclusive lock
+	if node.level > LockShared && pgr.Locked <= LockShared && (level > LockShared || node.level >= LockPending) {
+		return false, nil
+	}
+	if pgr.Locked == LockNone {
+		if node.shared == 0 {
+			if ok, err := setLock(f, lockRead, pendingByte, 1); !ok {
+				return false, err
+			}
+			ok, err := setLock(f, lockRead, sharedFirst, sharedSize)
+			if _, unlockErr := setLock(f, lockUnlock, pendingByte, 1); err == nil {
+				err = unlockErr
+			}
+			if !ok || err != nil {
+				return false, err
+			}
+			node.level = LockShared
+		}
+		node.shared++
+		pgr.Locked = LockShared
+	}
+	if level >= LockReserved && pgr.Locked < LockReserved {
+		if ok, err := setLock(f, lockWrite, reservedByte, 1); !ok {
+			return false, err
+		}
+		pgr.Locked, node.level = LockReserved, LockReserved
+	}
+	if level >= LockPending && pgr.Locked < LockPending {
+		if ok, err := setLock(f, lockWrite, pendingByte, 1); !ok {
+			return false, err
+		}
+		pgr.Locked, node.level = LockPending, LockPending
+	}
+	if level >= LockExclusive && pgr.Locked < LockExclusive {
+		// the other pagers of the process are still reading
+		if node.shared > 1 {
+			return false, nil
+		}
+		if ok, err := setLock(f, lockWrite, sharedFirst, sharedSize); !ok {
+			return false, err
+		}
+		pgr.Locked, node.level = LockExclusive, LockExclusive
+	}
+	return true, nil
+}
+
+// unlock lower the lock to LockShared or LockNone. The fcntl locks are released with
+// the last reader of the process.
+func (pgr *pager) unlock(level LockLevel) error {
+	if pgr.File == nil || pgr.Locked <= level {
+		return nil
+	}
+	f, node := pgr.File, pgr.Inode
+	inodesMu.Lock()
+	defer inodesMu.Unlock()
+	var err error
+	if pgr.Locked > LockShared {
+		if pgr.Locked == LockExclusive {
+			_, err = setLock(f, lockRead, sharedFirst, sharedSize)
+		}
+		// the pending byte and the reserved byte
+		if _, unlockErr := setLock(f, lockUnlock, pendingByte, 2); err == nil {
+			err = unlockErr
+		}
+		node.level = LockShared
+	}
+	if level == LockNone {
+		node.shared--
+		if node.shared == 0 {
+			if _, unlockErr := setLock(f, lockUnlock, pendingByte, sharedFirst+sharedSize-pendingByte); err == nil {
+				err = unlockErr
+			}
+			node.level = LockNone
+			for _, unused := range node.unused {
+				unused.Close()
+			}
+			node.unused = nil
+		}
+	}
+	pgr.Locked = level
+	return err
+}
+
+// Lock raise the lock on the database file to level.
+func (pgr *pager) Lock(level LockLevel) error {
+	return pgr.lock(level)
+}
+
+// Unlock release the lock on the database file. The lock of an open write transaction
+// is kept until the transaction ends.
+func (pgr *pager) Unlock() error {
+	if pgr.InWriteTxn {
+		return nil
+	}
+	return pgr.unlock(LockNone)
+}
+
+// SetBusyTimeout set how long a busy lock is retried before ErrorBusy is returned.
+func (pgr *pager) SetBusyTimeout(timeout time.Duration) {
+	pgr.BusyTimeout = timeout
+}
+
+// refresh drop the cached pages if another process committed since this pager last
+// held a lock, every commit increase the change counter in the database header.
+func (pgr *pager) refresh() error {
+	info, err := pgr.File.Stat()
+	if err != nil {
+		return err
+	}
+	pgr.FileSize = info.Size()
+	if pgr.Wal != nil {
+		changed, err := pgr.walChanged()
+		if err != nil {
+			return err
+		}
+		if changed {
+			// the log may have been deleted and created again, it is opened again
+			err := pgr.Wal.Close()
+			pgr.Wal = nil
+			if err != nil {
+				return err
+			}
+			pgr.WalPageNumber = 0
+			if err := pgr.openWal(); err != nil {
+				return err
+			}
+		}
+	}
+	mem, err := pgr.readPage(1)
+	if err != nil {
+		return err
+	}
+	counter := changeCounter(mem.RawData)
+	if counter == pgr.ChangeCounter {
+		return nil
+	}
+	pgr.ChangeCounter = counter
+	pgr.PageCache.Truncate(0)
+	pgr.PageNumber = PageNumber(pgr.FileSize / PageSize)
+	if pgr.Wal != nil && pgr.WalFrames > 0 {
+		pgr.PageNumber = pgr.WalPageNumber
+	}
+	return nil
+}
+
+// bumpChangeCounter increase the change counter in the database header, so that the
+// other processes know their cached pages are stale.
+func (pgr *pager) bumpChangeCounter() error {
+	pce, err := pgr.FetchPage(1, PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+	if err != nil {
+		return err
+	}
+	if err := pgr.Write(1); err != nil {
+		return err
+	}
+	pgr.ChangeCounter = changeCounter(pce.Data.RawData) + 1
+	setChangeCounter(pce.Data.RawData, pgr.ChangeCounter)
+	return nil
+}
+
+// isHotJournal return true if the journal is left by a crashed writer. The journal
+// of a live writer is protected by its reserved lock, the writer may be another pager
+// of the process.
+func (pgr *pager) isHotJournal() (bool, error) {
+	if _, err := os.Stat(journalName(pgr.FileName)); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	inodesMu.Lock()
+	reserved := pgr.Inode.level >= LockReserved
+	inodesMu.Unlock()
+	if reserved {
+		return false, nil
+	}
+	reserved, err := lockedByOther(pgr.File, lockWrite, reservedByte, 1)
+	return !reserved, err
+}
@@ -0,0 +1,170 @@
+//go:build unix
+
+package btree
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestLockHelper run in the child process started by startLocker. It optionally
+// commit one more entry into a table, then hold a lock on the database file until
+// its standard input is closed.
+func TestLockHelper(t *testing.T) {
+	fileName := os.Getenv("GODB_LOCK_FILE")
+	if fileName == "" {
+		t.Skip("run by startLocker only")
+	}
+	bs, err := Open(fileName)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer bs.Close()
+	if root, _ := strconv.Atoi(os.Getenv("GODB_LOCK_INSERT")); root != 0 {
+		bt := bs.OpenBtree(PageNumber(root))
+		err = bs.Lock(LockShared)
+		if err == nil {
+			key := uint32(len(collectKeys(t, bt)))
+			err = bt.Insert(key, payloadOf(key))
+		}
+		if err == nil {
+			err = bs.Commit()
+		}
+		if err == nil {
+			err = bs.Unlock()
+		}
+	}
+	if err == nil {
+		level, _ := strconv.Atoi(os.Getenv("GODB_LOCK_LEVEL"))
+		err = bs.Lock(LockLevel(level))
+	}
+	fmt.Println("locked", err)
+	io.Copy(io.Discard, os.Stdin)
+}
+
+// startLocker start a child process holding the lock on the database file, the lock
+// is released by the returned function.
+func startLocker(t *testing.T, fileName string, level LockLevel, env ...string) func() {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelper$")
+	cmd.Env = append(os.Environ(), "GODB_LOCK_FILE="+fileName, fmt.Sprintf("GODB_LOCK_LEVEL=%d", level))
+	cmd.Env = append(cmd.Env, env...)
+	stdin, err := cmd.StdinPipe()
+	assert.Nil(t, err)
+	stdout, err := cmd.StdoutPipe()
+	assert.Nil(t, err)
+	assert.Nil(t, cmd.Start())
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "locked <nil>\n", line)
+	return func() {
+		stdin.Close()
+		cmd.Wait()
+	}
+}
+
+func TestLocking(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	bs, err := Open(fileName)
+	assert.Nil(t, err)
+	defer bs.Close()
+	root := setupCommitted(t, bs, 100)
+	assert.Nil(t, bs.Unlock())
+	pgr := bs.Pager.(*pager)
+	assert.Equal(t, LockNone, pgr.Locked)
+
+	// a reader let the others read and prepare their changes, but not commit
+	stop := startLocker(t, fileName, LockShared)
+	assert.Nil(t, bs.Lock(LockShared))
+	bt := bs.OpenBtree(root)
+	assertKeys(t, bt, 100)
+	assert.Nil(t, bt.Insert(100, payloadOf(100)))
+	assert.Equal(t, LockReserved, pgr.Locked)
+	assert.Equal(t, ErrorBusy, bs.Commit())
+	// the commit wait for the reader to leave within the busy timeout
+	bs.SetBusyTimeout(10 * time.Second)
+	go func(stop func()) {
+		time.Sleep(50 * time.Millisecond)
+		stop()
+	}(stop)
+	assert.Nil(t, bs.Commit())
+	assert.Equal(t, LockShared, pgr.Locked)
+	assert.Nil(t, bs.Unlock())
+	bs.SetBusyTimeout(0)
+
+	// a writer keep the other writers out, but not the readers
+	stop = startLocker(t, fileName, LockReserved)
+	assert.Nil(t, bs.Lock(LockShared))
+	assertKeys(t, bt, 101)
+	assert.Equal(t, ErrorBusy, bt.Insert(101, payloadOf(101)))
+	assert.Nil(t, bs.Rollback())
+	assert.Nil(t, bs.Unlock())
+	stop()
+
+	// a writer waiting for the exclusive lock keep the new readers out
+	stop = startLocker(t, fileName, LockPending)
+	assert.Equal(t, ErrorBusy, bs.Lock(LockShared))
+	stop()
+	stop = startLocker(t, fileName, LockExclusive)
+	assert.Equal(t, ErrorBusy, bs.Lock(LockShared))
+	stop()
+
+	// the pages cached before another process commit are read again
+	stop = startLocker(t, fileName, LockNone, fmt.Sprintf("GODB_LOCK_INSERT=%d", root))
+	stop()
+	assert.Nil(t, bs.Lock(LockShared))
+	assertKeys(t, bs.OpenBtree(root), 102)
+	assert.Nil(t, bs.Unlock())
+}
+
+func TestLockingInProcess(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "test.db")
+	bs, err := Open(fileName)
+	assert.Nil(t, err)
+	defer bs.Close()
+	root := setupCommitted(t, bs, 100)
+	assert.Nil(t, bs.Unlock())
+
+	// a writer of the process keep the other writers out, its journal is not hot
+	bt := bs.OpenBtree(root)
+	assert.Nil(t, bs.Lock(LockShared))
+	assert.Nil(t, bt.Insert(100, payloadOf(100)))
+	// the same file opened by another name is locked as well
+	link := filepath.Join(dir, "link.db")
+	assert.Nil(t, os.Symlink(fileName, link))
+	other, err := Open(link)
+	assert.Nil(t, err)
+	defer other.Close()
+	otherBt := other.OpenBtree(root)
+	assert.Nil(t, other.Lock(LockShared))
+	assertKeys(t, otherBt, 100)
+	assert.Equal(t, ErrorBusy, otherBt.Insert(100, payloadOf(100)))
+	assert.Nil(t, other.Rollback())
+	_, err = os.Stat(journalName(fileName))
+	assert.Nil(t, err)
+
+	// the commit wait for the reader to leave
+	assert.Equal(t, ErrorBusy, bs.Commit())
+	assert.Nil(t, other.Unlock())
+	assert.Nil(t, bs.Commit())
+	assert.Nil(t, other.Lock(LockShared))
+	assertKeys(t, otherBt, 101)
+
+	// a closed pager keep the file open while the process hold a lock on it
+	closed, err := Open(fileName)
+	assert.Nil(t, err)
+	assert.Nil(t, closed.Close())
+	assert.Equal(t, 1, len(other.Pager.(*pager).Inode.unused))
+	assert.Nil(t, other.Unlock())
+	assert.Nil(t, bs.Unlock())
+	assert.Empty(t, other.Pager.(*pager).Inode.unused)
+}
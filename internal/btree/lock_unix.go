@@ -0,0 +1,36 @@
+//go:build unix
+
+package btree
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+var lockTypes = [...]int16{
+	lockUnlock: syscall.F_UNLCK,
+	lockRead:   syscall.F_RDLCK,
+	lockWrite:  syscall.F_WRLCK,
+}
+
+// setLock set a byte range lock on the file without waiting. return false if another
+// process hold a conflicting lock.
+func setLock(f *os.File, typ int, start, length int64) (bool, error) {
+	lk := syscall.Flock_t{Type: lockTypes[typ], Whence: io.SeekStart, Start: start, Len: length}
+	err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lk)
+	if err == syscall.EAGAIN || err == syscall.EACCES {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// lockedByOther return true if another process hold a lock on the byte range that
+// conflict with a lock of the type.
+func lockedByOther(f *os.File, typ int, start, length int64) (bool, error) {
+	lk := syscall.Flock_t{Type: lockTypes[typ], Whence: io.SeekStart, Start: start, Len: length}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_GETLK, &lk); err != nil {
+		return false, err
+	}
+	return lk.Type != syscall.F_UNLCK, nil
+}
@@ -3,6 +3,7 @@ package btree
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"godb/internal/utils"
 )
 
@@ -30,7 +31,7 @@ const (
 //    1       2     number of cells
 //    3       2     first free block
 //    5       2     cell content offset
-//    7       1     reserved
+//    7       1     number of fragmented free bytes
 //    8       4     right child page number. only used in non-leaf page
 
 // MemPage is  page in memory
@@ -48,23 +49,45 @@ type MemPage struct {
 	CellContentOffset uint16     // offset for cell content, only meaningful for leaf page
 	FreeBytes         uint16     // free bytes in this page
 	OverflowCell      []Cell     // array store overflow cell
+	OverflowIndex     []uint16   // the cell index each overflow cell should be inserted at
 	BShared           *Shared    // the btree shared content the MemPage belong to
 }
 
+// Cell layout:
+//
+// OFFSET	SIZE	DATA
+//    0       4     left child page number
+//    4       4     payload size, exclude the key
+//    8       4     key
+//   12       L     local payload, L = min(payload size, maxLocal)
+//  12+L      4     first overflow page number, only exist if payload size > maxLocal
+
 // Cell is an in memory cell
 type Cell struct {
 	LeftChildPageNo PageNumber // left child page number
-	PayloadSize     uint16     // the payload size, exclude the key
+	PayloadSize     uint32     // the payload size, exclude the key
 	Key             uint32     // key
 	RawData         []byte     // pointer to the cell itself
 	Payload         []byte     // pointer to payload
+	OverflowPageNo  PageNumber // first overflow page, 0 if the whole payload is on the page
 }
 
+// cellHeaderSize is the size of the fixed part of a cell: left child, payload size and key.
+const cellHeaderSize = 12
+
+// maxCellSize is the max size of a cell. It guarantees any page, including page one,
+// can hold at least four cells so that a page split always succeeds.
+const maxCellSize = (PageSize-DatabaseHeaderSize-12)/4 - 2
+
+var (
+	ErrorPayloadTooLarge = errors.New("payload too large")
+)
+
 func NewCell(key uint32, payload []byte) Cell {
 	var cell Cell
 	cell.LeftChildPageNo = 0
 	cell.Key = key
-	cell.PayloadSize = uint16(len(payload))
+	cell.PayloadSize = uint32(len(payload))
 	cell.Payload = payload
 	return cell
 }
@@ -84,15 +107,9 @@ func setFlags(mem *MemPage, flag uint8) error {
 	if !checkFlags(flag) {
 		return ErrorInvalidFlags
 	}
-	if (flag & PAGE_DATA) > 0 {
-		mem.IsDataPage = true
-	}
-	if (flag & PAGE_LEAF_DATA) > 0 {
-		mem.IsDataLeaf = true
-	}
-	if (flag & PAGE_LEAF) > 0 {
-		mem.IsLeaf = true
-	}
+	mem.IsDataPage = (flag & PAGE_DATA) > 0
+	mem.IsLeaf = (flag & PAGE_LEAF) > 0
+	mem.IsDataLeaf = mem.IsDataPage && mem.IsLeaf
 	return nil
 }
 
@@ -105,11 +122,32 @@ func NewZeroPage(pageNo PageNumber) (*MemPage, error) {
 	return mem, nil
 }
 
+// markDirty tell the pager that the page is going to be modified.
+// a page that is not bound to a btree is not managed by any pager.
+func (mem *MemPage) markDirty() error {
+	if mem.BShared == nil || mem.BShared.Pager == nil {
+		return nil
+	}
+	return mem.BShared.Pager.Write(mem.PageNo)
+}
+
 // ComputeFreeBytes will set the FreeBytes field of the MemPage
+// the free bytes include the gap between cell index and cell content, and all the free blocks.
 func (mem *MemPage) ComputeFreeBytes() error {
 	top := mem.CellContentOffset
 	cellLast := mem.CellIndexOffset + 2*mem.CellNum
+	if cellLast > top {
+		return ErrorCorruptedPage
+	}
 	mem.FreeBytes = top - cellLast
+	freePointer := utils.GetUint16(mem.RawData[mem.HeaderOffset+3:])
+	for freePointer != 0 {
+		if freePointer < top || freePointer > PageSize-4 {
+			return ErrorCorruptedPage
+		}
+		mem.FreeBytes += utils.GetUint16(mem.RawData[freePointer+2:])
+		freePointer = utils.GetUint16(mem.RawData[freePointer:])
+	}
 	return nil
 }
 
@@ -122,17 +160,20 @@ func (mem *MemPage) ZeroPage(flags uint8) error {
 	if err != nil {
 		return err
 	}
+	if err := mem.markDirty(); err != nil {
+		return err
+	}
 	hdr := mem.HeaderOffset
-	mem.RawData[hdr] = flags
 	// clean raw data
 	copy(mem.RawData[hdr:], make([]byte, 4096))
+	mem.RawData[hdr] = flags
 
 	var first = hdr
 	if mem.IsLeaf {
-		// leaf page has 4 bytes right child PageNumber in page header
-		first += 12
-	} else {
 		first += 8
+	} else {
+		// non-leaf page has 4 bytes right child PageNumber in page header
+		first += 12
 	}
 	mem.CellIndexOffset = first
 	mem.CellContentOffset = 4096
@@ -142,6 +183,7 @@ func (mem *MemPage) ZeroPage(flags uint8) error {
 	utils.SetUint16(mem.RawData[hdr+5:], 4096)
 	mem.FreeBytes = 4096 - first
 	mem.OverflowCell = []Cell{}
+	mem.OverflowIndex = []uint16{}
 	mem.CellNum = 0
 	mem.IsInit = true
 	return nil
@@ -159,22 +201,14 @@ func (mem *MemPage) InitMemPage() error {
 	}
 	// check and init the flags
 	flags := mem.RawData[mem.HeaderOffset]
-	if !checkFlags(flags) {
+	if err := setFlags(mem, flags); err != nil {
 		return ErrorCorruptedPage
 	}
-	if (flags & PAGE_DATA) > 0 {
-		mem.IsDataPage = true
-	}
-	if (flags & PAGE_LEAF) > 0 {
-		mem.IsLeaf = true
-	} else {
-		// a leaf page header contains 4 bytes right child PageNumber
+	mem.CellIndexOffset = mem.HeaderOffset + 8
+	if !mem.IsLeaf {
+		// a non-leaf page header contains 4 bytes right child PageNumber
 		mem.CellIndexOffset += 4
 	}
-	if mem.IsDataPage && mem.IsLeaf {
-		mem.IsDataLeaf = true
-	}
-	mem.CellIndexOffset += mem.HeaderOffset + 8
 	mem.CellContentOffset = utils.GetUint16(mem.RawData[mem.HeaderOffset+5:])
 	mem.CellNum = utils.GetUint16(mem.RawData[mem.HeaderOffset+1:])
 	mem.IsInit = true
@@ -189,6 +223,9 @@ func CopyMemPage(dst *MemPage, src *MemPage) error {
 	if dst.PageNo == 1 {
 		toHeaderOffset += 100
 	}
+	if err := dst.markDirty(); err != nil {
+		return err
+	}
 	// copy the cellContent, page header and cellIndex array from src to dst
 	copy(dst.RawData[cellContentOffset:], src.RawData[cellContentOffset:])
 	copy(dst.RawData[toHeaderOffset:], src.RawData[fromHeaderOffset:src.CellIndexOffset+2*src.CellNum])
@@ -206,20 +243,36 @@ func CopyMemPage(dst *MemPage, src *MemPage) error {
 	return nil
 }
 
-// FindFreeSpace find a space bigger enough to hold at least size byte on the free block
+// FindFreeSpace find a space bigger enough to hold at least size byte on the free block.
+// The space is taken from the end of the first free block that is big enough.
+// return 0 if no free block can hold size bytes.
 func (mem *MemPage) FindFreeSpace(size uint16) uint16 {
-	freePointer := utils.GetUint16(mem.RawData[mem.HeaderOffset+3:]) // the first free block offset
-	freeSize := utils.GetUint16(mem.RawData[freePointer+2:])         // the first free block size
-	if freeSize > size {
-		remain := freeSize - size
-		utils.SetUint16(mem.RawData[freePointer+2:], remain)
-		return freePointer + remain
+	hdr := mem.HeaderOffset
+	prev := hdr + 3                                    // the offset of the pointer to the free block
+	freePointer := utils.GetUint16(mem.RawData[prev:]) // the first free block offset
+	for freePointer != 0 {
+		next := utils.GetUint16(mem.RawData[freePointer:])
+		freeSize := utils.GetUint16(mem.RawData[freePointer+2:])
+		if freeSize >= size {
+			remain := freeSize - size
+			if remain < 4 {
+				// the remaining bytes are too small to be a free block, unlink the whole block
+				// and record the remaining bytes as fragmented bytes
+				utils.SetUint16(mem.RawData[prev:], next)
+				mem.RawData[hdr+7] += uint8(remain)
+				return freePointer + remain
+			}
+			utils.SetUint16(mem.RawData[freePointer+2:], remain)
+			return freePointer + remain
+		}
+		prev = freePointer
+		freePointer = next
 	}
-	// FIXME: this return is just a placeholder to make the compiler happy
-	return freePointer
+	return 0
 }
 
-// AllocateSpace allocate space bigger enough to hold size bytes.
+// AllocateSpace allocate space bigger enough to hold size bytes, and keep 2 bytes
+// in the gap for the new cell index.
 // return the offset of the allocated space
 func (mem *MemPage) AllocateSpace(size uint16) uint16 {
 	var offset uint16 = 0                      // the return offset
@@ -229,7 +282,14 @@ func (mem *MemPage) AllocateSpace(size uint16) uint16 {
 	// if there is a free block, try to allocate space from free block
 	if (mem.RawData[mem.HeaderOffset+3] != 0 || mem.RawData[mem.HeaderOffset+4] != 0) && gap+2 <= top {
 		offset = mem.FindFreeSpace(size)
-		return offset
+		if offset != 0 {
+			return offset
+		}
+	}
+	if gap+2+size > top {
+		// the free space is fragmented, move all the cells together to make room
+		mem.defragment()
+		top = mem.CellContentOffset
 	}
 	//allocate space form the area between cell pointer array and cell content area
 	top -= size
@@ -239,31 +299,228 @@ func (mem *MemPage) AllocateSpace(size uint16) uint16 {
 	return offset
 }
 
+// defragment move all the cells to the end of the page so that all the free
+// space is in the gap between cell index and cell content.
+func (mem *MemPage) defragment() {
+	hdr := mem.HeaderOffset
+	tmp := append([]byte{}, mem.RawData...)
+	top := uint16(PageSize)
+	for k := uint16(0); k < mem.CellNum; k++ {
+		offset := mem.GetKthCellIndex(k)
+		size := onPageSize(utils.GetUint32(tmp[offset+4:]))
+		top -= size
+		copy(mem.RawData[top:], tmp[offset:offset+size])
+		utils.SetUint16(mem.RawData[mem.CellIndexOffset+2*k:], top)
+	}
+	gap := mem.CellIndexOffset + 2*mem.CellNum
+	copy(mem.RawData[gap:top], make([]byte, top-gap))
+	utils.SetUint16(mem.RawData[hdr+3:], 0)
+	utils.SetUint16(mem.RawData[hdr+5:], top)
+	mem.RawData[hdr+7] = 0
+	mem.CellContentOffset = top
+}
+
+// freeSpace return size bytes at offset to the free block chain. The chain is sorted
+// by offset, adjacent free blocks are merged, and a free block right at the start of
+// the cell content area is given back to the gap.
+func (mem *MemPage) freeSpace(offset uint16, size uint16) {
+	hdr := mem.HeaderOffset
+	copy(mem.RawData[offset:offset+size], make([]byte, size))
+	// find the free blocks before and after the freed space
+	prev := uint16(0)
+	next := utils.GetUint16(mem.RawData[hdr+3:])
+	for next != 0 && next < offset {
+		prev = next
+		next = utils.GetUint16(mem.RawData[next:])
+	}
+	// merge with the next free block
+	if next != 0 && offset+size == next {
+		size += utils.GetUint16(mem.RawData[next+2:])
+		next = utils.GetUint16(mem.RawData[next:])
+	}
+	// merge with the previous free block
+	if prev != 0 && prev+utils.GetUint16(mem.RawData[prev+2:]) == offset {
+		size += utils.GetUint16(mem.RawData[prev+2:])
+		offset = prev
+	} else if prev != 0 {
+		utils.SetUint16(mem.RawData[prev:], offset)
+	} else {
+		utils.SetUint16(mem.RawData[hdr+3:], offset)
+	}
+	if offset == mem.CellContentOffset {
+		// the free block is at the top of the cell content, give it back to the gap.
+		// it must be the first free block since the chain is sorted by offset.
+		utils.SetUint16(mem.RawData[hdr+3:], next)
+		copy(mem.RawData[offset:offset+4], make([]byte, 4))
+		mem.CellContentOffset = offset + size
+		utils.SetUint16(mem.RawData[hdr+5:], mem.CellContentOffset)
+		return
+	}
+	utils.SetUint16(mem.RawData[offset:], next)
+	utils.SetUint16(mem.RawData[offset+2:], size)
+}
+
+// DropCell remove the kth cell from the page and return its space to the free block chain.
+func (mem *MemPage) DropCell(k uint16) error {
+	if k >= mem.CellNum {
+		return ErrorCorruptedPage
+	}
+	if err := mem.markDirty(); err != nil {
+		return err
+	}
+	offset := mem.GetKthCellIndex(k)
+	size := onPageSize(mem.GetKthCellSize(k))
+	mem.freeSpace(offset, size)
+	// remove the cell index
+	base := mem.CellIndexOffset + 2*k
+	end := mem.CellIndexOffset + 2*mem.CellNum
+	copy(mem.RawData[base:], mem.RawData[base+2:end])
+	utils.SetUint16(mem.RawData[end-2:], 0)
+	mem.CellNum -= 1
+	utils.SetUint16(mem.RawData[mem.HeaderOffset+1:], mem.CellNum)
+	mem.FreeBytes += 2 + size
+	return nil
+}
+
 // BalanceDeep is used when the cursor currently point to the root page and
-// the root page need balance.
+// the root page need balance. The content of the root page, including the overflow
+// cells, is moved into a new child page and the root page becomes a non-leaf page
+// whose right child is the new child. The new child is returned and the caller
+// need to balance it.
 func (mem *MemPage) BalanceDeep() (*MemPage, error) {
-	// TODO: finish balance_deep
 	bShared := mem.BShared
 	// allocate a new page. The new page will become the MemPage's new right child
 	child, err := bShared.AllocateNewPage()
 	if err != nil {
 		return nil, err
 	}
+	flags := mem.RawData[mem.HeaderOffset]
 	// copy mem content to child
-	err = CopyMemPage(child, mem)
+	err = child.assemble(flags, mem.allCells(), mem.GetRightChild())
 	if err != nil {
 		return nil, err
 	}
 
-	child.OverflowCell = mem.OverflowCell
-
 	// zero root page, set child to the right child of the root page
-	err = mem.ZeroPage(child.RawData[0] & ^PAGE_LEAF)
+	err = mem.ZeroPage(flags & ^PAGE_LEAF)
 	if err != nil {
 		return nil, err
 	}
-	utils.SetUint32(mem.RawData[mem.HeaderOffset+8:], uint32(child.PageNo))
-	return nil, nil
+	err = mem.SetRightChild(child.PageNo)
+	if err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+// SetRightChild set the right child of a non-leaf page.
+func (mem *MemPage) SetRightChild(pageNo PageNumber) error {
+	if mem.IsLeaf {
+		return ErrorInvalidFlags
+	}
+	if err := mem.markDirty(); err != nil {
+		return err
+	}
+	utils.SetUint32(mem.RawData[mem.HeaderOffset+8:], uint32(pageNo))
+	return nil
+}
+
+// SetKthLeftPageNumber set the left child of the kth cell.
+func (mem *MemPage) SetKthLeftPageNumber(k uint16, pageNo PageNumber) error {
+	if err := mem.markDirty(); err != nil {
+		return err
+	}
+	utils.SetUint32(mem.RawData[mem.GetKthCellIndex(k):], uint32(pageNo))
+	return nil
+}
+
+// GetKthChild return the kth child of a non-leaf page. k = CellNum means the right child.
+func (mem *MemPage) GetKthChild(k uint16) PageNumber {
+	if k >= mem.CellNum {
+		return mem.GetRightChild()
+	}
+	return mem.GetKthLeftPageNumber(k)
+}
+
+// SetKthChild set the kth child of a non-leaf page. k = CellNum means the right child.
+func (mem *MemPage) SetKthChild(k uint16, pageNo PageNumber) error {
+	if k >= mem.CellNum {
+		return mem.SetRightChild(pageNo)
+	}
+	return mem.SetKthLeftPageNumber(k, pageNo)
+}
+
+// ChildIndex return the index of the child page in a non-leaf page.
+// CellNum means the right child. return -1 if the page is not a child.
+func (mem *MemPage) ChildIndex(pageNo PageNumber) int {
+	if mem.IsLeaf {
+		return -1
+	}
+	for i := uint16(0); i <= mem.CellNum; i++ {
+		if mem.GetKthChild(i) == pageNo {
+			return int(i)
+		}
+	}
+	return -1
+}
+
+// allCells return a copy of all the cells on the page in key order,
+// including the overflow cells that are not written to the page yet.
+func (mem *MemPage) allCells() []Cell {
+	cells := make([]Cell, 0, int(mem.CellNum)+len(mem.OverflowCell))
+	var k uint16 = 0
+	ovfl := 0
+	for k < mem.CellNum || ovfl < len(mem.OverflowCell) {
+		// an overflow cell is taken at its index, or at the end if the page has no cell left
+		if ovfl < len(mem.OverflowCell) &&
+			(int(mem.OverflowIndex[ovfl]) <= len(cells) || k >= mem.CellNum) {
+			cells = append(cells, mem.OverflowCell[ovfl])
+			ovfl++
+			continue
+		}
+		cells = append(cells, cloneCell(mem.getKthLocalCell(k)))
+		k++
+	}
+	return cells
+}
+
+// cloneCell copy the cell so that it does not point to the page content any more.
+func cloneCell(cell Cell) Cell {
+	c := cell
+	c.Payload = append([]byte{}, cell.Payload...)
+	c.RawData = nil
+	return c
+}
+
+// assemble zero the page with flags and fill it with the cells in order.
+// the cells that can not fit into the page become overflow cells.
+func (mem *MemPage) assemble(flags uint8, cells []Cell, rightChild PageNumber) error {
+	if err := mem.ZeroPage(flags); err != nil {
+		return err
+	}
+	for i, cell := range cells {
+		if err := mem.InsertCellFast(cell, uint16(i)); err != nil {
+			return err
+		}
+	}
+	if !mem.IsLeaf {
+		return mem.SetRightChild(rightChild)
+	}
+	return nil
+}
+
+// CellSize return the number of bytes the cell occupied on a page, exclude the cell index.
+func (cell Cell) CellSize() uint16 {
+	return onPageSize(cell.PayloadSize)
+}
+
+// usableSpace return the bytes a page can use to store cells and cell indexes.
+func (mem *MemPage) usableSpace() uint16 {
+	hdr := mem.HeaderOffset + 8
+	if !mem.IsLeaf {
+		hdr += 4
+	}
+	return PageSize - hdr
 }
 
 // GetRightChild return the right child of the page. if the page is a leaf page,
@@ -285,20 +542,28 @@ func (mem *MemPage) GetKthLeftPageNumber(k uint16) PageNumber {
 	return PageNumber(utils.GetUint32(mem.RawData[offset:]))
 }
 
-func (mem *MemPage) GetKthCellSize(k uint16) uint16 {
+// GetKthCellSize return the payload size of the kth cell, include the bytes on overflow pages.
+func (mem *MemPage) GetKthCellSize(k uint16) uint32 {
 	offset := mem.GetKthCellIndex(k) + 4
-	return utils.GetUint16(mem.RawData[offset:])
+	return utils.GetUint32(mem.RawData[offset:])
 }
 
 func (mem *MemPage) GetKthKey(k uint16) uint32 {
-	offset := mem.GetKthCellIndex(k) + 6
+	offset := mem.GetKthCellIndex(k) + 8
 	return utils.GetUint32(mem.RawData[offset:])
 }
 
-func (mem *MemPage) GetKthCellContent(k uint16) ([]byte, uint16) {
-	offset := mem.GetKthCellIndex(k)
-	size := mem.GetKthCellSize(k)
-	return mem.RawData[offset+10:], size
+// GetKthCellContent return the whole payload of the kth cell. The payload stored on
+// overflow pages is read back and appended to the local payload.
+func (mem *MemPage) GetKthCellContent(k uint16) ([]byte, error) {
+	cell := mem.getKthLocalCell(k)
+	if cell.OverflowPageNo == 0 {
+		return cell.Payload, nil
+	}
+	if mem.BShared == nil {
+		return nil, ErrorCorruptedPage
+	}
+	return mem.BShared.readOverflow(cell)
 }
 
 func (mem *MemPage) WriteCellContent(key uint32, data []byte) error {
@@ -306,40 +571,66 @@ func (mem *MemPage) WriteCellContent(key uint32, data []byte) error {
 	return nil
 }
 
-// GetKthCell gets kth cell in the memPage
-func (mem *MemPage) GetKthCell(k uint16) Cell {
+// GetKthCell gets kth cell in the memPage, the Payload of the cell is the whole payload.
+func (mem *MemPage) GetKthCell(k uint16) (Cell, error) {
+	cell := mem.getKthLocalCell(k)
+	payload, err := mem.GetKthCellContent(k)
+	if err != nil {
+		return Cell{}, err
+	}
+	cell.Payload = payload
+	return cell, nil
+}
+
+// getKthLocalCell gets kth cell in the memPage, the Payload of the cell only contain
+// the local payload stored on the page.
+func (mem *MemPage) getKthLocalCell(k uint16) Cell {
 	offset := mem.GetKthCellIndex(k)
 	size := mem.GetKthCellSize(k)
-	leftChild := mem.GetKthLeftPageNumber(k)
-	key := mem.GetKthKey(k)
-	return Cell{LeftChildPageNo: leftChild,
+	local := localSize(size)
+	cell := Cell{LeftChildPageNo: mem.GetKthLeftPageNumber(k),
 		PayloadSize: size,
-		Key:         key,
-		RawData:     mem.RawData[offset : offset+10+size],
-		Payload:     mem.RawData[offset+10 : offset+10+size]}
+		Key:         mem.GetKthKey(k),
+		RawData:     mem.RawData[offset : offset+onPageSize(size)],
+		Payload:     mem.RawData[offset+cellHeaderSize : offset+cellHeaderSize+local]}
+	if uint32(local) < size {
+		cell.OverflowPageNo = PageNumber(utils.GetUint32(mem.RawData[offset+cellHeaderSize+local:]))
+	}
+	return cell
 }
 
+// InsertCellFast insert the cell as the ith cell of the page. If the page has no room
+// for the cell, the cell is kept in the overflow array and the caller need to balance the page.
+// A cell whose payload is larger than maxLocal must have its overflow pages written before.
 func (mem *MemPage) InsertCellFast(cell Cell, i uint16) error {
+	if cell.PayloadSize > maxLocal && cell.OverflowPageNo == 0 {
+		return ErrorPayloadTooLarge
+	}
 	// convert cell to raw bytes
 	buf := bytes.NewBuffer([]byte{})
 	binary.Write(buf, binary.LittleEndian, cell.LeftChildPageNo)
 	binary.Write(buf, binary.LittleEndian, cell.PayloadSize)
 	binary.Write(buf, binary.LittleEndian, cell.Key)
-	binary.Write(buf, binary.LittleEndian, cell.Payload)
+	binary.Write(buf, binary.LittleEndian, cell.Payload[:localSize(cell.PayloadSize)])
+	if cell.PayloadSize > maxLocal {
+		binary.Write(buf, binary.LittleEndian, cell.OverflowPageNo)
+	}
 	size := uint16(buf.Len())
-	// TODO: finish the remaining task for insert
-	if size+2 > mem.FreeBytes {
+	if size+2 > mem.FreeBytes || len(mem.OverflowCell) > 0 {
 		// the free bytes in this page can not hold the cell index + cell content
 		// store the cell in the overflow array. Balance is handled in caller function
-		mem.OverflowCell = append(mem.OverflowCell, cell)
-
+		mem.OverflowCell = append(mem.OverflowCell, cloneCell(cell))
+		mem.OverflowIndex = append(mem.OverflowIndex, i)
 	} else {
-		// insert into CellIndex
-		base := mem.CellIndexOffset + 2*i
-		copy(mem.RawData[base+2:], mem.RawData[base:base+2*(mem.CellNum-i)])
+		if err := mem.markDirty(); err != nil {
+			return err
+		}
 		// insert into CellContent
 		offset := mem.AllocateSpace(size)
 		copy(mem.RawData[offset:], buf.Bytes())
+		// insert into CellIndex
+		base := mem.CellIndexOffset + 2*i
+		copy(mem.RawData[base+2:], mem.RawData[base:base+2*(mem.CellNum-i)])
 		utils.SetUint16(mem.RawData[base:], offset)
 		// increase CellNum in mem
 		mem.CellNum += 1
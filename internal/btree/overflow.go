@@ -0,0 +1,126 @@
+package btree
+
+import (
+	"godb/internal/utils"
+)
+
+// A payload that is too large to fit in a cell keeps its first maxLocal bytes on the
+// page, the rest is stored in a linked list of overflow pages.
+//
+// Overflow page layout:
+//
+// OFFSET	SIZE	DATA
+//    0       4     next overflow page number, 0 if this is the last page
+//    4    PageSize-4 payload content
+
+// maxLocal is the max number of payload bytes stored in a cell on the page.
+const maxLocal = maxCellSize - cellHeaderSize - 4
+
+// overflowSize is the number of payload bytes an overflow page can hold.
+const overflowSize = PageSize - 4
+
+// localSize return the number of payload bytes stored on the page for a payload of size bytes.
+func localSize(size uint32) uint16 {
+	if size > maxLocal {
+		return maxLocal
+	}
+	return uint16(size)
+}
+
+// onPageSize return the number of bytes a cell occupied on a page for a payload of size bytes.
+func onPageSize(size uint32) uint16 {
+	if size > maxLocal {
+		return cellHeaderSize + maxLocal + 4
+	}
+	return cellHeaderSize + uint16(size)
+}
+
+// newCell create a cell for the payload. If the payload is too large, the part
+// after the local payload is written to a new overflow chain.
+func (bs *Shared) newCell(key uint32, payload []byte) (Cell, error) {
+	cell := NewCell(key, payload)
+	if cell.PayloadSize <= maxLocal {
+		return cell, nil
+	}
+	first, err := bs.writeOverflow(payload[maxLocal:])
+	if err != nil {
+		return Cell{}, err
+	}
+	cell.Payload = payload[:maxLocal]
+	cell.OverflowPageNo = first
+	return cell, nil
+}
+
+// writeOverflow store data in a chain of new overflow pages and return the first page number.
+func (bs *Shared) writeOverflow(data []byte) (PageNumber, error) {
+	var first PageNumber = 0
+	var prev *MemPage = nil
+	for len(data) > 0 {
+		ovfl, err := bs.AllocateNewPage()
+		if err != nil {
+			return 0, err
+		}
+		if err := ovfl.markDirty(); err != nil {
+			return 0, err
+		}
+		// an overflow page is not a btree page
+		ovfl.IsInit = false
+		copy(ovfl.RawData, make([]byte, PageSize))
+		n := copy(ovfl.RawData[4:], data)
+		data = data[n:]
+		if prev == nil {
+			first = ovfl.PageNo
+		} else {
+			utils.SetUint32(prev.RawData[0:], uint32(ovfl.PageNo))
+		}
+		prev = ovfl
+	}
+	return first, nil
+}
+
+// readOverflow return the whole payload of the cell, the local payload followed by
+// the content of the overflow chain.
+func (bs *Shared) readOverflow(cell Cell) ([]byte, error) {
+	payload := make([]byte, 0, cell.PayloadSize)
+	payload = append(payload, cell.Payload...)
+	pageNo := cell.OverflowPageNo
+	for uint32(len(payload)) < cell.PayloadSize {
+		if pageNo == 0 || uint32(pageNo) > bs.NumPage {
+			return nil, ErrorCorruptedPage
+		}
+		ovfl, err := bs.GetPage(pageNo, PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+		if err != nil {
+			return nil, err
+		}
+		n := cell.PayloadSize - uint32(len(payload))
+		if n > overflowSize {
+			n = overflowSize
+		}
+		payload = append(payload, ovfl.RawData[4:4+n]...)
+		pageNo = PageNumber(utils.GetUint32(ovfl.RawData[0:]))
+	}
+	return payload, nil
+}
+
+// freeOverflow put all the overflow pages of the cell on the freelist.
+func (bs *Shared) freeOverflow(cell Cell) error {
+	pageNo := cell.OverflowPageNo
+	remain := int64(cell.PayloadSize) - maxLocal
+	for remain > 0 {
+		if pageNo == 0 || uint32(pageNo) > bs.NumPage {
+			return ErrorCorruptedPage
+		}
+		ovfl, err := bs.GetPage(pageNo, PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+		if err != nil {
+			return err
+		}
+		// read the next page number before the page is reused by the freelist
+		next := PageNumber(utils.GetUint32(ovfl.RawData[0:]))
+		if err := bs.FreePage(pageNo); err != nil {
+			return err
+		}
+		pageNo = next
+		remain -= overflowSize
+	}
+	return nil
+}
@@ -2,60 +2,228 @@ package btree
 
 import (
 	"errors"
+	"sort"
 )
 
 var (
 	ErrorCacheMiss = errors.New("cache missed")
 )
 
+// DefaultCacheSize is the default max number of pages kept in the page cache.
+const DefaultCacheSize = 2000
+
 type PageCache interface {
 	FetchPage(pageNo PageNumber, flag uint8) (*PageCacheEntry, error)
+	DirtyPages() []*PageCacheEntry
+	// Truncate drop all the pages whose page number is bigger than pageNo.
+	Truncate(pageNo PageNumber)
+	// Unbind reset the MemPage of every cached page, they are init again from their raw data.
+	Unbind()
+	// SetCapacity set the max number of pages kept in the cache, 0 for no limit.
+	SetCapacity(capacity int)
+	// Release unpin the pages fetched since the last Release, so that they can be evicted.
+	Release()
+	// Stats return the counters of the page cache.
+	Stats() CacheStats
+}
+
+// CacheStats is the counters of the page cache.
+type CacheStats struct {
+	Hits      uint64 // number of fetches found in the cache
+	Misses    uint64 // number of pages loaded from the pager
+	Evictions uint64 // number of pages removed from the cache to make room
 }
 
 type PageCacheEntry struct {
 	PageNo PageNumber // the page number of the cache entry
 	Dirty  bool       // true if the data in the cache is modified
 	Data   *MemPage   // the cached page data
+	Ref    bool       // reference bit of the clock replacement policy
+	Epoch  uint64     // the epoch the entry is last fetched in
 }
 
+// The page cache keeps at most capacity pages, the pages are replaced by the clock
+// policy. An entry is evictable unless:
+//   - it is fetched after the last Release, the caller may still hold its MemPage
+//   - it is page one, or it is referenced by an open cursor
+//   - it is dirty and the pager can not write it back before commit
+//
+// The cache grows beyond its capacity when all the entries are pinned.
 type pageCache struct {
 	pager     *pager                         // pager that own the page cache object
 	cacheHash map[PageNumber]*PageCacheEntry // page cache hash, store the page cache entry pointer
+	capacity  int                            // max number of pages kept in the cache, 0 for no limit
+	clock     []*PageCacheEntry              // the entries in the order of the clock
+	hand      int                            // index of the entry the clock hand point to
+	epoch     uint64                         // the entries fetched in the current epoch are pinned
+	stats     CacheStats                     // hit, miss and eviction counters
+}
+
+func newPageCache(pgr *pager) *pageCache {
+	pcache := new(pageCache)
+	pcache.pager = pgr
+	pcache.cacheHash = make(map[PageNumber]*PageCacheEntry)
+	pcache.capacity = DefaultCacheSize
+	return pcache
 }
 
 func (pcache *pageCache) FetchPage(pageNo PageNumber, flag uint8) (*PageCacheEntry, error) {
-	// TODO: finish the fetch page logic
 	if entry, ok := pcache.cacheHash[pageNo]; ok {
+		pcache.stats.Hits++
+		entry.Ref = true
+		entry.Epoch = pcache.epoch
 		return entry, nil
 	} else if (flag & PAGE_CACHE_CREAT) > 0 {
-		// cache miss, try to create a new page and return
-		newPageNo := (*pcache.pager).GetPageNumber() + 1
-		(*pcache.pager).PageNumber += 1
+		// make room for the page before it is loaded
+		if pcache.capacity > 0 && len(pcache.cacheHash) >= pcache.capacity {
+			if err := pcache.evict(); err != nil {
+				return nil, err
+			}
+		}
+		// cache miss, load the page from the pager and add it into page cache
+		pcache.stats.Misses++
+		mem, err := pcache.pager.readPage(pageNo)
+		if err != nil {
+			return nil, err
+		}
 		pce := new(PageCacheEntry)
-		pce.Data, _ = NewZeroPage(newPageNo)
-		pce.Dirty = true
-		pce.PageNo = newPageNo
-		// add the newly created page into page cache
-		pcache.cacheHash[newPageNo] = pce
+		pce.Data = mem
+		pce.PageNo = pageNo
+		pce.Ref = true
+		pce.Epoch = pcache.epoch
+		if pageNo > pcache.pager.PageNumber {
+			// the page is beyond the end of the database file, it must be written on commit
+			if err := pcache.pager.beginWrite(); err != nil {
+				return nil, err
+			}
+			pcache.pager.PageNumber = pageNo
+			pce.Dirty = true
+		}
+		pcache.cacheHash[pageNo] = pce
+		pcache.clock = append(pcache.clock, pce)
 		return pce, nil
 	}
 	return nil, ErrorCacheMiss
 }
 
+// evict remove one evictable entry from the cache. The clock hand sweep the entries,
+// an entry referenced since the last sweep get a second chance. Nothing is removed
+// if all the entries are pinned.
+func (pcache *pageCache) evict() error {
+	for i := 0; i < 2*len(pcache.clock); i++ {
+		if pcache.hand >= len(pcache.clock) {
+			pcache.hand = 0
+		}
+		pce := pcache.clock[pcache.hand]
+		if pce.Ref {
+			pce.Ref = false
+			pcache.hand++
+			continue
+		}
+		if !pcache.evictable(pce) {
+			pcache.hand++
+			continue
+		}
+		if pce.Dirty {
+			// the page is written back before it leaves the cache
+			if err := pcache.pager.spillPage(pce); err != nil {
+				return err
+			}
+		}
+		// the last entry take the place of the removed one
+		last := len(pcache.clock) - 1
+		pcache.clock[pcache.hand] = pcache.clock[last]
+		pcache.clock = pcache.clock[:last]
+		delete(pcache.cacheHash, pce.PageNo)
+		pcache.stats.Evictions++
+		return nil
+	}
+	return nil
+}
+
+func (pcache *pageCache) evictable(pce *PageCacheEntry) bool {
+	if pce.Epoch == pcache.epoch || pce.PageNo == 1 {
+		return false
+	}
+	if pce.Dirty && !pcache.pager.canSpill() {
+		return false
+	}
+	bs := pce.Data.BShared
+	return bs == nil || !bs.isPinned(pce.PageNo)
+}
+
+func (pcache *pageCache) SetCapacity(capacity int) {
+	pcache.capacity = capacity
+}
+
+func (pcache *pageCache) Release() {
+	pcache.epoch++
+}
+
+func (pcache *pageCache) Stats() CacheStats {
+	return pcache.stats
+}
+
+// DirtyPages return all the dirty entries in the page cache, ordered by page number.
+func (pcache *pageCache) DirtyPages() []*PageCacheEntry {
+	var dirty []*PageCacheEntry
+	for _, pce := range pcache.cacheHash {
+		if pce.Dirty {
+			dirty = append(dirty, pce)
+		}
+	}
+	sort.Slice(dirty, func(i, j int) bool { return dirty[i].PageNo < dirty[j].PageNo })
+	return dirty
+}
+
+func (pcache *pageCache) Truncate(pageNo PageNumber) {
+	clock := pcache.clock[:0]
+	for _, pce := range pcache.clock {
+		if pce.PageNo > pageNo {
+			delete(pcache.cacheHash, pce.PageNo)
+		} else {
+			clock = append(clock, pce)
+		}
+	}
+	pcache.clock = clock
+	pcache.hand = 0
+}
+
+func (pcache *pageCache) Unbind() {
+	for _, pce := range pcache.clock {
+		resetMemPage(pce.Data)
+	}
+}
+
+// resetMemPage flag the MemPage as unbound so that ToMemPage init it again from its raw data.
+func resetMemPage(mem *MemPage) {
+	mem.BShared = nil
+	mem.IsInit = false
+	mem.OverflowCell = nil
+	mem.OverflowIndex = nil
+}
+
 // ToMemPage return the MemPage the PageCacheEntry hold
 // if the MemPage not init before, ToMemPage will init the MemPage's PageNo, BShared nad HeaderOffset field
 func (pce PageCacheEntry) ToMemPage(pageNo PageNumber, shared *Shared) *MemPage {
 	mem := pce.Data
-	if mem.PageNo != pageNo {
-		// if the page's page number != given page number, then the page is newly created.
-		// thus need to init the page content.
+	if mem.BShared == nil {
+		// the page is not bound to a btree yet, thus need to init the page content.
 		mem.PageNo = pageNo
 		mem.BShared = shared
 		if pageNo == 1 {
-			mem.HeaderOffset = 100
+			mem.HeaderOffset = DatabaseHeaderSize
+			mem.IsPageOne = true
 		} else {
 			mem.HeaderOffset = 0
 		}
+		// a page read from the database file only has its raw data set.
+		// a zeroed page has no valid flags and is left for the caller to set up.
+		if !mem.IsInit && checkFlags(mem.RawData[mem.HeaderOffset]) {
+			if err := mem.InitMemPage(); err == nil {
+				mem.ComputeFreeBytes()
+			}
+		}
 	}
 	return mem
 }
@@ -0,0 +1,67 @@
+package btree
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestPageCacheEviction(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	bs, err := Open(fileName)
+	assert.Nil(t, err)
+	pcache := bs.Pager.GetPageCache().(*pageCache)
+	pcache.SetCapacity(16)
+
+	// the dirty pages are spilled to the database file in the middle of the transaction
+	root := setupCommitted(t, bs, 3000)
+	bt := bs.OpenBtree(root)
+	assert.True(t, bs.NumPage > 16)
+	assert.True(t, len(pcache.cacheHash) <= 16)
+	assert.Equal(t, len(pcache.cacheHash), len(pcache.clock))
+	stats := pcache.Stats()
+	assert.True(t, stats.Evictions > 0)
+	assert.True(t, stats.Hits > 0)
+	assert.Equal(t, stats.Misses, stats.Evictions+uint64(len(pcache.cacheHash)))
+	assertKeys(t, bt, 3000)
+
+	// the spilled pages are restored from the journal on rollback
+	modify(t, bt, 3000)
+	assert.Nil(t, bs.Rollback())
+	assertKeys(t, bt, 3000)
+	assert.Nil(t, bs.Close())
+
+	bs, err = Open(fileName)
+	assert.Nil(t, err)
+	defer bs.Close()
+	assertKeys(t, bs.OpenBtree(root), 3000)
+}
+
+func TestPageCachePinnedByCursor(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	bs, err := Open(fileName)
+	assert.Nil(t, err)
+	defer bs.Close()
+	pcache := bs.Pager.GetPageCache().(*pageCache)
+	pcache.SetCapacity(4)
+	root := setupCommitted(t, bs, 1000)
+
+	// a cursor keep its pages in the cache while other pages are evicted
+	cursor := bs.OpenBtree(root).Cursor()
+	loc, err := cursor.MoveTo(500)
+	assert.Nil(t, err)
+	assert.Equal(t, int8(0), loc)
+	mem := cursor.(*btCursor).Mem
+	scan := bs.OpenBtree(root).Cursor()
+	assert.Nil(t, scan.MoveToRoot())
+	assert.Nil(t, scan.MoveToLeftMost())
+	for !scan.Eof() {
+		assert.Nil(t, scan.MoveNext())
+	}
+	scan.Close()
+	assert.True(t, pcache.Stats().Evictions > 0)
+	assert.Equal(t, mem, pcache.cacheHash[mem.PageNo].Data)
+	assert.Equal(t, uint32(500), cursor.Key())
+	cursor.Close()
+	assert.Empty(t, bs.BtCursor)
+}
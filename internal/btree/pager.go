@@ -1,25 +1,154 @@
 package btree
 
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
 const (
 	PAGE_CACHE_FETCH uint8 = 0x1 // only fetch a page cache
 	PAGE_CACHE_CREAT uint8 = 0x2 // create a page cache
 )
 
+// PageSize is the size of every page in the database file.
+const PageSize = 4096
+
+var (
+	ErrorShortRead = errors.New("short read on database file")
+)
+
 type Pager interface {
 	FetchPage(pageNo PageNumber, flag uint8) (*PageCacheEntry, error)
 	// Insert(pageNo PageNumber, data []byte) error
 
+	// Write mark the page as dirty. It must be called before the page content is modified.
+	// The first Write start a write transaction.
+	Write(pageNo PageNumber) error
+	// Commit write all the dirty pages back to the database file atomically.
+	Commit() error
+	// Rollback discard all the changes made by the current write transaction.
+	Rollback() error
+	// Begin start a write transaction before any page is written.
+	Begin() error
+	// Savepoint open a nested savepoint. RollbackTo restore the pages to the nth
+	// savepoint, and ReleaseSavepoint close it. The outermost savepoint is 0.
+	Savepoint() error
+	RollbackTo(n int) error
+	ReleaseSavepoint(n int) error
+	// Flush write all the dirty pages back to the database file.
+	Flush() error
+	// Checkpoint copy the pages in the write-ahead log back to the database file.
+	Checkpoint() error
+	// SetJournalMode switch between JournalModeDelete and JournalModeWal.
+	SetJournalMode(mode uint8) error
+	// BeginRead take a read snapshot, EndRead release it.
+	BeginRead()
+	EndRead()
+	// Lock raise the lock on the database file to level, Unlock release it once the
+	// write transaction is over. A busy lock is retried until the busy timeout expire.
+	Lock(level LockLevel) error
+	Unlock() error
+	SetBusyTimeout(timeout time.Duration)
+	// Close flush the dirty pages and close the database file.
+	Close() error
 	GetPageNumber() PageNumber
+	GetPageCache() PageCache
 }
 
 type pager struct {
 	PageCache  PageCache  // page cache interface
 	PageNumber PageNumber // page number in the database file
+	FileName   string     // name of the database file, empty for an in-memory database
+	File       *os.File   // the database file, nil for an in-memory database
+	Inode      *inode     // the lock of the process on the database file
+	FileSize   int64      // size of the database file in bytes
+
+	Locked        LockLevel     // the lock held on the database file
+	BusyTimeout   time.Duration // how long a busy lock is retried
+	ChangeCounter uint32        // the file change counter when the lock was last taken
+
+	InWriteTxn     bool                  // true if a write transaction is open
+	OrigPageNumber PageNumber            // page number in the database file before the write transaction
+	Journal        *os.File              // the rollback journal, nil if no page is journaled yet
+	JournalCount   uint32                // number of page records in the journal
+	JournalNonce   uint32                // random nonce of the journal checksum
+	JournalSynced  bool                  // true if all the records in the journal are synced
+	Journaled      map[PageNumber]bool   // pages whose original content is saved
+	OrigPages      map[PageNumber][]byte // original content of the pages of an in-memory database
+	Savepoints     []*savepoint          // open savepoints, the innermost is the last
+
+	JournalMode   uint8                   // JournalModeDelete or JournalModeWal
+	Wal           *os.File                // the write-ahead log, nil if not in WAL mode
+	WalIndex      map[PageNumber][]uint32 // frames of every page in the log, in ascending order
+	WalFrames     uint32                  // number of committed frames in the log
+	WalChecksum   uint32                  // cumulative checksum of the last committed frame
+	WalSalt1      uint32                  // salt-1 of the log
+	WalSalt2      uint32                  // salt-2 of the log
+	WalSequence   uint32                  // checkpoint sequence number of the log
+	WalPageNumber PageNumber              // number of pages in the database after the last commit frame
+	InReadTxn     bool                    // true if a read snapshot is taken
+	ReadMark      uint32                  // the last frame visible to the read snapshot
+}
+
+// OpenPager open the database file and return a pager on it. The file is created if
+// it does not exist. If fileName is empty, the pager is an in-memory pager that never
+// touch the disk.
+func OpenPager(fileName string) (Pager, error) {
+	pgr := new(pager)
+	pcache := newPageCache(pgr)
+	pgr.PageCache = pcache
+	pgr.FileName = fileName
+	if fileName == "" {
+		// every page of an in-memory database lives in the page cache
+		pcache.SetCapacity(0)
+		return pgr, nil
+	}
+	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	pgr.File = f
+	pgr.Inode = openInode(info)
+	pgr.FileSize = info.Size()
+	// a journal left by a crash is played back once the shared lock is taken
+	if err := pgr.lock(LockShared); err != nil {
+		pgr.closeFile()
+		return nil, err
+	}
+	defer pgr.unlock(LockNone)
+	// a partial page at the end of the file is ignored
+	pgr.PageNumber = PageNumber(pgr.FileSize / PageSize)
+	// the journal mode is recorded in the database header
+	if pgr.FileSize >= DatabaseHeaderSize {
+		hdr := make([]byte, DatabaseHeaderSize)
+		if _, err := f.ReadAt(hdr, 0); err != nil {
+			pgr.closeFile()
+			return nil, err
+		}
+		if h, err := ParseHeader(hdr); err == nil && h.JournalMode == JournalModeWal {
+			if err := pgr.SetJournalMode(JournalModeWal); err != nil {
+				pgr.closeFile()
+				return nil, err
+			}
+			if pgr.WalFrames > 0 {
+				pgr.PageNumber = pgr.WalPageNumber
+			}
+		}
+	}
+	return pgr, nil
 }
 
 // FetchPage fetch a page from pager.
 // if the page already in the page cache, return the cache directly.
-// if there is a cache miss and PAGE_CACHE_CREAT flag is set, create a new page.
+// if there is a cache miss and PAGE_CACHE_CREAT flag is set, load the page from the
+// database file, or create a new page if the page is beyond the end of the file.
 // otherwise return nil
 func (pgr *pager) FetchPage(pageNo PageNumber, flag uint8) (*PageCacheEntry, error) {
 	if pageNo == 0 {
@@ -35,3 +164,207 @@ func (pgr *pager) FetchPage(pageNo PageNumber, flag uint8) (*PageCacheEntry, err
 func (pgr *pager) GetPageNumber() PageNumber {
 	return pgr.PageNumber
 }
+
+func (pgr *pager) GetPageCache() PageCache {
+	return pgr.PageCache
+}
+
+// canSpill return true if a dirty page can be written to the database file before commit.
+// Only the rollback journal can restore the pages written in the middle of a transaction.
+func (pgr *pager) canSpill() bool {
+	return pgr.File != nil && pgr.Wal == nil
+}
+
+// spillPage write a dirty page back to the database file so that it can be evicted.
+// The journal is synced first, so the original content of the page can be restored.
+func (pgr *pager) spillPage(pce *PageCacheEntry) error {
+	if err := pgr.lock(LockExclusive); err != nil {
+		return err
+	}
+	if err := pgr.syncJournal(); err != nil {
+		return err
+	}
+	if err := pgr.writePage(pce.PageNo, pce.Data.RawData); err != nil {
+		return err
+	}
+	pce.Dirty = false
+	return nil
+}
+
+// readPage read the content of a page from the database file. A page that is not in
+// the database file yet comes back zeroed.
+func (pgr *pager) readPage(pageNo PageNumber) (*MemPage, error) {
+	mem, err := NewZeroPage(pageNo)
+	if err != nil {
+		return nil, err
+	}
+	// the newest version of the page may be in the write-ahead log
+	if pgr.Wal != nil {
+		if frame := pgr.walFindFrame(pageNo); frame != 0 {
+			if err := pgr.walReadPage(frame, mem.RawData); err != nil {
+				return nil, err
+			}
+			return mem, nil
+		}
+	}
+	offset := int64(pageNo-1) * PageSize
+	if pgr.File == nil || offset+PageSize > pgr.FileSize {
+		return mem, nil
+	}
+	n, err := pgr.File.ReadAt(mem.RawData, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n != PageSize {
+		return nil, ErrorShortRead
+	}
+	return mem, nil
+}
+
+// writePage write the content of a page to the database file.
+func (pgr *pager) writePage(pageNo PageNumber, raw []byte) error {
+	offset := int64(pageNo-1) * PageSize
+	if _, err := pgr.File.WriteAt(raw[:PageSize], offset); err != nil {
+		return err
+	}
+	if offset+PageSize > pgr.FileSize {
+		pgr.FileSize = offset + PageSize
+	}
+	return nil
+}
+
+func (pgr *pager) Write(pageNo PageNumber) error {
+	pce, err := pgr.FetchPage(pageNo, PAGE_CACHE_FETCH)
+	if err != nil {
+		return err
+	}
+	if err := pgr.beginWrite(); err != nil {
+		return err
+	}
+	// the write-ahead log never modify the database file before checkpoint,
+	// thus there is no need to save the original content
+	if pgr.Wal == nil {
+		if err := pgr.journalPage(pageNo, pce.Data.RawData); err != nil {
+			return err
+		}
+	}
+	pgr.savePage(pageNo, pce.Data.RawData)
+	pce.Dirty = true
+	return nil
+}
+
+// Commit sync the journal, write the dirty pages to the database file and
+// then delete the journal. The database file is written under the exclusive lock.
+func (pgr *pager) Commit() error {
+	if !pgr.InWriteTxn {
+		pgr.Savepoints = nil
+		return nil
+	}
+	if pgr.File != nil {
+		if err := pgr.bumpChangeCounter(); err != nil {
+			return err
+		}
+	}
+	if pgr.Wal != nil {
+		if err := pgr.walCommit(pgr.PageCache.DirtyPages()); err != nil {
+			return err
+		}
+		if err := pgr.endWrite(); err != nil {
+			return err
+		}
+		// the checkpoint wait for a later commit if the log is still read by others
+		if pgr.WalFrames >= walAutoCheckpoint && !pgr.InReadTxn {
+			if err := pgr.Checkpoint(); err != ErrorCheckpointBusy {
+				return err
+			}
+		}
+		return nil
+	}
+	// an in-memory database keeps all its pages in the page cache
+	if pgr.File != nil {
+		if err := pgr.lock(LockExclusive); err != nil {
+			return err
+		}
+		if err := pgr.syncJournal(); err != nil {
+			return err
+		}
+		for _, pce := range pgr.PageCache.DirtyPages() {
+			if err := pgr.writePage(pce.PageNo, pce.Data.RawData); err != nil {
+				return err
+			}
+			pce.Dirty = false
+		}
+		if err := pgr.File.Sync(); err != nil {
+			return err
+		}
+	}
+	return pgr.endWrite()
+}
+
+// Rollback restore the original content of the pages modified by the write transaction.
+// All the MemPage fetched before are invalid after rollback.
+func (pgr *pager) Rollback() error {
+	if !pgr.InWriteTxn {
+		pgr.Savepoints = nil
+		return nil
+	}
+	if pgr.File == nil {
+		for pageNo, raw := range pgr.OrigPages {
+			pce, err := pgr.FetchPage(pageNo, PAGE_CACHE_FETCH)
+			if err != nil {
+				return err
+			}
+			copy(pce.Data.RawData, raw)
+		}
+		pgr.PageCache.Unbind()
+		pgr.PageCache.Truncate(pgr.OrigPageNumber)
+		pgr.PageNumber = pgr.OrigPageNumber
+		return pgr.endWrite()
+	}
+	// the pages written to the database file during the transaction are restored from the journal.
+	// in WAL mode, the pages are only written to the log on commit
+	if pgr.Journal != nil {
+		if err := pgr.writeJournalHeader(pgr.JournalCount); err != nil {
+			return err
+		}
+		if err := pgr.playbackJournal(pgr.Journal); err != nil {
+			return err
+		}
+	}
+	if pgr.FileSize > int64(pgr.OrigPageNumber)*PageSize {
+		if err := pgr.File.Truncate(int64(pgr.OrigPageNumber) * PageSize); err != nil {
+			return err
+		}
+		pgr.FileSize = int64(pgr.OrigPageNumber) * PageSize
+	}
+	// every cached page is read again from the database file
+	pgr.PageCache.Truncate(0)
+	pgr.PageNumber = pgr.OrigPageNumber
+	return pgr.endWrite()
+}
+
+func (pgr *pager) Flush() error {
+	return pgr.Commit()
+}
+
+func (pgr *pager) Close() error {
+	if pgr.File == nil {
+		return nil
+	}
+	if err := pgr.Commit(); err != nil {
+		return err
+	}
+	pgr.EndRead()
+	// the log is checkpointed and deleted, the next open create it again.
+	// it is left as it is while another process still use it.
+	if err := pgr.closeWal(); err == ErrorCheckpointBusy {
+		err = pgr.Wal.Close()
+		pgr.Wal = nil
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return pgr.closeFile()
+}
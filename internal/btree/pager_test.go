@@ -0,0 +1,62 @@
+package btree
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestPagerPersist(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	pgr, err := OpenPager(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, PageNumber(0), pgr.GetPageNumber())
+	for i := 1; i <= 3; i++ {
+		pce, err := pgr.FetchPage(PageNumber(i), PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+		assert.Nil(t, err)
+		assert.Nil(t, pgr.Write(PageNumber(i)))
+		pce.Data.RawData[0] = byte(i)
+		pce.Data.RawData[PageSize-1] = byte(i * 2)
+	}
+	assert.Equal(t, PageNumber(3), pgr.GetPageNumber())
+	assert.Nil(t, pgr.Close())
+
+	pgr, err = OpenPager(fileName)
+	assert.Nil(t, err)
+	defer pgr.Close()
+	assert.Equal(t, PageNumber(3), pgr.GetPageNumber())
+	for i := 1; i <= 3; i++ {
+		pce, err := pgr.FetchPage(PageNumber(i), PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+		assert.Nil(t, err)
+		assert.False(t, pce.Dirty)
+		assert.Equal(t, byte(i), pce.Data.RawData[0])
+		assert.Equal(t, byte(i*2), pce.Data.RawData[PageSize-1])
+	}
+	// a page not in the cache can not be fetched without PAGE_CACHE_CREAT
+	_, err = pgr.FetchPage(4, PAGE_CACHE_FETCH)
+	assert.Equal(t, ErrorCacheMiss, err)
+}
+
+func TestSharedNumPage(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	pgr, err := OpenPager(fileName)
+	assert.Nil(t, err)
+	bs := NewShared(pgr)
+	for i := 1; i <= 2; i++ {
+		mem, err := bs.AllocateNewPage()
+		assert.Nil(t, err)
+		assert.Equal(t, PageNumber(i), mem.PageNo)
+		assert.Nil(t, mem.ZeroPage(PAGE_DATA|PAGE_LEAF_DATA|PAGE_LEAF))
+	}
+	assert.Nil(t, bs.Close())
+
+	pgr, err = OpenPager(fileName)
+	assert.Nil(t, err)
+	bs = NewShared(pgr)
+	defer bs.Close()
+	assert.Equal(t, uint32(2), bs.NumPage)
+	mem, err := bs.GetPage(2, PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+	assert.Nil(t, err)
+	assert.True(t, mem.IsLeaf)
+	assert.True(t, mem.IsDataLeaf)
+}
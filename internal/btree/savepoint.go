@@ -0,0 +1,103 @@
+package btree
+
+import "errors"
+
+// A savepoint mark a state inside the write transaction that can be rolled back to,
+// without rolling back the whole transaction. Every savepoint keep the original
+// content of the pages modified after it is opened, the pre-image of a page is saved
+// in the innermost savepoint only. Rolling back to a savepoint apply the pre-images
+// of the savepoint and of all the savepoints opened after it.
+
+var (
+	ErrorNoSavepoint = errors.New("no such savepoint")
+)
+
+type savepoint struct {
+	PageNumber PageNumber            // page number in the database when the savepoint is opened
+	Pages      map[PageNumber][]byte // pre-images of the pages modified after the savepoint
+}
+
+// Begin start a write transaction before any page is written.
+func (pgr *pager) Begin() error {
+	return pgr.beginWrite()
+}
+
+// Savepoint open a new savepoint, it is nested in the savepoints opened before.
+func (pgr *pager) Savepoint() error {
+	pgr.Savepoints = append(pgr.Savepoints, &savepoint{
+		PageNumber: pgr.PageNumber,
+		Pages:      make(map[PageNumber][]byte),
+	})
+	return nil
+}
+
+// savePage save the pre-image of the page in the innermost savepoint. A page beyond
+// the end of the database when the savepoint is opened need not be saved, it is
+// truncated on rollback.
+func (pgr *pager) savePage(pageNo PageNumber, raw []byte) {
+	if len(pgr.Savepoints) == 0 {
+		return
+	}
+	sp := pgr.Savepoints[len(pgr.Savepoints)-1]
+	if _, ok := sp.Pages[pageNo]; ok || pageNo > sp.PageNumber {
+		return
+	}
+	sp.Pages[pageNo] = append([]byte{}, raw[:PageSize]...)
+}
+
+// RollbackTo restore the pages to their content when the nth savepoint, counted from
+// the outermost one, was opened. The savepoints opened after it are closed, the nth
+// savepoint stay open. All the MemPage fetched before are invalid after rollback.
+func (pgr *pager) RollbackTo(n int) error {
+	if n < 0 || n >= len(pgr.Savepoints) {
+		return ErrorNoSavepoint
+	}
+	// the outer savepoints hold the older pre-images, thus they are applied last
+	for i := len(pgr.Savepoints) - 1; i >= n; i-- {
+		for pageNo, raw := range pgr.Savepoints[i].Pages {
+			pce, err := pgr.FetchPage(pageNo, PAGE_CACHE_FETCH|PAGE_CACHE_CREAT)
+			if err != nil {
+				return err
+			}
+			copy(pce.Data.RawData, raw)
+			// the page is already journaled when it was first modified
+			pce.Dirty = true
+		}
+	}
+	// a freed page is not written, but its MemPage is no longer init
+	pgr.PageCache.Unbind()
+	sp := pgr.Savepoints[n]
+	pgr.PageCache.Truncate(sp.PageNumber)
+	pgr.PageNumber = sp.PageNumber
+	// the pages after the savepoint may be spilled to the database file
+	if pgr.File != nil && pgr.FileSize > int64(sp.PageNumber)*PageSize {
+		if err := pgr.File.Truncate(int64(sp.PageNumber) * PageSize); err != nil {
+			return err
+		}
+		pgr.FileSize = int64(sp.PageNumber) * PageSize
+	}
+	sp.Pages = make(map[PageNumber][]byte)
+	pgr.Savepoints = pgr.Savepoints[:n+1]
+	return nil
+}
+
+// ReleaseSavepoint close the nth savepoint and all the savepoints opened after it.
+// Their changes become part of the enclosing savepoint or of the transaction.
+func (pgr *pager) ReleaseSavepoint(n int) error {
+	if n < 0 || n >= len(pgr.Savepoints) {
+		return ErrorNoSavepoint
+	}
+	if n > 0 {
+		// the enclosing savepoint need the pre-images of the pages it does not hold yet
+		outer := pgr.Savepoints[n-1]
+		for _, sp := range pgr.Savepoints[n:] {
+			for pageNo, raw := range sp.Pages {
+				if _, ok := outer.Pages[pageNo]; !ok && pageNo <= outer.PageNumber {
+					outer.Pages[pageNo] = raw
+				}
+			}
+		}
+	}
+	pgr.Savepoints = pgr.Savepoints[:n]
+	return nil
+}
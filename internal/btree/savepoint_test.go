@@ -0,0 +1,74 @@
+package btree
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestSavepoint(t *testing.T) {
+	dir := t.TempDir()
+	for _, mode := range []string{"delete", "spill", "wal", "memory"} {
+		fileName := filepath.Join(dir, mode+".db")
+		if mode == "memory" {
+			fileName = ""
+		}
+		bs, err := Open(fileName)
+		assert.Nil(t, err)
+		switch mode {
+		case "spill":
+			// a small cache write the dirty pages to the database file before commit
+			bs.Pager.GetPageCache().SetCapacity(8)
+		case "wal":
+			assert.Nil(t, bs.SetJournalMode(JournalModeWal))
+		}
+		root := setupCommitted(t, bs, 100)
+		bt := bs.OpenBtree(root)
+		numPage := bs.NumPage
+
+		assert.Nil(t, bs.Savepoint())
+		for i := 100; i < 1000; i++ {
+			assert.Nil(t, bt.Insert(uint32(i), payloadOf(uint32(i))))
+		}
+		afterInsert := bs.NumPage
+		assert.Nil(t, bs.Savepoint())
+		for i := 0; i < 1000; i += 3 {
+			assert.Nil(t, bt.Delete(uint32(i)))
+		}
+		assert.Nil(t, bs.Savepoint())
+		for i := 1000; i < 1500; i++ {
+			assert.Nil(t, bt.Insert(uint32(i), payloadOf(uint32(i))))
+		}
+		// rolling back to the second savepoint undo the deletes and the last inserts
+		assert.Nil(t, bs.RollbackTo(1), mode)
+		assertKeys(t, bt, 1000)
+		assert.Equal(t, afterInsert, bs.NumPage, mode)
+		assert.Equal(t, ErrorNoSavepoint, bs.RollbackTo(2))
+
+		// the changes of a released savepoint belong to the enclosing one
+		for i := 0; i < 1000; i += 2 {
+			assert.Nil(t, bt.Delete(uint32(i)))
+		}
+		assert.Nil(t, bs.ReleaseSavepoint(1))
+		assert.Equal(t, ErrorNoSavepoint, bs.ReleaseSavepoint(1))
+		assert.Nil(t, bs.RollbackTo(0))
+		assertKeys(t, bt, 100)
+		assert.Equal(t, numPage, bs.NumPage, mode)
+
+		// the savepoint stay open after rollback
+		for i := 100; i < 200; i++ {
+			assert.Nil(t, bt.Insert(uint32(i), payloadOf(uint32(i))))
+		}
+		assert.Nil(t, bs.ReleaseSavepoint(0))
+		assert.Nil(t, bs.Commit())
+		assertKeys(t, bt, 200)
+		assert.Nil(t, bs.Close())
+
+		if fileName != "" {
+			bs, err = Open(fileName)
+			assert.Nil(t, err)
+			assertKeys(t, bs.OpenBtree(root), 200)
+			assert.Nil(t, bs.Close())
+		}
+	}
+}
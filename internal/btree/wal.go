@@ -0,0 +1,362 @@
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"godb/internal/utils"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// In WAL mode, the database file is not modified by a commit. The committed pages
+// are appended to the write-ahead log as frames instead, and the WAL index maps every
+// page number to the frames that hold a version of the page. A reader looks up the
+// newest frame of a page that is not after its read mark, so it keeps seeing the
+// same snapshot while a writer appends new frames. A checkpoint copies the newest
+// version of every page back into the database file and resets the log.
+//
+// WAL header layout:
+//
+// OFFSET	SIZE	DATA
+//    0       8     magic string "godbwal\000"
+//    8       4     page size
+//   12       4     checkpoint sequence number
+//   16       4     salt-1, random number changed on every checkpoint
+//   20       4     salt-2, random number changed on every checkpoint
+//   24       4     checksum of the first 24 bytes of the header
+//   28       4     reserved
+//
+// WAL frame header layout, followed by PageSize bytes of page content:
+//
+// OFFSET	SIZE	DATA
+//    0       4     page number
+//    4       4     for a commit frame, number of pages in the database after the commit. 0 otherwise
+//    8       4     salt-1 copied from the header
+//   12       4     salt-2 copied from the header
+//   16       4     cumulative checksum of all the frames up to this one
+//   20       4     reserved
+
+const walHeaderSize = 32
+const walFrameHeaderSize = 24
+const walFrameSize = walFrameHeaderSize + PageSize
+
+// walAutoCheckpoint is the number of frames in the log that trigger a checkpoint after commit.
+const walAutoCheckpoint = 1000
+
+var walMagic = []byte("godbwal\000")
+
+var (
+	ErrorCheckpointBusy = errors.New("checkpoint blocked by an open reader")
+)
+
+// walName return the name of the write-ahead log of the database file.
+func walName(fileName string) string {
+	return fileName + "-wal"
+}
+
+// openWal open the write-ahead log and rebuild the WAL index from the valid frames.
+func (pgr *pager) openWal() error {
+	f, err := os.OpenFile(walName(pgr.FileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	pgr.Wal = f
+	pgr.WalIndex = make(map[PageNumber][]uint32)
+	pgr.WalFrames = 0
+	if err := pgr.recoverWal(); err != nil {
+		f.Close()
+		pgr.Wal = nil
+		return err
+	}
+	return nil
+}
+
+// recoverWal read the log and index every frame up to the last valid commit frame.
+// The frames after the last commit frame belong to a transaction that never committed.
+func (pgr *pager) recoverWal() error {
+	hdr := make([]byte, walHeaderSize)
+	n, err := pgr.Wal.ReadAt(hdr, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n < walHeaderSize || !bytes.Equal(hdr[:8], walMagic) ||
+		utils.GetUint32(hdr[24:]) != crc32.ChecksumIEEE(hdr[:24]) {
+		// an empty or broken log is started over
+		return pgr.resetWal(0)
+	}
+	pgr.WalSequence = utils.GetUint32(hdr[12:])
+	pgr.WalSalt1 = utils.GetUint32(hdr[16:])
+	pgr.WalSalt2 = utils.GetUint32(hdr[20:])
+	checksum := utils.GetUint32(hdr[24:])
+	frame := make([]byte, walFrameSize)
+	pending := map[PageNumber][]uint32{}
+	for i := uint32(1); ; i++ {
+		if _, err := pgr.Wal.ReadAt(frame, walFrameOffset(i)); err != nil {
+			break
+		}
+		if utils.GetUint32(frame[8:]) != pgr.WalSalt1 || utils.GetUint32(frame[12:]) != pgr.WalSalt2 {
+			break
+		}
+		checksum = walChecksum(checksum, frame)
+		if utils.GetUint32(frame[16:]) != checksum {
+			break
+		}
+		pageNo := PageNumber(utils.GetUint32(frame[0:]))
+		pending[pageNo] = append(pending[pageNo], i)
+		if size := utils.GetUint32(frame[4:]); size != 0 {
+			// a commit frame, all the frames before it are committed
+			for no, frames := range pending {
+				pgr.WalIndex[no] = append(pgr.WalIndex[no], frames...)
+			}
+			pending = map[PageNumber][]uint32{}
+			pgr.WalFrames = i
+			pgr.WalChecksum = checksum
+			pgr.WalPageNumber = PageNumber(size)
+		}
+	}
+	if pgr.WalFrames == 0 {
+		pgr.WalChecksum = utils.GetUint32(hdr[24:])
+	}
+	return nil
+}
+
+// resetWal truncate the log and write a new header with new salts.
+func (pgr *pager) resetWal(sequence uint32) error {
+	if err := pgr.Wal.Truncate(0); err != nil {
+		return err
+	}
+	pgr.WalSequence = sequence
+	pgr.WalSalt1 = rand.Uint32()
+	pgr.WalSalt2 = rand.Uint32()
+	hdr := make([]byte, walHeaderSize)
+	copy(hdr, walMagic)
+	utils.SetUint32(hdr[8:], PageSize)
+	utils.SetUint32(hdr[12:], pgr.WalSequence)
+	utils.SetUint32(hdr[16:], pgr.WalSalt1)
+	utils.SetUint32(hdr[20:], pgr.WalSalt2)
+	utils.SetUint32(hdr[24:], crc32.ChecksumIEEE(hdr[:24]))
+	if _, err := pgr.Wal.WriteAt(hdr, 0); err != nil {
+		return err
+	}
+	pgr.WalChecksum = utils.GetUint32(hdr[24:])
+	pgr.WalIndex = make(map[PageNumber][]uint32)
+	pgr.WalFrames = 0
+	pgr.WalPageNumber = 0
+	return pgr.Wal.Sync()
+}
+
+func walFrameOffset(frame uint32) int64 {
+	return walHeaderSize + int64(frame-1)*walFrameSize
+}
+
+// lockExclusive take the exclusive lock before the database file is checkpointed.
+// return the level the lock go back to after the checkpoint.
+func (pgr *pager) lockExclusive() (LockLevel, error) {
+	prev := pgr.Locked
+	if err := pgr.lock(LockExclusive); err != nil {
+		pgr.unlock(prev)
+		if err == ErrorBusy {
+			err = ErrorCheckpointBusy
+		}
+		return prev, err
+	}
+	return prev, nil
+}
+
+// walChanged return true if another process appended frames to the log, reset it,
+// or replaced it since the WAL index was built.
+func (pgr *pager) walChanged() (bool, error) {
+	info, err := pgr.Wal.Stat()
+	if err != nil {
+		return false, err
+	}
+	if named, err := os.Stat(walName(pgr.FileName)); err != nil || !os.SameFile(info, named) {
+		return true, nil
+	}
+	if info.Size() != walFrameOffset(pgr.WalFrames+1) {
+		return true, nil
+	}
+	hdr := make([]byte, walHeaderSize)
+	if _, err := pgr.Wal.ReadAt(hdr, 0); err != nil {
+		return false, err
+	}
+	return utils.GetUint32(hdr[16:]) != pgr.WalSalt1 || utils.GetUint32(hdr[20:]) != pgr.WalSalt2, nil
+}
+
+// walChecksum compute the cumulative checksum of the frame, the checksum field
+// and the reserved field of the frame header are not covered.
+func walChecksum(prev uint32, frame []byte) uint32 {
+	checksum := crc32.Update(prev, crc32.IEEETable, frame[:16])
+	return crc32.Update(checksum, crc32.IEEETable, frame[walFrameHeaderSize:walFrameSize])
+}
+
+// walFindFrame return the newest frame of the page that is visible to the reader.
+// return 0 if the page is not in the log.
+func (pgr *pager) walFindFrame(pageNo PageNumber) uint32 {
+	mark := pgr.WalFrames
+	if pgr.InReadTxn {
+		mark = pgr.ReadMark
+	}
+	frames := pgr.WalIndex[pageNo]
+	for i := len(frames) - 1; i >= 0; i-- {
+		if frames[i] <= mark {
+			return frames[i]
+		}
+	}
+	return 0
+}
+
+// walReadPage read the content of the frame into raw.
+func (pgr *pager) walReadPage(frame uint32, raw []byte) error {
+	n, err := pgr.Wal.ReadAt(raw[:PageSize], walFrameOffset(frame)+walFrameHeaderSize)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n != PageSize {
+		return ErrorShortRead
+	}
+	return nil
+}
+
+// walCommit append the dirty pages to the log. The last frame is the commit frame.
+func (pgr *pager) walCommit(dirty []*PageCacheEntry) error {
+	if len(dirty) == 0 {
+		return nil
+	}
+	frame := make([]byte, walFrameSize)
+	checksum := pgr.WalChecksum
+	for i, pce := range dirty {
+		no := pgr.WalFrames + uint32(i) + 1
+		copy(frame, make([]byte, walFrameHeaderSize))
+		utils.SetUint32(frame[0:], uint32(pce.PageNo))
+		if i == len(dirty)-1 {
+			utils.SetUint32(frame[4:], uint32(pgr.PageNumber))
+		}
+		utils.SetUint32(frame[8:], pgr.WalSalt1)
+		utils.SetUint32(frame[12:], pgr.WalSalt2)
+		copy(frame[walFrameHeaderSize:], pce.Data.RawData[:PageSize])
+		checksum = walChecksum(checksum, frame)
+		utils.SetUint32(frame[16:], checksum)
+		if _, err := pgr.Wal.WriteAt(frame, walFrameOffset(no)); err != nil {
+			return err
+		}
+	}
+	// the transaction is committed once the commit frame is on the disk
+	if err := pgr.Wal.Sync(); err != nil {
+		return err
+	}
+	for i, pce := range dirty {
+		pgr.WalIndex[pce.PageNo] = append(pgr.WalIndex[pce.PageNo], pgr.WalFrames+uint32(i)+1)
+		pce.Dirty = false
+	}
+	pgr.WalFrames += uint32(len(dirty))
+	pgr.WalChecksum = checksum
+	pgr.WalPageNumber = pgr.PageNumber
+	return nil
+}
+
+// Checkpoint copy the newest version of every page in the log back to the database
+// file and reset the log. It does nothing if the database is not in WAL mode.
+func (pgr *pager) Checkpoint() error {
+	if pgr.Wal == nil || pgr.WalFrames == 0 {
+		return nil
+	}
+	if pgr.InWriteTxn || pgr.InReadTxn {
+		return ErrorCheckpointBusy
+	}
+	prev, err := pgr.lockExclusive()
+	if err != nil {
+		return err
+	}
+	defer pgr.unlock(prev)
+	pages := make([]PageNumber, 0, len(pgr.WalIndex))
+	for pageNo := range pgr.WalIndex {
+		pages = append(pages, pageNo)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i] < pages[j] })
+	raw := make([]byte, PageSize)
+	for _, pageNo := range pages {
+		if pageNo > pgr.WalPageNumber {
+			continue
+		}
+		if err := pgr.walReadPage(pgr.walFindFrame(pageNo), raw); err != nil {
+			return err
+		}
+		if err := pgr.writePage(pageNo, raw); err != nil {
+			return err
+		}
+	}
+	if err := pgr.File.Truncate(int64(pgr.WalPageNumber) * PageSize); err != nil {
+		return err
+	}
+	pgr.FileSize = int64(pgr.WalPageNumber) * PageSize
+	if err := pgr.File.Sync(); err != nil {
+		return err
+	}
+	return pgr.resetWal(pgr.WalSequence + 1)
+}
+
+// BeginRead take a snapshot of the database. The pages read before EndRead do not
+// see the frames committed after BeginRead.
+func (pgr *pager) BeginRead() {
+	pgr.InReadTxn = true
+	pgr.ReadMark = pgr.WalFrames
+}
+
+// EndRead release the snapshot taken by BeginRead.
+func (pgr *pager) EndRead() {
+	pgr.InReadTxn = false
+	pgr.ReadMark = 0
+}
+
+// SetJournalMode switch the pager between the rollback journal and the write-ahead log.
+// It must not be called inside a write transaction.
+func (pgr *pager) SetJournalMode(mode uint8) error {
+	if pgr.InWriteTxn {
+		return ErrorCheckpointBusy
+	}
+	if pgr.File == nil || mode == pgr.JournalMode {
+		pgr.JournalMode = mode
+		return nil
+	}
+	switch mode {
+	case JournalModeWal:
+		if err := pgr.openWal(); err != nil {
+			return err
+		}
+	case JournalModeDelete:
+		if err := pgr.closeWal(); err != nil {
+			return err
+		}
+	default:
+		return ErrorUnsupportedVersion
+	}
+	pgr.JournalMode = mode
+	return nil
+}
+
+// closeWal checkpoint the log, then close and delete it. The log is deleted under the
+// exclusive lock, so that no other process is using it.
+func (pgr *pager) closeWal() error {
+	if pgr.Wal == nil {
+		return nil
+	}
+	prev, err := pgr.lockExclusive()
+	if err != nil {
+		return err
+	}
+	defer pgr.unlock(prev)
+	if err := pgr.Checkpoint(); err != nil {
+		return err
+	}
+	err = pgr.Wal.Close()
+	pgr.Wal = nil
+	pgr.WalIndex = nil
+	if err != nil {
+		return err
+	}
+	return os.Remove(walName(pgr.FileName))
+}
@@ -0,0 +1,118 @@
+package btree
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalCommit(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	bs, err := Open(fileName)
+	assert.Nil(t, err)
+	assert.Nil(t, bs.SetJournalMode(JournalModeWal))
+	stat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	size := stat.Size()
+
+	// the commit only append frames to the log
+	root := setupCommitted(t, bs, 500)
+	stat, err = os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, size, stat.Size())
+	_, err = os.Stat(journalName(fileName))
+	assert.True(t, os.IsNotExist(err))
+	wal, err := os.Stat(walName(fileName))
+	assert.Nil(t, err)
+	assert.True(t, wal.Size() > walHeaderSize)
+
+	// a rollback discard the uncommitted pages
+	bt := bs.OpenBtree(root)
+	modify(t, bt, 500)
+	assert.Nil(t, bs.Rollback())
+	assertKeys(t, bt, 500)
+
+	// the checkpoint copy the pages back and reset the log
+	assert.Nil(t, bs.Pager.Checkpoint())
+	stat, err = os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(bs.NumPage)*PageSize, stat.Size())
+	wal, err = os.Stat(walName(fileName))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(walHeaderSize), wal.Size())
+	assertKeys(t, bt, 500)
+	assert.Nil(t, bs.Close())
+
+	// the journal mode is kept in the header
+	bs, err = Open(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, JournalModeWal, bs.Header.JournalMode)
+	assertKeys(t, bs.OpenBtree(root), 500)
+	assert.Nil(t, bs.SetJournalMode(JournalModeDelete))
+	_, err = os.Stat(walName(fileName))
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, bs.Close())
+
+	bs, err = Open(fileName)
+	assert.Nil(t, err)
+	defer bs.Close()
+	assert.Equal(t, JournalModeDelete, bs.Header.JournalMode)
+	assertKeys(t, bs.OpenBtree(root), 500)
+}
+
+func TestWalRecovery(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "test.db")
+	bs, err := Open(fileName)
+	assert.Nil(t, err)
+	assert.Nil(t, bs.SetJournalMode(JournalModeWal))
+	root := setupCommitted(t, bs, 100)
+	bt := bs.OpenBtree(root)
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, bt.Insert(uint32(i), payloadOf(uint32(i))))
+	}
+	assert.Nil(t, bs.Commit())
+	// simulate a crash before the checkpoint by copying the files aside
+	crashed := filepath.Join(dir, "crashed.db")
+	raw, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(crashed, raw, 0644))
+	wal, err := os.ReadFile(walName(fileName))
+	assert.Nil(t, err)
+	// frames of an uncommitted transaction at the end of the log are ignored
+	garbage := make([]byte, walFrameSize)
+	garbage[3] = 2
+	wal = append(wal, garbage...)
+	assert.Nil(t, os.WriteFile(walName(crashed), wal, 0644))
+	assert.Nil(t, bs.Close())
+
+	bs, err = Open(crashed)
+	assert.Nil(t, err)
+	defer bs.Close()
+	assertKeys(t, bs.OpenBtree(root), 200)
+}
+
+func TestWalReadSnapshot(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	bs, err := Open(fileName)
+	assert.Nil(t, err)
+	defer bs.Close()
+	assert.Nil(t, bs.SetJournalMode(JournalModeWal))
+	root := setupCommitted(t, bs, 10)
+
+	pgr := bs.Pager.(*pager)
+	bs.Pager.BeginRead()
+	mark := pgr.ReadMark
+	bt := bs.OpenBtree(root)
+	assert.Nil(t, bt.Insert(10, payloadOf(10)))
+	assert.Nil(t, bs.Commit())
+	// the frames committed after BeginRead are hidden from the reader
+	assert.True(t, pgr.WalFrames > mark)
+	for pageNo := range pgr.WalIndex {
+		assert.True(t, pgr.walFindFrame(pageNo) <= mark)
+	}
+	assert.Equal(t, ErrorCheckpointBusy, bs.Pager.Checkpoint())
+	bs.Pager.EndRead()
+	assert.Nil(t, bs.Pager.Checkpoint())
+}
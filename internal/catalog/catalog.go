@@ -0,0 +1,403 @@
+package catalog
+
+import (
+	"errors"
+	"godb/internal/btree"
+	"godb/internal/parser"
+	"godb/internal/record"
+	"math"
+	"sort"
+	"strings"
+)
+
+// The catalog is the schema table rooted at page 1. Every table and index in the
+// database has a row in the schema table, the key of the row is a rowid. A schema row is a record
+// as if the schema table were created by:
+//
+//	create table godb_master (type varchar, name varchar, tbl_name varchar, rootpage integer, sql varchar)
+
+// SchemaRootPageNo is the root page of the schema table.
+const SchemaRootPageNo btree.PageNumber = 1
+
+// SchemaTableName is the name of the schema table.
+const SchemaTableName = "godb_master"
+
+// schemaTypes is the column types of the schema table.
+var schemaTypes = []parser.ColumnType{
+	parser.NewColumnType(parser.VarTypeVarchar, math.MaxInt32),
+	parser.NewColumnType(parser.VarTypeVarchar, math.MaxInt32),
+	parser.NewColumnType(parser.VarTypeVarchar, math.MaxInt32),
+	parser.NewColumnType(parser.VarTypeInteger, 0),
+	parser.NewColumnType(parser.VarTypeVarchar, math.MaxInt32),
+}
+
+// The object types in the schema table.
+const (
+	ObjectTable = "table"
+	ObjectIndex = "index"
+)
+
+var (
+	ErrorTableExists   = errors.New("table already exists")
+	ErrorNoSuchTable   = errors.New("no such table")
+	ErrorCorruptSchema = errors.New("malformed database schema")
+	ErrorNoTable       = errors.New("statement does not refer to a table")
+	ErrorIndexExists   = errors.New("index already exists")
+	ErrorNoSuchColumn  = errors.New("no such column")
+)
+
+// Object is a row of the schema table.
+type Object struct {
+	Rowid      uint32           // key of the row in the schema table
+	Type       string           // object type, ObjectTable or ObjectIndex
+	Name       string           // name of the object
+	TableName  string           // name of the table the object belongs to
+	RootPageNo btree.PageNumber // root page of the object's btree
+	SQL        string           // the original sql text that create the object
+}
+
+// Table is the schema of a table.
+type Table struct {
+	Object
+	Columns []string            // column names
+	Types   []parser.ColumnType // column types
+	Indexes []*Index            // indexes on the table, in creation order
+}
+
+// Index is the schema of an index. The key of an index entry is the values of the
+// indexed columns followed by the rowid, see record.IndexKey.
+type Index struct {
+	Object
+	Columns []int // positions of the indexed columns in the table, in key order
+	Unique  bool  // true if no two rows may have the same non NULL values in the columns
+}
+
+// ColumnIndex return the index of the column with the name, or -1 if there is no such
+// column. Column names are case insensitive.
+func (table *Table) ColumnIndex(name string) int {
+	for i, column := range table.Columns {
+		if strings.EqualFold(column, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Catalog is the in memory copy of the schema table.
+type Catalog struct {
+	Shared  *btree.Shared     // shared btree content of the database
+	Schema  btree.Btree       // the schema table
+	Tables  map[string]*Table // tables by lower case name
+	Indexes map[string]*Index // indexes by lower case name
+	NextRow uint32            // rowid of the next schema row
+	Cookie  uint32            // the schema cookie when the catalog is loaded
+}
+
+// Open load the catalog of the database.
+func Open(bs *btree.Shared) (*Catalog, error) {
+	c := &Catalog{Shared: bs, Schema: bs.OpenBtree(SchemaRootPageNo)}
+	if err := c.Load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load read all the rows of the schema table. The tables are loaded before the
+// indexes that refer to them.
+func (c *Catalog) Load() error {
+	c.Tables = make(map[string]*Table)
+	c.Indexes = make(map[string]*Index)
+	c.NextRow = 1
+	var indexes []Object
+	cursor := c.Schema.Cursor()
+	defer cursor.Close()
+	if err := cursor.MoveToRoot(); err != nil {
+		return err
+	}
+	if err := cursor.MoveToLeftMost(); err != nil {
+		return err
+	}
+	for !cursor.Eof() {
+		data, err := cursor.Data()
+		if err != nil {
+			return err
+		}
+		obj, err := decodeObject(data)
+		if err != nil {
+			return err
+		}
+		obj.Rowid = cursor.Key()
+		if obj.Type == ObjectIndex {
+			indexes = append(indexes, obj)
+		} else if err := c.addObject(obj); err != nil {
+			return err
+		}
+		if obj.Rowid >= c.NextRow {
+			c.NextRow = obj.Rowid + 1
+		}
+		if err := cursor.MoveNext(); err != nil {
+			return err
+		}
+	}
+	for _, obj := range indexes {
+		if err := c.addObject(obj); err != nil {
+			return err
+		}
+	}
+	c.Cookie = c.Shared.Header.SchemaCookie
+	return nil
+}
+
+// refresh load the catalog again if the schema is changed by a commit or a rollback.
+func (c *Catalog) refresh() error {
+	if c.Cookie == c.Shared.Header.SchemaCookie {
+		return nil
+	}
+	return c.Load()
+}
+
+// addObject add a schema row into the in memory catalog.
+func (c *Catalog) addObject(obj Object) error {
+	switch obj.Type {
+	case ObjectTable:
+		stmt, err := parser.Parse(obj.SQL)
+		if err != nil {
+			return ErrorCorruptSchema
+		}
+		ct, ok := stmt.(parser.CreateTableStatement)
+		if !ok {
+			return ErrorCorruptSchema
+		}
+		c.Tables[strings.ToLower(obj.Name)] = &Table{Object: obj, Columns: ct.FieldName, Types: ct.FiledType}
+		return nil
+	case ObjectIndex:
+		stmt, err := parser.Parse(obj.SQL)
+		if err != nil {
+			return ErrorCorruptSchema
+		}
+		ci, ok := stmt.(parser.CreateIndexStatement)
+		if !ok {
+			return ErrorCorruptSchema
+		}
+		table, ok := c.Tables[strings.ToLower(obj.TableName)]
+		if !ok {
+			return ErrorCorruptSchema
+		}
+		index, err := newIndex(obj, table, ci)
+		if err != nil {
+			return ErrorCorruptSchema
+		}
+		c.addIndex(table, index)
+		return nil
+	default:
+		return ErrorCorruptSchema
+	}
+}
+
+// CreateTable allocate the root page of a new table and record it in the schema table.
+// sql is the original text of the statement.
+func (c *Catalog) CreateTable(stmt parser.CreateTableStatement, sql string) (*Table, error) {
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(stmt.TableName)
+	if _, ok := c.Tables[name]; ok || name == SchemaTableName {
+		return nil, ErrorTableExists
+	}
+	// tables and indexes share the same namespace
+	if _, ok := c.Indexes[name]; ok {
+		return nil, ErrorIndexExists
+	}
+	root, err := c.Shared.CreateBtree(btree.PAGE_DATA | btree.PAGE_LEAF_DATA)
+	if err != nil {
+		return nil, err
+	}
+	obj := Object{
+		Rowid:      c.NextRow,
+		Type:       ObjectTable,
+		Name:       stmt.TableName,
+		TableName:  stmt.TableName,
+		RootPageNo: root,
+		SQL:        strings.TrimSpace(sql),
+	}
+	raw, err := encodeObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Schema.Insert(obj.Rowid, raw); err != nil {
+		return nil, err
+	}
+	if err := c.Shared.ChangeSchema(); err != nil {
+		return nil, err
+	}
+	c.NextRow++
+	table := &Table{Object: obj, Columns: stmt.FieldName, Types: stmt.FiledType}
+	c.Tables[name] = table
+	c.Cookie = c.Shared.Header.SchemaCookie
+	return table, nil
+}
+
+// newIndex resolve the columns of an index on the table.
+func newIndex(obj Object, table *Table, stmt parser.CreateIndexStatement) (*Index, error) {
+	index := &Index{Object: obj, Unique: stmt.Unique}
+	for _, name := range stmt.Columns {
+		idx := table.ColumnIndex(name)
+		if idx < 0 {
+			return nil, ErrorNoSuchColumn
+		}
+		index.Columns = append(index.Columns, idx)
+	}
+	return index, nil
+}
+
+func (c *Catalog) addIndex(table *Table, index *Index) {
+	table.Indexes = append(table.Indexes, index)
+	c.Indexes[strings.ToLower(index.Name)] = index
+}
+
+// CreateIndex allocate the root page of a new index and record it in the schema table.
+// The index is empty, the caller fill it with the rows already in the table.
+func (c *Catalog) CreateIndex(stmt parser.CreateIndexStatement, sql string) (*Index, error) {
+	table, err := c.GetTable(stmt.TableName)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(stmt.IndexName)
+	if _, ok := c.Indexes[name]; ok {
+		return nil, ErrorIndexExists
+	}
+	if _, ok := c.Tables[name]; ok || name == SchemaTableName {
+		return nil, ErrorTableExists
+	}
+	obj := Object{
+		Rowid:     c.NextRow,
+		Type:      ObjectIndex,
+		Name:      stmt.IndexName,
+		TableName: table.Name,
+		SQL:       strings.TrimSpace(sql),
+	}
+	index, err := newIndex(obj, table, stmt)
+	if err != nil {
+		return nil, err
+	}
+	index.RootPageNo, err = c.Shared.CreateBtree(btree.PAGE_INDEX)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := encodeObject(index.Object)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Schema.Insert(index.Rowid, raw); err != nil {
+		return nil, err
+	}
+	if err := c.Shared.ChangeSchema(); err != nil {
+		return nil, err
+	}
+	c.NextRow++
+	c.addIndex(table, index)
+	c.Cookie = c.Shared.Header.SchemaCookie
+	return index, nil
+}
+
+// DropTable remove the table and everything that belongs to it from the schema
+// table, and put all their pages on the freelist. return false if there is no such
+// table and ifExists is true.
+func (c *Catalog) DropTable(name string, ifExists bool) (bool, error) {
+	table, err := c.GetTable(name)
+	if err == ErrorNoSuchTable && ifExists {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	for _, index := range table.Indexes {
+		if err := c.Shared.DropBtree(index.RootPageNo); err != nil {
+			return false, err
+		}
+		if err := c.Schema.Delete(index.Rowid); err != nil {
+			return false, err
+		}
+	}
+	if err := c.Shared.DropBtree(table.RootPageNo); err != nil {
+		return false, err
+	}
+	if err := c.Schema.Delete(table.Rowid); err != nil {
+		return false, err
+	}
+	if err := c.Shared.ChangeSchema(); err != nil {
+		return false, err
+	}
+	// the catalog is changed only once the schema table is, a failed drop is rolled
+	// back by the caller and the catalog still match the schema table
+	for _, index := range table.Indexes {
+		delete(c.Indexes, strings.ToLower(index.Name))
+	}
+	delete(c.Tables, strings.ToLower(table.Name))
+	c.Cookie = c.Shared.Header.SchemaCookie
+	return true, nil
+}
+
+// GetTable return the table with the name, table names are case insensitive.
+func (c *Catalog) GetTable(name string) (*Table, error) {
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+	table, ok := c.Tables[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrorNoSuchTable
+	}
+	return table, nil
+}
+
+// Resolve return the table a statement refer to.
+func (c *Catalog) Resolve(stmt interface{}) (*Table, error) {
+	switch st := stmt.(type) {
+	case parser.InsertStatement:
+		return c.GetTable(st.TableName)
+	case parser.SelectStatement:
+		return c.GetTable(st.TableName)
+	case parser.UpdateStatement:
+		return c.GetTable(st.TableName)
+	case parser.DeleteStatement:
+		return c.GetTable(st.TableName)
+	default:
+		return nil, ErrorNoTable
+	}
+}
+
+// ListTables return all the tables ordered by name.
+func (c *Catalog) ListTables() ([]*Table, error) {
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+	tables := make([]*Table, 0, len(c.Tables))
+	for _, table := range c.Tables {
+		tables = append(tables, table)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return tables, nil
+}
+
+func encodeObject(obj Object) ([]byte, error) {
+	return record.Encode(schemaTypes, []parser.ColumnValue{
+		parser.VarcharValue(obj.Type),
+		parser.VarcharValue(obj.Name),
+		parser.VarcharValue(obj.TableName),
+		parser.IntegerValue(int32(obj.RootPageNo)),
+		parser.VarcharValue(obj.SQL),
+	})
+}
+
+func decodeObject(raw []byte) (Object, error) {
+	values, err := record.Decode(schemaTypes, raw)
+	if err != nil {
+		return Object{}, ErrorCorruptSchema
+	}
+	return Object{
+		Type:       values[0].String(),
+		Name:       values[1].String(),
+		TableName:  values[2].String(),
+		RootPageNo: btree.PageNumber(values[3].Int()),
+		SQL:        values[4].String(),
+	}, nil
+}
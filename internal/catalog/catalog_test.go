@@ -0,0 +1,181 @@
+package catalog
+
+import (
+	"github.com/stretchr/testify/assert"
+	"godb/internal/btree"
+	"godb/internal/parser"
+	"path/filepath"
+	"testing"
+)
+
+func createTable(t *testing.T, c *Catalog, sql string) *Table {
+	stmt, err := parser.Parse(sql)
+	assert.Nil(t, err)
+	table, err := c.CreateTable(stmt.(parser.CreateTableStatement), sql)
+	assert.Nil(t, err)
+	return table
+}
+
+func TestCreateTable(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	bs, err := btree.Open(fileName)
+	assert.Nil(t, err)
+	c, err := Open(bs)
+	assert.Nil(t, err)
+	assert.Empty(t, c.Tables)
+	users := createTable(t, c, "create table users (id integer, name varchar(32))")
+	assert.Equal(t, []string{"id", "name"}, users.Columns)
+	assert.NotEqual(t, SchemaRootPageNo, users.RootPageNo)
+	posts := createTable(t, c, "create table posts (id integer)")
+	assert.NotEqual(t, users.RootPageNo, posts.RootPageNo)
+
+	stmt, err := parser.Parse("create table Users (id integer)")
+	assert.Nil(t, err)
+	_, err = c.CreateTable(stmt.(parser.CreateTableStatement), "create table Users (id integer)")
+	assert.Equal(t, ErrorTableExists, err)
+	assert.Nil(t, bs.Close())
+
+	// the catalog is loaded from the schema table on open
+	bs, err = btree.Open(fileName)
+	assert.Nil(t, err)
+	defer bs.Close()
+	c, err = Open(bs)
+	assert.Nil(t, err)
+	tables, err := c.ListTables()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(tables))
+	assert.Equal(t, "posts", tables[0].Name)
+	assert.Equal(t, users.Object, tables[1].Object)
+	assert.Equal(t, users.Types, tables[1].Types)
+
+	insert, err := parser.Parse("insert into users values (1, 'a')")
+	assert.Nil(t, err)
+	table, err := c.Resolve(insert)
+	assert.Nil(t, err)
+	assert.Equal(t, users.RootPageNo, table.RootPageNo)
+	sel, err := parser.Parse("select * from missing")
+	assert.Nil(t, err)
+	_, err = c.Resolve(sel)
+	assert.Equal(t, ErrorNoSuchTable, err)
+}
+
+func TestCatalogRollback(t *testing.T) {
+	bs, err := btree.Open("")
+	assert.Nil(t, err)
+	defer bs.Close()
+	c, err := Open(bs)
+	assert.Nil(t, err)
+	createTable(t, c, "create table a (id integer)")
+	assert.Nil(t, bs.Commit())
+	createTable(t, c, "create table b (id integer)")
+	assert.Nil(t, bs.Rollback())
+	// the schema cookie tell the catalog to load the schema table again
+	_, err = c.GetTable("b")
+	assert.Equal(t, ErrorNoSuchTable, err)
+	_, err = c.GetTable("a")
+	assert.Nil(t, err)
+}
+
+func TestDropTable(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	bs, err := btree.Open(fileName)
+	assert.Nil(t, err)
+	c, err := Open(bs)
+	assert.Nil(t, err)
+	createTable(t, c, "create table a (id integer)")
+	b := createTable(t, c, "create table b (id integer)")
+	dropped, err := c.DropTable("A", false)
+	assert.Nil(t, err)
+	assert.True(t, dropped)
+	dropped, err = c.DropTable("a", true)
+	assert.Nil(t, err)
+	assert.False(t, dropped)
+	_, err = c.DropTable("a", false)
+	assert.Equal(t, ErrorNoSuchTable, err)
+	assert.Equal(t, uint32(1), bs.Header.FreelistCount)
+	assert.Nil(t, bs.Close())
+
+	bs, err = btree.Open(fileName)
+	assert.Nil(t, err)
+	defer bs.Close()
+	c, err = Open(bs)
+	assert.Nil(t, err)
+	tables, err := c.ListTables()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tables))
+	assert.Equal(t, b.Object, tables[0].Object)
+	// the freed root page is reused by the next table
+	a := createTable(t, c, "create table a (id integer)")
+	assert.Equal(t, uint32(0), bs.Header.FreelistCount)
+	assert.True(t, uint32(a.RootPageNo) <= bs.NumPage)
+
+	// a failed drop leave the catalog as it is
+	sql := "create index idx_a on a (id)"
+	stmt, err := parser.Parse(sql)
+	assert.Nil(t, err)
+	index, err := c.CreateIndex(stmt.(parser.CreateIndexStatement), sql)
+	assert.Nil(t, err)
+	assert.Nil(t, bs.Commit())
+	root := a.RootPageNo
+	a.RootPageNo = 1
+	_, err = c.DropTable("a", false)
+	assert.Equal(t, btree.ErrorFreePage, err)
+	assert.Nil(t, bs.Rollback())
+	a.RootPageNo = root
+	assert.Equal(t, index, c.Indexes["idx_a"])
+	table, err := c.GetTable("a")
+	assert.Nil(t, err)
+	assert.Equal(t, a, table)
+}
+
+func TestCreateIndex(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	bs, err := btree.Open(fileName)
+	assert.Nil(t, err)
+	c, err := Open(bs)
+	assert.Nil(t, err)
+	createTable(t, c, "create table users (id integer, name varchar(32), age integer)")
+	createIndex := func(sql string) (*Index, error) {
+		stmt, err := parser.Parse(sql)
+		assert.Nil(t, err)
+		return c.CreateIndex(stmt.(parser.CreateIndexStatement), sql)
+	}
+	index, err := createIndex("create unique index idx_name on USERS (name, age)")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, index.Columns)
+	assert.True(t, index.Unique)
+	assert.Equal(t, "users", index.TableName)
+	_, err = createIndex("create index IDX_NAME on users (id)")
+	assert.Equal(t, ErrorIndexExists, err)
+	_, err = createIndex("create index users on users (id)")
+	assert.Equal(t, ErrorTableExists, err)
+	_, err = createIndex("create index idx_missing on users (missing)")
+	assert.Equal(t, ErrorNoSuchColumn, err)
+	_, err = createIndex("create index idx_missing on missing (id)")
+	assert.Equal(t, ErrorNoSuchTable, err)
+	assert.Nil(t, bs.Close())
+
+	bs, err = btree.Open(fileName)
+	assert.Nil(t, err)
+	c, err = Open(bs)
+	assert.Nil(t, err)
+	users, err := c.GetTable("users")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(users.Indexes))
+	assert.Equal(t, index.Object, users.Indexes[0].Object)
+	assert.Equal(t, index.Columns, users.Indexes[0].Columns)
+	// dropping the table drop its indexes too
+	_, err = c.DropTable("users", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(c.Indexes))
+	assert.Equal(t, uint32(2), bs.Header.FreelistCount)
+	assert.Nil(t, bs.Close())
+
+	bs, err = btree.Open(fileName)
+	assert.Nil(t, err)
+	defer bs.Close()
+	c, err = Open(bs)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(c.Tables))
+	assert.Equal(t, 0, len(c.Indexes))
+}
@@ -1,5 +1,612 @@
 package executor
 
+import (
+	"context"
+	"errors"
+	"godb/internal/btree"
+	"godb/internal/catalog"
+	"godb/internal/parser"
+	"godb/internal/record"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrorUnsupportedStatement = errors.New("unsupported statement")
+	ErrorTableFull            = errors.New("table is full, no more rowid")
+	ErrorOrderByPosition      = errors.New("ORDER BY term out of range")
+)
+
+// Executable is a statement ready to run against the database.
 type Executable interface {
 	execute() error
 }
+
+// Result is the outcome of a statement.
+type Result struct {
+	Columns      []string               // column names of the result rows
+	Types        []parser.ColumnType    // column types of the result rows
+	Rows         [][]parser.ColumnValue // rows returned by a query
+	RowsAffected int                    // number of rows changed by the statement
+}
+
+// Executor run the parsed statements against a database. Several executors can share
+// the same database through their own connection, each executor is used by one
+// goroutine at a time.
+type Executor struct {
+	Shared     *btree.Shared    // shared btree content of the database
+	Conn       *btree.Conn      // connection of the executor on the shared content
+	Catalog    *catalog.Catalog // tables of the database
+	InTxn      bool             // true if an explicit transaction is open
+	Savepoints []string         // names of the open savepoints, the innermost is the last
+	autoTxn    bool             // true if the transaction is started by SAVEPOINT instead of BEGIN
+	ownShared  bool             // true if the shared content is opened by the executor
+}
+
+// Open open the database file and load its catalog. If fileName is empty, the
+// database lives in memory only.
+func Open(fileName string) (*Executor, error) {
+	bs, err := btree.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	e, err := New(bs)
+	if err != nil {
+		bs.Close()
+		return nil, err
+	}
+	e.ownShared = true
+	return e, nil
+}
+
+// New open an executor on the shared content of an open database. The shared content
+// is not closed with the executor.
+func New(bs *btree.Shared) (*Executor, error) {
+	conn := bs.Conn()
+	var c *catalog.Catalog
+	err := conn.Do(false, func() error {
+		var err error
+		c, err = catalog.Open(bs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Executor{Shared: bs, Conn: conn, Catalog: c}, nil
+}
+
+// SetBusyTimeout set how long a statement wait for the locks held by other processes,
+// or for the transactions of other executors, before it fail with btree.ErrorBusy.
+func (e *Executor) SetBusyTimeout(timeout time.Duration) {
+	e.Conn.SetBusyTimeout(timeout)
+}
+
+// Close close the database. An open transaction is rolled back.
+func (e *Executor) Close() error {
+	if e.InTxn {
+		if err := e.Rollback(); err != nil {
+			return err
+		}
+	}
+	if !e.ownShared {
+		return nil
+	}
+	return e.Shared.Close()
+}
+
+// Rollback discard all the changes not committed yet, and end the explicit transaction.
+// The transaction ends even if the rollback fails.
+func (e *Executor) Rollback() error {
+	err := e.Conn.Rollback()
+	e.endTransaction(nil)
+	return err
+}
+
+// ListTables return all the tables ordered by name.
+func (e *Executor) ListTables() ([]*catalog.Table, error) {
+	var tables []*catalog.Table
+	err := e.Conn.Do(false, func() error {
+		var err error
+		tables, err = e.Catalog.ListTables()
+		return err
+	})
+	return tables, err
+}
+
+// GetTable return the table with the name.
+func (e *Executor) GetTable(name string) (*catalog.Table, error) {
+	var table *catalog.Table
+	err := e.Conn.Do(false, func() error {
+		var err error
+		table, err = e.Catalog.GetTable(name)
+		return err
+	})
+	return table, err
+}
+
+// Execute parse and run a single statement, see ExecuteContext.
+func (e *Executor) Execute(sql string) (*Result, error) {
+	return e.ExecuteContext(context.Background(), sql)
+}
+
+// ExecuteContext parse and run a single statement. Outside an explicit transaction,
+// the statement is committed if it succeeds, and rolled back otherwise. A statement
+// with parameters must be run by Prepare, so that values are bound to them. The wait
+// for the transaction of another executor stop when ctx is done.
+func (e *Executor) ExecuteContext(ctx context.Context, sql string) (*Result, error) {
+	stmt, err := e.Prepare(sql)
+	if err != nil {
+		return nil, err
+	}
+	if stmt.NumParams() > 0 {
+		return nil, ErrorUnboundParams
+	}
+	return stmt.ExecContext(ctx)
+}
+
+// ExecuteStatement run a parsed statement, sql is the original text of the statement.
+func (e *Executor) ExecuteStatement(stmt interface{}, sql string) (*Result, error) {
+	result := new(Result)
+	exec, err := e.prepare(stmt, sql, result)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.run(context.Background(), exec); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// run run the executable in the explicit transaction, or in a transaction of its own.
+func (e *Executor) run(ctx context.Context, exec Executable) error {
+	if te, ok := exec.(*transactionExec); ok {
+		return te.executeContext(ctx)
+	} else if e.InTxn {
+		return e.executeInTransaction(ctx, exec)
+	}
+	return e.autocommit(ctx, exec)
+}
+
+// autocommit run the statement in a transaction of its own. A query run in a read
+// transaction, along with the queries of the other executors.
+func (e *Executor) autocommit(ctx context.Context, exec Executable) error {
+	_, query := exec.(*selectExec)
+	return e.Conn.DoContext(ctx, !query, exec.execute)
+}
+
+// executeInTransaction run the statement inside the explicit transaction. A failed
+// statement is rolled back alone, the transaction stay open. A query does not turn a
+// deferred transaction into a write transaction.
+func (e *Executor) executeInTransaction(ctx context.Context, exec Executable) error {
+	if _, query := exec.(*selectExec); query {
+		return e.Conn.DoContext(ctx, false, exec.execute)
+	}
+	return e.Conn.DoContext(ctx, true, func() error {
+		n := len(e.Savepoints)
+		if err := e.Shared.Savepoint(); err != nil {
+			return err
+		}
+		if err := exec.execute(); err != nil {
+			if rbErr := e.Shared.RollbackTo(n); rbErr != nil {
+				return rbErr
+			}
+			if rbErr := e.Shared.ReleaseSavepoint(n); rbErr != nil {
+				return rbErr
+			}
+			return err
+		}
+		return e.Shared.ReleaseSavepoint(n)
+	})
+}
+
+// prepare build the executable of a statement.
+func (e *Executor) prepare(stmt interface{}, sql string, result *Result) (Executable, error) {
+	switch st := stmt.(type) {
+	case parser.CreateTableStatement:
+		return &createTableExec{e, st, sql, result}, nil
+	case parser.CreateIndexStatement:
+		return &createIndexExec{e, st, sql, result}, nil
+	case parser.InsertStatement:
+		return &insertExec{exec: e, stmt: st, result: result}, nil
+	case parser.SelectStatement:
+		return &selectExec{exec: e, stmt: st, result: result}, nil
+	case parser.UpdateStatement:
+		return &updateExec{exec: e, stmt: st, result: result}, nil
+	case parser.DeleteStatement:
+		return &deleteExec{exec: e, stmt: st, result: result}, nil
+	case parser.DropTableStatement:
+		return &dropTableExec{e, st, result}, nil
+	case parser.BeginStatement, parser.CommitStatement, parser.RollbackStatement,
+		parser.SavepointStatement, parser.ReleaseStatement:
+		return &transactionExec{e, st}, nil
+	default:
+		return nil, ErrorUnsupportedStatement
+	}
+}
+
+type createTableExec struct {
+	exec   *Executor
+	stmt   parser.CreateTableStatement
+	sql    string
+	result *Result
+}
+
+func (ct *createTableExec) execute() error {
+	_, err := ct.exec.Catalog.CreateTable(ct.stmt, ct.sql)
+	return err
+}
+
+type dropTableExec struct {
+	exec   *Executor
+	stmt   parser.DropTableStatement
+	result *Result
+}
+
+func (dt *dropTableExec) execute() error {
+	_, err := dt.exec.Catalog.DropTable(dt.stmt.TableName, dt.stmt.IfExists)
+	return err
+}
+
+type insertExec struct {
+	exec   *Executor
+	stmt   parser.InsertStatement
+	result *Result
+}
+
+func (ins *insertExec) execute() error {
+	table, err := ins.exec.Catalog.Resolve(ins.stmt)
+	if err != nil {
+		return err
+	}
+	values := ins.stmt.Values
+	if ins.stmt.Params != nil {
+		values = append([]parser.ColumnValue(nil), values...)
+		for i, param := range ins.stmt.Params {
+			if values[i], err = eval(param, nil); err != nil {
+				return err
+			}
+		}
+	}
+	raw, err := record.Encode(table.Types, values)
+	if err != nil {
+		return err
+	}
+	cursor := ins.exec.Shared.OpenBtree(table.RootPageNo).Cursor()
+	defer cursor.Close()
+	rowid, err := nextRowid(cursor)
+	if err != nil {
+		return err
+	}
+	if err := cursor.Insert(rowid, raw); err != nil {
+		return err
+	}
+	if err := insertIndexes(ins.exec.Shared, table, rowid, values); err != nil {
+		return err
+	}
+	ins.result.RowsAffected = 1
+	return nil
+}
+
+// nextRowid return the rowid after the largest rowid in the table.
+func nextRowid(cursor btree.BtCursor) (uint32, error) {
+	// the cursor stop on the last entry when the key is bigger than all the keys
+	if _, err := cursor.MoveTo(math.MaxUint32); err != nil {
+		return 0, err
+	}
+	if cursor.Eof() {
+		return 1, nil
+	}
+	if cursor.Key() == math.MaxUint32 {
+		return 0, ErrorTableFull
+	}
+	return cursor.Key() + 1, nil
+}
+
+type selectExec struct {
+	exec    *Executor
+	stmt    parser.SelectStatement
+	result  *Result
+	cache   scanCache
+	exprs   []parser.Expr         // one expression per result column
+	columns []string              // the names of the result columns
+	terms   []parser.OrderingTerm // the ORDER BY terms bound to the table
+}
+
+func (sel *selectExec) execute() error {
+	table, err := sel.exec.Catalog.Resolve(sel.stmt)
+	if err != nil {
+		return err
+	}
+	if !sel.cache.resolve(table, sel.exec.Catalog.Cookie) {
+		if err := sel.bind(table); err != nil {
+			sel.cache = scanCache{}
+			return err
+		}
+	}
+	exprs, orderBy := sel.exprs, sel.terms
+	sel.result.Columns = sel.columns
+	sel.result.Types = make([]parser.ColumnType, len(exprs))
+	for i, expr := range exprs {
+		sel.result.Types[i] = exprType(expr, table)
+	}
+	p := sel.cache.planScan(sel.stmt.Where, orderBy)
+	// the sort keys of every row, only needed if the rows does not come out in order
+	var keys [][]parser.ColumnValue
+	err = scan(sel.exec.Shared, table, p, sel.stmt.Where, func(rowid uint32, values []parser.ColumnValue) error {
+		r := &row{table, values}
+		result := make([]parser.ColumnValue, len(exprs))
+		for i, expr := range exprs {
+			value, err := eval(expr, r)
+			if err != nil {
+				return err
+			}
+			result[i] = value
+		}
+		sel.result.Rows = append(sel.result.Rows, result)
+		if p.ordered || len(orderBy) == 0 {
+			return nil
+		}
+		key := make([]parser.ColumnValue, len(orderBy))
+		for i, term := range orderBy {
+			value, err := eval(term.Expr, r)
+			if err != nil {
+				return err
+			}
+			key[i] = value
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil || keys == nil {
+		return err
+	}
+	sortRows(sel.result.Rows, keys, orderBy)
+	return nil
+}
+
+// orderBy bind the ORDER BY terms to the table. A term may also be the alias or the
+// position, starting from 1, of a result column.
+func (sel *selectExec) orderBy(table *catalog.Table, exprs []parser.Expr) ([]parser.OrderingTerm, error) {
+	terms := make([]parser.OrderingTerm, len(sel.stmt.OrderBy))
+	for i, term := range sel.stmt.OrderBy {
+		switch e := term.Expr.(type) {
+		case parser.Literal:
+			if e.Value.Type() == parser.VarTypeInteger {
+				pos := int(e.Value.Int())
+				if pos < 1 || pos > len(exprs) {
+					return nil, ErrorOrderByPosition
+				}
+				term.Expr = exprs[pos-1]
+			}
+		case parser.ColumnRef:
+			if e.Table == "" && table.ColumnIndex(e.Column) < 0 {
+				for j, name := range sel.columns {
+					if strings.EqualFold(name, e.Column) {
+						term.Expr = exprs[j]
+						break
+					}
+				}
+			}
+		}
+		if err := bindExpr(term.Expr, table); err != nil {
+			return nil, err
+		}
+		terms[i] = term
+	}
+	return terms, nil
+}
+
+// sortRows sort the rows by their sort keys. NULL sort before any other value.
+func sortRows(rows [][]parser.ColumnValue, keys [][]parser.ColumnValue, orderBy []parser.OrderingTerm) {
+	idx := make([]int, len(rows))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		for i, term := range orderBy {
+			c := compareSortValues(keys[idx[a]][i], keys[idx[b]][i])
+			if term.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	sorted := make([][]parser.ColumnValue, len(rows))
+	for i, j := range idx {
+		sorted[i] = rows[j]
+	}
+	copy(rows, sorted)
+}
+
+// scanTable walk the rows of the table matching the WHERE clause, and call fn on
+// every row. An index is used if it limit the rows to scan. fn must not modify the
+// table or its indexes.
+func scanTable(bs *btree.Shared, table *catalog.Table, where parser.Expr, fn func(rowid uint32, values []parser.ColumnValue) error) error {
+	return scan(bs, table, planScan(table, where, nil), where, fn)
+}
+
+type updateExec struct {
+	exec    *Executor
+	stmt    parser.UpdateStatement
+	result  *Result
+	cache   scanCache
+	columns []int // the index of every column SET
+}
+
+func (upd *updateExec) execute() error {
+	table, err := upd.exec.Catalog.Resolve(upd.stmt)
+	if err != nil {
+		return err
+	}
+	if !upd.cache.resolve(table, upd.exec.Catalog.Cookie) {
+		if err := upd.bind(table); err != nil {
+			upd.cache = scanCache{}
+			return err
+		}
+	}
+	columns := upd.columns
+	// the new rows are computed before any row is rewritten, so that the scan is
+	// not disturbed by the changes of the btree
+	type change struct {
+		rowid  uint32
+		old    []parser.ColumnValue
+		values []parser.ColumnValue
+		raw    []byte
+	}
+	var changes []change
+	p := upd.cache.planScan(upd.stmt.Where, nil)
+	err = scan(upd.exec.Shared, table, p, upd.stmt.Where, func(rowid uint32, values []parser.ColumnValue) error {
+		updated := append([]parser.ColumnValue{}, values...)
+		for i, idx := range columns {
+			// every new value is computed from the old row
+			value, err := eval(upd.stmt.Values[i], &row{table, values})
+			if err != nil {
+				return err
+			}
+			updated[idx] = value
+		}
+		raw, err := record.Encode(table.Types, updated)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, change{rowid, values, updated, raw})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cursor := upd.exec.Shared.OpenBtree(table.RootPageNo).Cursor()
+	defer cursor.Close()
+	for _, c := range changes {
+		// the cell is rewritten by removing the old one and inserting the new one
+		if err := cursor.Delete(c.rowid); err != nil {
+			return err
+		}
+		if err := cursor.Insert(c.rowid, c.raw); err != nil {
+			return err
+		}
+		if err := deleteIndexes(upd.exec.Shared, table, c.rowid, c.old); err != nil {
+			return err
+		}
+	}
+	// the new index entries are added after all the old ones are removed, so that
+	// the uniqueness is checked against the table after the whole statement
+	for _, c := range changes {
+		if err := insertIndexes(upd.exec.Shared, table, c.rowid, c.values); err != nil {
+			return err
+		}
+	}
+	upd.result.RowsAffected = len(changes)
+	return nil
+}
+
+// bind bind the statement to the table, the columns are kept for the next runs of a
+// prepared statement.
+func (upd *updateExec) bind(table *catalog.Table) error {
+	if err := bindExpr(upd.stmt.Where, table); err != nil {
+		return err
+	}
+	upd.columns = make([]int, len(upd.stmt.Columns))
+	for i, name := range upd.stmt.Columns {
+		if upd.columns[i] = table.ColumnIndex(name); upd.columns[i] < 0 {
+			return ErrorNoSuchColumn
+		}
+		if err := bindExpr(upd.stmt.Values[i], table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type deleteExec struct {
+	exec   *Executor
+	stmt   parser.DeleteStatement
+	result *Result
+	cache  scanCache
+}
+
+func (del *deleteExec) execute() error {
+	table, err := del.exec.Catalog.Resolve(del.stmt)
+	if err != nil {
+		return err
+	}
+	if !del.cache.resolve(table, del.exec.Catalog.Cookie) {
+		if err := bindExpr(del.stmt.Where, table); err != nil {
+			del.cache = scanCache{}
+			return err
+		}
+	}
+	var rowids []uint32
+	var rows [][]parser.ColumnValue
+	p := del.cache.planScan(del.stmt.Where, nil)
+	err = scan(del.exec.Shared, table, p, del.stmt.Where, func(rowid uint32, values []parser.ColumnValue) error {
+		rowids = append(rowids, rowid)
+		rows = append(rows, values)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cursor := del.exec.Shared.OpenBtree(table.RootPageNo).Cursor()
+	defer cursor.Close()
+	for i, rowid := range rowids {
+		if err := cursor.Delete(rowid); err != nil {
+			return err
+		}
+		if err := deleteIndexes(del.exec.Shared, table, rowid, rows[i]); err != nil {
+			return err
+		}
+	}
+	del.result.RowsAffected = len(rowids)
+	return nil
+}
+
+// bind bind the statement to the table, and expand the SELECT list and the ORDER BY
+// terms. They are kept for the next runs of a prepared statement.
+func (sel *selectExec) bind(table *catalog.Table) error {
+	if err := bindExpr(sel.stmt.Where, table); err != nil {
+		return err
+	}
+	if err := sel.project(table); err != nil {
+		return err
+	}
+	terms, err := sel.orderBy(table, sel.exprs)
+	if err != nil {
+		return err
+	}
+	sel.terms = terms
+	return nil
+}
+
+// project expand the SELECT list into one expression per result column, and name
+// the result columns.
+func (sel *selectExec) project(table *catalog.Table) error {
+	sel.exprs, sel.columns = nil, nil
+	for _, column := range sel.stmt.Columns {
+		if column.Star {
+			if column.StarTable != "" && !strings.EqualFold(column.StarTable, table.Name) {
+				return catalog.ErrorNoSuchTable
+			}
+			for _, name := range table.Columns {
+				sel.exprs = append(sel.exprs, parser.ColumnRef{Column: name})
+				sel.columns = append(sel.columns, name)
+			}
+			continue
+		}
+		if err := bindExpr(column.Expr, table); err != nil {
+			return err
+		}
+		name := column.Alias
+		if name == "" {
+			name = exprName(column.Expr)
+		}
+		sel.exprs = append(sel.exprs, column.Expr)
+		sel.columns = append(sel.columns, name)
+	}
+	return nil
+}
@@ -0,0 +1,676 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"godb/internal/btree"
+	"godb/internal/catalog"
+	"godb/internal/parser"
+	"godb/internal/record"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func mustExecute(t *testing.T, e *Executor, sql string) *Result {
+	result, err := e.Execute(sql)
+	assert.Nil(t, err, sql)
+	return result
+}
+
+func TestCreateInsertSelect(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	e, err := Open(fileName)
+	assert.Nil(t, err)
+	mustExecute(t, e, "create table users (id integer, name varchar(16))")
+	for i := 0; i < 500; i++ {
+		result := mustExecute(t, e, fmt.Sprintf("insert into users values (%d, 'user%d')", i, i))
+		assert.Equal(t, 1, result.RowsAffected)
+	}
+	assert.Nil(t, e.Close())
+
+	e, err = Open(fileName)
+	assert.Nil(t, err)
+	defer e.Close()
+	result := mustExecute(t, e, "select * from users")
+	assert.Equal(t, []string{"id", "name"}, result.Columns)
+	assert.Equal(t, 500, len(result.Rows))
+	for i, row := range result.Rows {
+		assert.Equal(t, int32(i), row[0].Int())
+		assert.Equal(t, fmt.Sprintf("user%d", i), row[1].String())
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	e, err := Open("")
+	assert.Nil(t, err)
+	defer e.Close()
+	_, err = e.Execute("select * from users")
+	assert.Equal(t, catalog.ErrorNoSuchTable, err)
+	mustExecute(t, e, "create table users (id integer, name varchar(4))")
+	_, err = e.Execute("create table users (id integer)")
+	assert.Equal(t, catalog.ErrorTableExists, err)
+	_, err = e.Execute("insert into users values (1)")
+	assert.Equal(t, record.ErrorColumnCount, err)
+	_, err = e.Execute("insert into users values ('1', 'a')")
+	assert.Equal(t, record.ErrorTypeMismatch, err)
+	_, err = e.Execute("insert into users values (1, 'abcde')")
+	assert.Equal(t, record.ErrorValueTooLong, err)
+	mustExecute(t, e, "insert into users values (1, 'abcd')")
+	result := mustExecute(t, e, "select * from users")
+	assert.Equal(t, 1, len(result.Rows))
+}
+
+func TestSelectWhere(t *testing.T) {
+	e, err := Open("")
+	assert.Nil(t, err)
+	defer e.Close()
+	mustExecute(t, e, "create table users (id integer, name varchar(16))")
+	for i := 1; i <= 20; i++ {
+		mustExecute(t, e, fmt.Sprintf("insert into users values (%d, 'user%d')", i, i))
+	}
+	mustExecute(t, e, "insert into users values (21, null)")
+	ids := func(sql string) []int32 {
+		var ids []int32
+		for _, row := range mustExecute(t, e, sql).Rows {
+			ids = append(ids, row[0].Int())
+		}
+		return ids
+	}
+	assert.Equal(t, []int32{3, 4, 5}, ids("select * from users where id >= 3 and id < 6"))
+	assert.Equal(t, []int32{1, 20}, ids("select * from users where id = 1 or id * 2 = 40"))
+	assert.Equal(t, []int32{2, 4}, ids("select * from users where id in (2, 4, 99)"))
+	assert.Equal(t, []int32{19, 20, 21}, ids("select * from users where not id between 1 and 18"))
+	assert.Equal(t, []int32{1, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19}, ids("select * from users where name like 'USER1%'"))
+	assert.Equal(t, []int32{21}, ids("select * from users where name is null"))
+	assert.Equal(t, 20, len(ids("select * from users where name is not null")))
+	// a comparison with NULL is never true
+	assert.Equal(t, []int32{1}, ids("select * from users where name = 'user1' or name = null"))
+	assert.Empty(t, ids("select * from users where id % 0 = 0"))
+	assert.Equal(t, []int32{7}, ids("select * from users where (id - 1) / 2 = 3 and id % 2 = 1"))
+	_, err = e.Execute("select * from users where age = 1")
+	assert.Equal(t, ErrorNoSuchColumn, err)
+}
+
+func TestLike(t *testing.T) {
+	assert.True(t, like("a%c", "abbbc"))
+	assert.True(t, like("%", ""))
+	assert.True(t, like("_b%", "ABC"))
+	assert.False(t, like("a_c", "ac"))
+	assert.False(t, like("%a", "ab"))
+	assert.True(t, like("%a%b", "xaxxab"))
+}
+
+func TestSelectProjection(t *testing.T) {
+	e, err := Open("")
+	assert.Nil(t, err)
+	defer e.Close()
+	mustExecute(t, e, "create table users (id integer, name varchar(16))")
+	mustExecute(t, e, "insert into users values (1, 'alice')")
+	mustExecute(t, e, "insert into users values (2, 'bob')")
+
+	result := mustExecute(t, e, "select name, users.id * 10 as score, id + 1, users.* from users where id = 2")
+	assert.Equal(t, []string{"name", "score", "id + 1", "id", "name"}, result.Columns)
+	assert.Equal(t, []parser.ColumnType{
+		parser.NewColumnType(parser.VarTypeVarchar, 16),
+		parser.NewColumnType(parser.VarTypeInteger, 0),
+		parser.NewColumnType(parser.VarTypeInteger, 0),
+		parser.NewColumnType(parser.VarTypeInteger, 0),
+		parser.NewColumnType(parser.VarTypeVarchar, 16),
+	}, result.Types)
+	assert.Equal(t, 1, len(result.Rows))
+	values := make([]string, len(result.Rows[0]))
+	for i, value := range result.Rows[0] {
+		values[i] = value.String()
+	}
+	assert.Equal(t, []string{"bob", "20", "3", "2", "bob"}, values)
+
+	_, err = e.Execute("select age from users")
+	assert.Equal(t, ErrorNoSuchColumn, err)
+	_, err = e.Execute("select other.id from users")
+	assert.Equal(t, ErrorNoSuchColumn, err)
+	_, err = e.Execute("select other.* from users")
+	assert.Equal(t, catalog.ErrorNoSuchTable, err)
+}
+
+func TestUpdateDelete(t *testing.T) {
+	e, err := Open("")
+	assert.Nil(t, err)
+	defer e.Close()
+	mustExecute(t, e, "create table users (id integer, name varchar(300), score integer)")
+	for i := 0; i < 300; i++ {
+		mustExecute(t, e, fmt.Sprintf("insert into users values (%d, 'user%d', 0)", i, i))
+	}
+	result := mustExecute(t, e, "update users set score = id * 2, name = 'x' where id % 3 = 0")
+	assert.Equal(t, 100, result.RowsAffected)
+	result = mustExecute(t, e, "select id, name, score from users where id < 4")
+	assert.Equal(t, 4, len(result.Rows))
+	assert.Equal(t, "x", result.Rows[3][1].String())
+	assert.Equal(t, int32(6), result.Rows[3][2].Int())
+	assert.Equal(t, "user2", result.Rows[2][1].String())
+
+	// growing every row split the pages while the rows are rewritten
+	result = mustExecute(t, e, "update users set name = 'abcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyz'")
+	assert.Equal(t, 300, result.RowsAffected)
+	result = mustExecute(t, e, "select id from users where name like 'abc%'")
+	assert.Equal(t, 300, len(result.Rows))
+
+	result = mustExecute(t, e, "delete from users where id >= 100")
+	assert.Equal(t, 200, result.RowsAffected)
+	result = mustExecute(t, e, "select * from users")
+	assert.Equal(t, 100, len(result.Rows))
+	assert.Equal(t, int32(99), result.Rows[99][0].Int())
+	// the rowids after the largest one are used again
+	mustExecute(t, e, "insert into users values (100, 'new', 0)")
+	result = mustExecute(t, e, "delete from users")
+	assert.Equal(t, 101, result.RowsAffected)
+	assert.Empty(t, mustExecute(t, e, "select * from users").Rows)
+
+	_, err = e.Execute("update users set age = 1")
+	assert.Equal(t, ErrorNoSuchColumn, err)
+	_, err = e.Execute("delete from users where age = 1")
+	assert.Equal(t, ErrorNoSuchColumn, err)
+}
+
+func TestUpdateRollback(t *testing.T) {
+	e, err := Open("")
+	assert.Nil(t, err)
+	defer e.Close()
+	mustExecute(t, e, "create table users (id integer, name varchar(4))")
+	mustExecute(t, e, "insert into users values (1, 'a')")
+	mustExecute(t, e, "insert into users values (2, 'b')")
+	// a value of a wrong type fails the statement, no row is changed
+	_, err = e.Execute("update users set name = id + 1")
+	assert.Equal(t, record.ErrorTypeMismatch, err)
+	_, err = e.Execute("update users set id = id + 10, name = 'abcde' where id = 2")
+	assert.Equal(t, record.ErrorValueTooLong, err)
+	result := mustExecute(t, e, "select * from users")
+	assert.Equal(t, 2, len(result.Rows))
+	assert.Equal(t, "1 a 2 b", fmt.Sprint(result.Rows[0][0], " ", result.Rows[0][1], " ", result.Rows[1][0], " ", result.Rows[1][1]))
+}
+
+func TestArithmeticOverflow(t *testing.T) {
+	e, err := Open("")
+	assert.Nil(t, err)
+	defer e.Close()
+	mustExecute(t, e, "create table t (a integer)")
+	mustExecute(t, e, "insert into t values (1)")
+	mustExecute(t, e, "insert into t values (2147483647)")
+	mustExecute(t, e, "insert into t values (-2147483648)")
+	mustExecute(t, e, "insert into t values (5)")
+	// the results that fit in an integer are kept
+	result := mustExecute(t, e, "select a - 1, a + 0, a * 1 from t where a = 2147483647")
+	assert.Equal(t, "2147483646 2147483647 2147483647", fmt.Sprint(result.Rows[0][0], " ", result.Rows[0][1], " ", result.Rows[0][2]))
+	for _, sql := range []string{
+		"select a + 1 from t where a = 2147483647",
+		"select a - 1 from t where a = -2147483648",
+		"select a * 2 from t where a = -2147483648",
+		"select -a from t where a = -2147483648",
+		"select a / -1 from t where a = -2147483648",
+	} {
+		_, err = e.Execute(sql)
+		assert.Equal(t, ErrorValueOutOfRange, err, sql)
+	}
+	// the overflow fails the statement, no row is changed
+	_, err = e.Execute("update t set a = a * 1000000000")
+	assert.Equal(t, ErrorValueOutOfRange, err)
+	result = mustExecute(t, e, "select * from t")
+	assert.Equal(t, 4, len(result.Rows))
+	assert.Equal(t, "1 2147483647 -2147483648 5", fmt.Sprint(result.Rows[0][0], " ", result.Rows[1][0], " ", result.Rows[2][0], " ", result.Rows[3][0]))
+}
+
+func TestDropTable(t *testing.T) {
+	e, err := Open("")
+	assert.Nil(t, err)
+	defer e.Close()
+	mustExecute(t, e, "create table users (id integer, name varchar(16))")
+	for i := 0; i < 500; i++ {
+		mustExecute(t, e, fmt.Sprintf("insert into users values (%d, 'user%d')", i, i))
+	}
+	numPage := e.Shared.NumPage
+	mustExecute(t, e, "drop table users")
+	_, err = e.Execute("select * from users")
+	assert.Equal(t, catalog.ErrorNoSuchTable, err)
+	_, err = e.Execute("drop table users")
+	assert.Equal(t, catalog.ErrorNoSuchTable, err)
+	mustExecute(t, e, "drop table if exists users")
+
+	// the pages of the dropped table are reused
+	mustExecute(t, e, "create table users (id integer, name varchar(16))")
+	for i := 0; i < 500; i++ {
+		mustExecute(t, e, fmt.Sprintf("insert into users values (%d, 'user%d')", i, i))
+	}
+	assert.Equal(t, numPage, e.Shared.NumPage)
+}
+
+// indexRowids walk the index and return the rowids in key order.
+func indexRowids(t *testing.T, e *Executor, name string) []uint32 {
+	index := e.Catalog.Indexes[name]
+	assert.NotNil(t, index)
+	cursor := e.Shared.OpenBtree(index.RootPageNo).Cursor()
+	defer cursor.Close()
+	assert.Nil(t, cursor.MoveToRoot())
+	assert.Nil(t, cursor.MoveToLeftMost())
+	var rowids []uint32
+	for !cursor.Eof() {
+		rowids = append(rowids, cursor.Key())
+		assert.Nil(t, cursor.MoveNext())
+	}
+	return rowids
+}
+
+func TestIndex(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	e, err := Open(fileName)
+	assert.Nil(t, err)
+	mustExecute(t, e, "create table users (id integer, name varchar(16))")
+	for i := 0; i < 300; i++ {
+		mustExecute(t, e, fmt.Sprintf("insert into users values (%d, 'user%03d')", 300-i, i))
+	}
+	// the rows already in the table are added to a new index
+	mustExecute(t, e, "create index idx_id on users (id)")
+	mustExecute(t, e, "create unique index idx_name on users (name)")
+	_, err = e.Execute("create index idx_id on users (name)")
+	assert.Equal(t, catalog.ErrorIndexExists, err)
+	// a unique index can not be built on duplicated values, and leave nothing behind
+	mustExecute(t, e, "create table t (a integer)")
+	mustExecute(t, e, "insert into t values (1)")
+	mustExecute(t, e, "insert into t values (1)")
+	_, err = e.Execute("create unique index idx_a on t (a)")
+	assert.Equal(t, ErrorUniqueConstraint, err)
+	mustExecute(t, e, "create index idx_a on t (a)")
+	mustExecute(t, e, "drop table t")
+	mustExecute(t, e, "insert into users values (1000, null)")
+	mustExecute(t, e, "insert into users values (1001, null)")
+	assert.Nil(t, e.Close())
+
+	e, err = Open(fileName)
+	assert.Nil(t, err)
+	defer e.Close()
+	rowids := indexRowids(t, e, "idx_id")
+	assert.Equal(t, 302, len(rowids))
+	assert.Equal(t, uint32(300), rowids[0])
+	assert.Equal(t, uint32(1), rowids[299])
+	// NULL sort first, and are never duplicates of each other
+	rowids = indexRowids(t, e, "idx_name")
+	assert.Equal(t, []uint32{301, 302, 1, 2}, rowids[:4])
+
+	_, err = e.Execute("insert into users values (5, 'user007')")
+	assert.Equal(t, ErrorUniqueConstraint, err)
+	_, err = e.Execute("update users set name = 'user007' where id = 1")
+	assert.Equal(t, ErrorUniqueConstraint, err)
+	// the failed statements leave the table and the indexes unchanged
+	assert.Equal(t, 302, len(mustExecute(t, e, "select * from users").Rows))
+	assert.Equal(t, 302, len(indexRowids(t, e, "idx_name")))
+
+	// the uniqueness is checked after all the rows are updated
+	mustExecute(t, e, "update users set id = id + 1 where id < 1000")
+	mustExecute(t, e, "update users set name = 'zzz' where id = 2")
+	mustExecute(t, e, "delete from users where id > 151")
+	assert.Equal(t, 150, len(mustExecute(t, e, "select * from users").Rows))
+	rowids = indexRowids(t, e, "idx_id")
+	assert.Equal(t, 150, len(rowids))
+	assert.Equal(t, uint32(300), rowids[0])
+	assert.Equal(t, 150, len(indexRowids(t, e, "idx_name")))
+
+	mustExecute(t, e, "drop table users")
+	assert.Equal(t, 0, len(e.Catalog.Indexes))
+}
+
+func TestRangeScan(t *testing.T) {
+	e, err := Open("")
+	assert.Nil(t, err)
+	defer e.Close()
+	// the same rows in a table with an index and a table without any
+	for _, name := range []string{"events", "plain"} {
+		mustExecute(t, e, fmt.Sprintf("create table %s (id integer, ts integer, name varchar(16))", name))
+		for i := 0; i < 400; i++ {
+			ts := fmt.Sprint((i * 37) % 200)
+			if i%50 == 0 {
+				ts = "null"
+			}
+			mustExecute(t, e, fmt.Sprintf("insert into %s values (%d, %s, 'event%d')", name, i, ts, i%7))
+		}
+	}
+	mustExecute(t, e, "create index idx_ts on events (ts)")
+	mustExecute(t, e, "create index idx_name_id on events (name, id)")
+
+	queries := []string{
+		"select * from %s where ts between 10 and 20",
+		"select * from %s where ts >= 190",
+		"select * from %s where ts > 190 and ts < 195 and id > 100",
+		"select * from %s where 5 > ts",
+		"select * from %s where ts <= 3 or ts = 100",
+		"select * from %s where ts = 42",
+		"select * from %s where ts between 30 and 20",
+		"select * from %s where name = 'event3' and id < 50",
+		"select * from %s where name > 'event5' order by name, id desc",
+		"select id, ts from %s order by ts desc, id",
+		"select * from %s where ts between 100 and 120 order by ts desc, id",
+		"select id, ts t from %s where ts < 5 order by t, 1 desc",
+		"select * from %s where ts is null order by id desc",
+	}
+	for _, query := range queries {
+		expected := mustExecute(t, e, fmt.Sprintf(query, "plain"))
+		result := mustExecute(t, e, fmt.Sprintf(query, "events"))
+		if strings.Contains(query, "order by") {
+			assert.Equal(t, expected.Rows, result.Rows, query)
+		} else {
+			assert.ElementsMatch(t, expected.Rows, result.Rows, query)
+		}
+	}
+
+	// the index is walked instead of the whole table
+	events, err := e.Catalog.GetTable("events")
+	assert.Nil(t, err)
+	sel, err := parser.Parse("select * from events where ts between 10 and 20 order by ts desc")
+	assert.Nil(t, err)
+	p := planScan(events, sel.(parser.SelectStatement).Where, sel.(parser.SelectStatement).OrderBy)
+	assert.Equal(t, "idx_ts", p.index.Name)
+	assert.True(t, p.ordered)
+	result := mustExecute(t, e, "select ts from events where ts between 10 and 20 order by ts desc")
+	assert.Equal(t, int32(20), result.Rows[0][0].Int())
+	assert.Equal(t, int32(10), result.Rows[len(result.Rows)-1][0].Int())
+	sel, err = parser.Parse("select * from events order by id")
+	assert.Nil(t, err)
+	p = planScan(events, nil, sel.(parser.SelectStatement).OrderBy)
+	assert.Nil(t, p.index)
+
+	_, err = e.Execute("select id from events order by 2")
+	assert.Equal(t, ErrorOrderByPosition, err)
+	_, err = e.Execute("select id from events order by missing")
+	assert.Equal(t, ErrorNoSuchColumn, err)
+}
+
+func countRows(t *testing.T, e *Executor, table string) int {
+	return len(mustExecute(t, e, "select * from "+table).Rows)
+}
+
+func TestTransaction(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.db")
+	e, err := Open(fileName)
+	assert.Nil(t, err)
+	mustExecute(t, e, "create table users (id integer, name varchar(16))")
+	mustExecute(t, e, "create unique index idx_id on users (id)")
+
+	// the inserts of a transaction are discarded as a unit
+	mustExecute(t, e, "begin")
+	for i := 0; i < 1000; i++ {
+		mustExecute(t, e, fmt.Sprintf("insert into users values (%d, 'user%d')", i, i))
+	}
+	assert.Equal(t, 1000, countRows(t, e, "users"))
+	_, err = e.Execute("begin")
+	assert.Equal(t, ErrorInTransaction, err)
+	mustExecute(t, e, "rollback")
+	assert.Equal(t, 0, countRows(t, e, "users"))
+	_, err = e.Execute("commit")
+	assert.Equal(t, ErrorNoTransaction, err)
+
+	// a failed statement is undone alone, the transaction go on
+	mustExecute(t, e, "begin immediate transaction")
+	for i := 0; i < 1000; i++ {
+		mustExecute(t, e, fmt.Sprintf("insert into users values (%d, 'user%d')", i, i))
+	}
+	_, err = e.Execute("update users set id = 5 where id > 990")
+	assert.Equal(t, ErrorUniqueConstraint, err)
+	assert.True(t, e.InTxn)
+	mustExecute(t, e, "create table logs (msg varchar(16))")
+	mustExecute(t, e, "commit")
+	assert.Equal(t, 1000, countRows(t, e, "users"))
+
+	// savepoints roll back part of the transaction
+	mustExecute(t, e, "begin")
+	mustExecute(t, e, "delete from users where id < 100")
+	mustExecute(t, e, "savepoint a")
+	mustExecute(t, e, "delete from users where id < 500")
+	mustExecute(t, e, "drop table logs")
+	mustExecute(t, e, "savepoint b")
+	mustExecute(t, e, "delete from users")
+	assert.Equal(t, 0, countRows(t, e, "users"))
+	mustExecute(t, e, "rollback to b")
+	assert.Equal(t, 500, countRows(t, e, "users"))
+	mustExecute(t, e, "rollback transaction to savepoint a")
+	assert.Equal(t, 900, countRows(t, e, "users"))
+	assert.Equal(t, 0, countRows(t, e, "logs"))
+	_, err = e.Execute("release b")
+	assert.Equal(t, ErrorNoSuchSavepoint, err)
+	mustExecute(t, e, "insert into users values (5000, 'late')")
+	mustExecute(t, e, "release a")
+	mustExecute(t, e, "end")
+	assert.Nil(t, e.Close())
+
+	e, err = Open(fileName)
+	assert.Nil(t, err)
+	defer e.Close()
+	assert.Equal(t, 901, countRows(t, e, "users"))
+	result := mustExecute(t, e, "select name from users where id = 5000")
+	assert.Equal(t, "late", result.Rows[0][0].String())
+
+	// a savepoint outside a transaction start one, releasing it commit
+	mustExecute(t, e, "savepoint outer")
+	assert.True(t, e.InTxn)
+	mustExecute(t, e, "delete from users where id >= 500")
+	mustExecute(t, e, "release outer")
+	assert.False(t, e.InTxn)
+	assert.Equal(t, 400, countRows(t, e, "users"))
+
+	// an open transaction is rolled back on close
+	mustExecute(t, e, "begin")
+	mustExecute(t, e, "delete from users")
+	assert.Nil(t, e.Close())
+	e, err = Open(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, 400, countRows(t, e, "users"))
+}
+
+func TestConcurrentExecutors(t *testing.T) {
+	bs, err := btree.Open(filepath.Join(t.TempDir(), "test.db"))
+	assert.Nil(t, err)
+	defer bs.Close()
+	bs.SetBusyTimeout(10 * time.Second)
+	e, err := New(bs)
+	assert.Nil(t, err)
+	mustExecute(t, e, "create table users (id integer, name varchar(16))")
+	mustExecute(t, e, "create index idx_id on users (id)")
+
+	// the writers insert 10 rows per transaction, the readers see whole transactions
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			e, err := New(bs)
+			assert.Nil(t, err)
+			defer e.Close()
+			for b := 0; b < 10; b++ {
+				mustExecute(t, e, "begin immediate")
+				for i := 0; i < 10; i++ {
+					id := (w*10+b)*10 + i
+					mustExecute(t, e, fmt.Sprintf("insert into users values (%d, 'user%d')", id, id))
+				}
+				mustExecute(t, e, "commit")
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e, err := New(bs)
+			assert.Nil(t, err)
+			defer e.Close()
+			for i := 0; i < 20; i++ {
+				assert.Equal(t, 0, countRows(t, e, "users")%10)
+				result := mustExecute(t, e, "select id from users where id >= 0 order by id")
+				assert.Equal(t, 0, len(result.Rows)%10)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 400, countRows(t, e, "users"))
+
+	// a table created by one executor is seen by the others
+	other, err := New(bs)
+	assert.Nil(t, err)
+	mustExecute(t, other, "create table logs (msg varchar(16))")
+	assert.Equal(t, 0, countRows(t, e, "logs"))
+	assert.Nil(t, other.Close())
+	assert.Nil(t, e.Close())
+}
+
+func TestDeferredTransaction(t *testing.T) {
+	bs, err := btree.Open("")
+	assert.Nil(t, err)
+	defer bs.Close()
+	e, err := New(bs)
+	assert.Nil(t, err)
+	other, err := New(bs)
+	assert.Nil(t, err)
+	mustExecute(t, e, "create table users (id integer)")
+	mustExecute(t, e, "insert into users values (1)")
+
+	// a deferred transaction let the others read until its first write
+	mustExecute(t, e, "begin")
+	assert.Equal(t, 1, countRows(t, e, "users"))
+	assert.Equal(t, 1, countRows(t, other, "users"))
+	mustExecute(t, e, "insert into users values (2)")
+	_, err = other.Execute("select * from users")
+	assert.Equal(t, btree.ErrorBusy, err)
+	mustExecute(t, e, "commit")
+	assert.Equal(t, 2, countRows(t, other, "users"))
+
+	// the write of a deferred transaction wait for the other readers
+	mustExecute(t, e, "begin deferred")
+	mustExecute(t, other, "begin")
+	assert.Equal(t, 2, countRows(t, other, "users"))
+	_, err = e.Execute("insert into users values (3)")
+	assert.Equal(t, btree.ErrorBusy, err)
+	assert.True(t, e.InTxn)
+	mustExecute(t, other, "commit")
+	mustExecute(t, e, "insert into users values (3)")
+	mustExecute(t, e, "commit")
+
+	// a read-only transaction can not write
+	assert.Nil(t, e.BeginReadOnly(context.Background()))
+	assert.Equal(t, ErrorInTransaction, e.BeginReadOnly(context.Background()))
+	assert.Equal(t, 3, countRows(t, other, "users"))
+	_, err = e.Execute("insert into users values (4)")
+	assert.Equal(t, btree.ErrorReadOnly, err)
+	mustExecute(t, e, "commit")
+	assert.Equal(t, 3, countRows(t, e, "users"))
+}
+
+func TestPreparedStatement(t *testing.T) {
+	e, err := Open("")
+	assert.Nil(t, err)
+	defer e.Close()
+	mustExecute(t, e, "create table users (id integer, name varchar(32))")
+	mustExecute(t, e, "create index idx_id on users (id)")
+	insert, err := e.Prepare("insert into users values (?, ?)")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, insert.NumParams())
+	for i := 0; i < 100; i++ {
+		_, err := insert.Exec(i, fmt.Sprintf("user%d", i))
+		assert.Nil(t, err)
+	}
+	// a value is never taken as SQL
+	_, err = insert.Exec(-1, "x'); drop table users; --")
+	assert.Nil(t, err)
+	assert.Equal(t, 101, countRows(t, e, "users"))
+
+	query, err := e.Prepare("select name from users where id >= :low and id < :low + ?2 order by id")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, query.NumParams())
+	assert.Nil(t, query.BindName(":low", 10))
+	assert.Nil(t, query.Bind(2, int64(3)))
+	result, err := query.Query()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(result.Rows))
+	assert.Equal(t, "user10", result.Rows[0][0].String())
+	result, err = query.Query(-1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "x'); drop table users; --", result.Rows[0][0].String())
+	// a parameter not bound is NULL
+	query.ClearBindings()
+	result, err = query.Query()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(result.Rows))
+
+	// a parameter in ORDER BY is a constant, not the position of a result column
+	ordered, err := e.Prepare("select id from users where id < 3 order by ?, id desc")
+	assert.Nil(t, err)
+	for _, position := range []int{1, 5} {
+		result, err = ordered.Query(position)
+		assert.Nil(t, err)
+		var ids []int32
+		for _, r := range result.Rows {
+			ids = append(ids, r[0].Int())
+		}
+		assert.Equal(t, []int32{2, 1, 0, -1}, ids)
+	}
+
+	assert.Equal(t, ErrorNoSuchParam, query.BindName(":high", 1))
+	assert.Equal(t, parser.ErrorParamIndex, query.Bind(3, 1))
+	assert.Equal(t, ErrorValueOutOfRange, query.Bind(1, int64(1)<<40))
+	assert.Equal(t, ErrorUnsupportedValue, query.Bind(1, 1.5))
+	_, err = insert.Query(1, "x")
+	assert.Equal(t, ErrorNotQuery, err)
+	// Execute can not bind the parameters
+	_, err = e.Execute("delete from users where id = ?")
+	assert.Equal(t, ErrorUnboundParams, err)
+	assert.Equal(t, 101, countRows(t, e, "users"))
+}
+
+func TestPreparedStatementPlan(t *testing.T) {
+	e, err := Open("")
+	assert.Nil(t, err)
+	defer e.Close()
+	mustExecute(t, e, "create table users (id integer, name varchar(32))")
+	for i := 0; i < 20; i++ {
+		mustExecute(t, e, fmt.Sprintf("insert into users values (%d, 'user%d')", i, i))
+	}
+	query, err := e.Prepare("select * from users where name = ?")
+	assert.Nil(t, err)
+	sel := query.bound.(*selectExec)
+	result, err := query.Query("user3")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(result.Rows))
+	assert.Nil(t, sel.cache.plan.index)
+
+	// the plan is kept while the values are the same
+	p := sel.cache.plan
+	_, err = query.Query("user3")
+	assert.Nil(t, err)
+	assert.True(t, p == sel.cache.plan)
+
+	// a new index is used once the schema change
+	mustExecute(t, e, "create index idx_name on users (name)")
+	result, err = query.Query("user3")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(result.Rows))
+	assert.Equal(t, "idx_name", sel.cache.plan.index.Name)
+	assert.Equal(t, record.EncodeKey([]parser.ColumnValue{parser.VarcharValue("user3")}), sel.cache.plan.start.Key)
+
+	// the plan is chosen again for new values, NULL does not limit the index
+	_, err = query.Query("user4")
+	assert.Nil(t, err)
+	assert.Equal(t, record.EncodeKey([]parser.ColumnValue{parser.VarcharValue("user4")}), sel.cache.plan.start.Key)
+	result, err = query.Query(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(result.Rows))
+	assert.Nil(t, sel.cache.plan.index)
+
+	// the statement is bound again to a new table with the same name
+	mustExecute(t, e, "drop table users")
+	_, err = query.Query("user3")
+	assert.Equal(t, catalog.ErrorNoSuchTable, err)
+	mustExecute(t, e, "create table users (name varchar(8), score integer)")
+	mustExecute(t, e, "insert into users values ('user3', 7)")
+	result, err = query.Query("user3")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"name", "score"}, result.Columns)
+	assert.Equal(t, "7", result.Rows[0][1].String())
+}
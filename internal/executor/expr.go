@@ -0,0 +1,341 @@
+package executor
+
+import (
+	"bytes"
+	"errors"
+	"godb/internal/catalog"
+	"godb/internal/parser"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrorNoSuchColumn    = catalog.ErrorNoSuchColumn
+	ErrorUnsupportedExpr = errors.New("unsupported expression")
+)
+
+// row is the context an expression is evaluated in.
+type row struct {
+	table  *catalog.Table
+	values []parser.ColumnValue
+}
+
+var (
+	valueTrue  = parser.IntegerValue(1)
+	valueFalse = parser.IntegerValue(0)
+)
+
+func boolValue(b bool) parser.ColumnValue {
+	if b {
+		return valueTrue
+	}
+	return valueFalse
+}
+
+// toInt convert a value to integer. A string that is not a number is 0.
+func toInt(value parser.ColumnValue) int32 {
+	if value.Type() == parser.VarTypeInteger {
+		return value.Int()
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(value.String()), 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(n)
+}
+
+// truth return the boolean value of a value, and whether the value is NULL.
+func truth(value parser.ColumnValue) (bool, bool) {
+	if value.IsNull() {
+		return false, true
+	}
+	return toInt(value) != 0, false
+}
+
+// compareValues compare two non-NULL values. An integer is smaller than any string.
+func compareValues(a, b parser.ColumnValue) int {
+	if a.Type() != b.Type() {
+		if a.Type() == parser.VarTypeInteger {
+			return -1
+		}
+		return 1
+	}
+	if a.Type() == parser.VarTypeInteger {
+		if a.Int() < b.Int() {
+			return -1
+		} else if a.Int() > b.Int() {
+			return 1
+		}
+		return 0
+	}
+	return bytes.Compare(a.Value(), b.Value())
+}
+
+// compareSortValues compare two values in the order of ORDER BY and the indexes,
+// NULL sort before any other value.
+func compareSortValues(a, b parser.ColumnValue) int {
+	if a.IsNull() || b.IsNull() {
+		if a.IsNull() && b.IsNull() {
+			return 0
+		} else if a.IsNull() {
+			return -1
+		}
+		return 1
+	}
+	return compareValues(a, b)
+}
+
+// column return the value of the column the reference point to.
+func (r *row) column(ref parser.ColumnRef) (parser.ColumnValue, error) {
+	if ref.Table != "" && !strings.EqualFold(ref.Table, r.table.Name) {
+		return parser.ColumnValue{}, ErrorNoSuchColumn
+	}
+	idx := r.table.ColumnIndex(ref.Column)
+	if idx < 0 {
+		return parser.ColumnValue{}, ErrorNoSuchColumn
+	}
+	return r.values[idx], nil
+}
+
+// eval evaluate the expression against the row. The logical operators follow the
+// three-valued logic of SQL, a comparison with NULL is NULL.
+func eval(expr parser.Expr, r *row) (parser.ColumnValue, error) {
+	switch e := expr.(type) {
+	case parser.Literal:
+		return e.Value, nil
+	case parser.BoundParam:
+		return *e.Value, nil
+	case parser.ColumnRef:
+		return r.column(e)
+	case parser.UnaryExpr:
+		operand, err := eval(e.Operand, r)
+		if err != nil || operand.IsNull() {
+			return operand, err
+		}
+		if e.Op == parser.OpNot {
+			b, _ := truth(operand)
+			return boolValue(!b), nil
+		}
+		return intValue(-int64(toInt(operand)))
+	case parser.BinaryExpr:
+		if e.Op == parser.OpAnd || e.Op == parser.OpOr {
+			return evalLogical(e, r)
+		}
+		left, err := eval(e.Left, r)
+		if err != nil {
+			return left, err
+		}
+		right, err := eval(e.Right, r)
+		if err != nil {
+			return right, err
+		}
+		if left.IsNull() || right.IsNull() {
+			return parser.NullValue(), nil
+		}
+		return evalBinary(e.Op, left, right)
+	case parser.IsNullExpr:
+		operand, err := eval(e.Operand, r)
+		if err != nil {
+			return operand, err
+		}
+		return boolValue(operand.IsNull() != e.Not), nil
+	case parser.InExpr:
+		return evalIn(e, r)
+	case parser.BetweenExpr:
+		// a BETWEEN b AND c is a >= b AND a <= c
+		result, err := evalLogical(parser.BinaryExpr{
+			Op:    parser.OpAnd,
+			Left:  parser.BinaryExpr{Op: parser.OpGe, Left: e.Operand, Right: e.Low},
+			Right: parser.BinaryExpr{Op: parser.OpLe, Left: e.Operand, Right: e.High},
+		}, r)
+		if err != nil || result.IsNull() || !e.Not {
+			return result, err
+		}
+		b, _ := truth(result)
+		return boolValue(!b), nil
+	case parser.LikeExpr:
+		operand, err := eval(e.Operand, r)
+		if err != nil {
+			return operand, err
+		}
+		pattern, err := eval(e.Pattern, r)
+		if err != nil {
+			return pattern, err
+		}
+		if operand.IsNull() || pattern.IsNull() {
+			return parser.NullValue(), nil
+		}
+		return boolValue(like(pattern.String(), operand.String()) != e.Not), nil
+	default:
+		return parser.ColumnValue{}, ErrorUnsupportedExpr
+	}
+}
+
+// evalLogical evaluate AND and OR. The right operand is skipped when the left one
+// decide the result.
+func evalLogical(e parser.BinaryExpr, r *row) (parser.ColumnValue, error) {
+	left, err := eval(e.Left, r)
+	if err != nil {
+		return left, err
+	}
+	l, lNull := truth(left)
+	// FALSE AND x is FALSE, TRUE OR x is TRUE
+	if !lNull && l == (e.Op == parser.OpOr) {
+		return boolValue(l), nil
+	}
+	right, err := eval(e.Right, r)
+	if err != nil {
+		return right, err
+	}
+	rv, rNull := truth(right)
+	if !rNull && rv == (e.Op == parser.OpOr) {
+		return boolValue(rv), nil
+	}
+	if lNull || rNull {
+		return parser.NullValue(), nil
+	}
+	return boolValue(rv), nil
+}
+
+func evalBinary(op parser.Operator, left, right parser.ColumnValue) (parser.ColumnValue, error) {
+	switch op {
+	case parser.OpEq:
+		return boolValue(compareValues(left, right) == 0), nil
+	case parser.OpNe:
+		return boolValue(compareValues(left, right) != 0), nil
+	case parser.OpLt:
+		return boolValue(compareValues(left, right) < 0), nil
+	case parser.OpLe:
+		return boolValue(compareValues(left, right) <= 0), nil
+	case parser.OpGt:
+		return boolValue(compareValues(left, right) > 0), nil
+	case parser.OpGe:
+		return boolValue(compareValues(left, right) >= 0), nil
+	}
+	l, r := int64(toInt(left)), int64(toInt(right))
+	switch op {
+	case parser.OpAdd:
+		return intValue(l + r)
+	case parser.OpSub:
+		return intValue(l - r)
+	case parser.OpMul:
+		return intValue(l * r)
+	case parser.OpDiv, parser.OpMod:
+		// division by zero is NULL
+		if r == 0 {
+			return parser.NullValue(), nil
+		}
+		if op == parser.OpDiv {
+			return intValue(l / r)
+		}
+		return intValue(l % r)
+	default:
+		return parser.ColumnValue{}, ErrorUnsupportedExpr
+	}
+}
+
+// evalIn evaluate IN. The result is NULL if the value is not found and the list
+// has a NULL.
+func evalIn(e parser.InExpr, r *row) (parser.ColumnValue, error) {
+	operand, err := eval(e.Operand, r)
+	if err != nil || operand.IsNull() {
+		return operand, err
+	}
+	hasNull := false
+	for _, item := range e.List {
+		value, err := eval(item, r)
+		if err != nil {
+			return value, err
+		}
+		if value.IsNull() {
+			hasNull = true
+		} else if compareValues(operand, value) == 0 {
+			return boolValue(!e.Not), nil
+		}
+	}
+	if hasNull {
+		return parser.NullValue(), nil
+	}
+	return boolValue(e.Not), nil
+}
+
+// like match the string against the pattern. '%' match any sequence of characters,
+// '_' match any single character. ASCII letters are case insensitive.
+func like(pattern, str string) bool {
+	p, s := []byte(strings.ToLower(pattern)), []byte(strings.ToLower(str))
+	// the position to retry after the last '%'
+	star, match := -1, 0
+	i, j := 0, 0
+	for j < len(s) {
+		if i < len(p) && (p[i] == '_' || p[i] == s[j]) {
+			i++
+			j++
+		} else if i < len(p) && p[i] == '%' {
+			star, match = i, j
+			i++
+		} else if star >= 0 {
+			i = star + 1
+			match++
+			j = match
+		} else {
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '%' {
+		i++
+	}
+	return i == len(p)
+}
+
+// bindExpr check that all the columns in the expression belong to the table.
+func bindExpr(expr parser.Expr, table *catalog.Table) error {
+	r := &row{table: table, values: make([]parser.ColumnValue, len(table.Columns))}
+	return parser.WalkExpr(expr, func(e parser.Expr) error {
+		if ref, ok := e.(parser.ColumnRef); ok {
+			_, err := r.column(ref)
+			return err
+		}
+		return nil
+	})
+}
+
+// matchWhere return true if the row satisfy the WHERE clause. A NULL result does not match.
+func matchWhere(where parser.Expr, r *row) (bool, error) {
+	if where == nil {
+		return true, nil
+	}
+	value, err := eval(where, r)
+	if err != nil {
+		return false, err
+	}
+	b, _ := truth(value)
+	return b, nil
+}
+
+// exprName return the name of a result column without alias.
+func exprName(expr parser.Expr) string {
+	if ref, ok := expr.(parser.ColumnRef); ok {
+		return ref.Column
+	}
+	name := expr.String()
+	if strings.HasPrefix(name, "(") && strings.HasSuffix(name, ")") {
+		name = name[1 : len(name)-1]
+	}
+	return name
+}
+
+// exprType return the type of the values the expression produce. The logical,
+// comparison and arithmetic operators all produce integers.
+func exprType(expr parser.Expr, table *catalog.Table) parser.ColumnType {
+	switch e := expr.(type) {
+	case parser.ColumnRef:
+		if idx := table.ColumnIndex(e.Column); idx >= 0 {
+			return table.Types[idx]
+		}
+	case parser.Literal:
+		return parser.NewColumnType(e.Value.Type(), len(e.Value.Value()))
+	case parser.BoundParam:
+		return parser.NewColumnType(e.Value.Type(), len(e.Value.Value()))
+	}
+	return parser.NewColumnType(parser.VarTypeInteger, 0)
+}
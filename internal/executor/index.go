@@ -0,0 +1,112 @@
+package executor
+
+import (
+	"bytes"
+	"errors"
+	"godb/internal/btree"
+	"godb/internal/catalog"
+	"godb/internal/parser"
+	"godb/internal/record"
+)
+
+var (
+	ErrorUniqueConstraint = errors.New("UNIQUE constraint failed")
+	ErrorCorruptIndex     = errors.New("index does not match the table")
+)
+
+type createIndexExec struct {
+	exec   *Executor
+	stmt   parser.CreateIndexStatement
+	sql    string
+	result *Result
+}
+
+// execute create the index and add every row already in the table into it.
+func (ci *createIndexExec) execute() error {
+	index, err := ci.exec.Catalog.CreateIndex(ci.stmt, ci.sql)
+	if err != nil {
+		return err
+	}
+	table, err := ci.exec.Catalog.GetTable(index.TableName)
+	if err != nil {
+		return err
+	}
+	return scanTable(ci.exec.Shared, table, nil, func(rowid uint32, values []parser.ColumnValue) error {
+		return insertIndexEntry(ci.exec.Shared, index, rowid, values)
+	})
+}
+
+// indexValues return the values of the indexed columns of the row.
+func indexValues(index *catalog.Index, values []parser.ColumnValue) []parser.ColumnValue {
+	key := make([]parser.ColumnValue, len(index.Columns))
+	for i, idx := range index.Columns {
+		key[i] = values[idx]
+	}
+	return key
+}
+
+// insertIndexEntry add the row into the index. A unique index reject the row if
+// another row has the same values in the indexed columns, NULL values are distinct.
+func insertIndexEntry(bs *btree.Shared, index *catalog.Index, rowid uint32, values []parser.ColumnValue) error {
+	cursor := bs.OpenBtree(index.RootPageNo).Cursor()
+	defer cursor.Close()
+	key := indexValues(index, values)
+	if index.Unique && !hasNull(key) {
+		prefix := record.EncodeKey(key)
+		loc, err := cursor.MoveToKey(prefix)
+		if err != nil {
+			return err
+		}
+		// the cursor may stop on the last entry smaller than the prefix, the first
+		// entry not smaller than the prefix is the next one
+		if loc < 0 {
+			if err := cursor.MoveNext(); err != nil {
+				return err
+			}
+		}
+		if !cursor.Eof() {
+			data, err := cursor.Data()
+			if err != nil {
+				return err
+			}
+			if bytes.HasPrefix(data, prefix) {
+				return ErrorUniqueConstraint
+			}
+		}
+	}
+	return cursor.InsertKey(record.IndexKey(key, rowid), rowid)
+}
+
+// deleteIndexEntry remove the row from the index.
+func deleteIndexEntry(bs *btree.Shared, index *catalog.Index, rowid uint32, values []parser.ColumnValue) error {
+	return bs.OpenBtree(index.RootPageNo).DeleteKey(record.IndexKey(indexValues(index, values), rowid))
+}
+
+// insertIndexes add the row into every index of the table.
+func insertIndexes(bs *btree.Shared, table *catalog.Table, rowid uint32, values []parser.ColumnValue) error {
+	for _, index := range table.Indexes {
+		if err := insertIndexEntry(bs, index, rowid, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteIndexes remove the row from every index of the table.
+func deleteIndexes(bs *btree.Shared, table *catalog.Table, rowid uint32, values []parser.ColumnValue) error {
+	for _, index := range table.Indexes {
+		if err := deleteIndexEntry(bs, index, rowid, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasNull(values []parser.ColumnValue) bool {
+	for _, value := range values {
+		if value.IsNull() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,322 @@
+package executor
+
+import (
+	"bytes"
+	"godb/internal/btree"
+	"godb/internal/catalog"
+	"godb/internal/parser"
+	"godb/internal/record"
+	"strings"
+)
+
+// plan is the access path of a scan. A table is scanned in rowid order, unless the
+// WHERE clause limit the first column of an index to a range, or the ORDER BY is the
+// first column of an index. Then only the range of the index is walked, in the
+// direction of the ORDER BY. The WHERE clause is still checked on every row.
+type plan struct {
+	index   *catalog.Index // the index to walk, nil for a full table scan
+	start   *btree.Bound   // the lower end of the range, nil if there is no lower bound
+	end     *btree.Bound   // the upper end of the range, nil if there is no upper bound
+	dir     btree.Direction
+	ordered bool // true if the rows come out in the order of the ORDER BY
+}
+
+// valueRange is the range of a column allowed by the WHERE clause.
+type valueRange struct {
+	lo, hi       *parser.ColumnValue // nil if unbounded
+	loInc, hiInc bool                // true if the bound itself is in the range
+}
+
+// planScan choose the access path of a scan on the table.
+func planScan(table *catalog.Table, where parser.Expr, orderBy []parser.OrderingTerm) plan {
+	p := plan{dir: btree.Forward}
+	ranges := make(map[int]*valueRange)
+	for _, expr := range conjuncts(where) {
+		collectRange(table, expr, ranges)
+	}
+	orderColumn := -1
+	if len(orderBy) == 1 {
+		if ref, ok := orderBy[0].Expr.(parser.ColumnRef); ok && ownColumn(table, ref) {
+			orderColumn = table.ColumnIndex(ref.Column)
+		}
+	}
+	for _, index := range table.Indexes {
+		if vr, ok := ranges[index.Columns[0]]; ok {
+			p.index = index
+			p.start, p.end = vr.bounds()
+			break
+		}
+	}
+	if p.index == nil && orderColumn >= 0 {
+		for _, index := range table.Indexes {
+			if index.Columns[0] == orderColumn {
+				p.index = index
+				break
+			}
+		}
+	}
+	if p.index != nil && p.index.Columns[0] == orderColumn {
+		p.ordered = true
+		if orderBy[0].Desc {
+			p.dir = btree.Backward
+		}
+	}
+	return p
+}
+
+// scanCache keep the table and the plan of a statement between the runs of a prepared
+// statement. The statement is bound to the table again when the schema change, and
+// the plan is chosen again when the values of the parameters in the WHERE clause
+// change, because they may change the range of the index.
+type scanCache struct {
+	table  *catalog.Table // the table the statement is bound to, nil before the first run
+	cookie uint32         // the schema cookie of the catalog when the table is resolved
+	plan   *plan          // the plan of the last run, nil if it is not chosen yet
+	params []parser.BoundParam
+	values []parser.ColumnValue // the values of the parameters when the plan is chosen
+}
+
+// resolve return true if the statement is still bound to the table. Otherwise the
+// cache is reset, and the statement must be bound to the table again. The table of
+// a reloaded catalog is a new object, so a catalog reloaded with the same cookie
+// does not reuse the old table.
+func (sc *scanCache) resolve(table *catalog.Table, cookie uint32) bool {
+	if sc.table == table && sc.cookie == cookie {
+		return true
+	}
+	*sc = scanCache{table: table, cookie: cookie}
+	return false
+}
+
+// planScan return the plan of the last run if the parameters of the WHERE clause
+// still have the same values, or choose the plan again.
+func (sc *scanCache) planScan(where parser.Expr, orderBy []parser.OrderingTerm) plan {
+	if sc.plan != nil && sc.sameValues() {
+		return *sc.plan
+	}
+	if sc.plan == nil {
+		parser.WalkExpr(where, func(e parser.Expr) error {
+			if param, ok := e.(parser.BoundParam); ok {
+				sc.params = append(sc.params, param)
+			}
+			return nil
+		})
+	}
+	p := planScan(sc.table, where, orderBy)
+	sc.plan = &p
+	sc.values = sc.values[:0]
+	for _, param := range sc.params {
+		sc.values = append(sc.values, *param.Value)
+	}
+	return p
+}
+
+// sameValues return true if the parameters have the values the plan is chosen for.
+func (sc *scanCache) sameValues() bool {
+	for i, param := range sc.params {
+		if param.Value.Type() != sc.values[i].Type() || !bytes.Equal(param.Value.Value(), sc.values[i].Value()) {
+			return false
+		}
+	}
+	return true
+}
+
+// conjuncts split the expression into the operands of the top level AND.
+func conjuncts(expr parser.Expr) []parser.Expr {
+	if e, ok := expr.(parser.BinaryExpr); ok && e.Op == parser.OpAnd {
+		return append(conjuncts(e.Left), conjuncts(e.Right)...)
+	}
+	if expr == nil {
+		return nil
+	}
+	return []parser.Expr{expr}
+}
+
+// ownColumn return true if the reference is a column of the table.
+func ownColumn(table *catalog.Table, ref parser.ColumnRef) bool {
+	return (ref.Table == "" || strings.EqualFold(ref.Table, table.Name)) && table.ColumnIndex(ref.Column) >= 0
+}
+
+// constant evaluate an expression without any column. ok is false if the expression
+// refer to a column or the value is NULL.
+func constant(table *catalog.Table, expr parser.Expr) (value parser.ColumnValue, ok bool) {
+	hasColumn := false
+	parser.WalkExpr(expr, func(e parser.Expr) error {
+		if _, isRef := e.(parser.ColumnRef); isRef {
+			hasColumn = true
+		}
+		return nil
+	})
+	if hasColumn {
+		return value, false
+	}
+	value, err := eval(expr, &row{table, nil})
+	return value, err == nil && !value.IsNull()
+}
+
+// collectRange narrow the range of a column if the expression is a comparison between
+// the column and a constant, or a BETWEEN on the column.
+func collectRange(table *catalog.Table, expr parser.Expr, ranges map[int]*valueRange) {
+	var ref parser.ColumnRef
+	var vr valueRange
+	switch e := expr.(type) {
+	case parser.BinaryExpr:
+		op, left, right := e.Op, e.Left, e.Right
+		if _, ok := right.(parser.ColumnRef); ok {
+			// the column is always on the left side
+			left, right = right, left
+			switch op {
+			case parser.OpLt:
+				op = parser.OpGt
+			case parser.OpLe:
+				op = parser.OpGe
+			case parser.OpGt:
+				op = parser.OpLt
+			case parser.OpGe:
+				op = parser.OpLe
+			}
+		}
+		var ok bool
+		if ref, ok = left.(parser.ColumnRef); !ok {
+			return
+		}
+		value, ok := constant(table, right)
+		if !ok {
+			return
+		}
+		switch op {
+		case parser.OpEq:
+			vr = valueRange{lo: &value, hi: &value, loInc: true, hiInc: true}
+		case parser.OpGt, parser.OpGe:
+			vr = valueRange{lo: &value, loInc: op == parser.OpGe}
+		case parser.OpLt, parser.OpLe:
+			vr = valueRange{hi: &value, hiInc: op == parser.OpLe}
+		default:
+			return
+		}
+	case parser.BetweenExpr:
+		var ok bool
+		if ref, ok = e.Operand.(parser.ColumnRef); !ok || e.Not {
+			return
+		}
+		low, okLow := constant(table, e.Low)
+		high, okHigh := constant(table, e.High)
+		if !okLow || !okHigh {
+			return
+		}
+		vr = valueRange{lo: &low, hi: &high, loInc: true, hiInc: true}
+	default:
+		return
+	}
+	if !ownColumn(table, ref) {
+		return
+	}
+	column := table.ColumnIndex(ref.Column)
+	if old, ok := ranges[column]; ok {
+		vr = old.intersect(vr)
+	}
+	ranges[column] = &vr
+}
+
+// intersect return the range allowed by both ranges.
+func (vr *valueRange) intersect(other valueRange) valueRange {
+	result := *vr
+	if other.lo != nil {
+		if result.lo == nil {
+			result.lo, result.loInc = other.lo, other.loInc
+		} else if c := compareValues(*other.lo, *result.lo); c > 0 || (c == 0 && !other.loInc) {
+			result.lo, result.loInc = other.lo, other.loInc
+		}
+	}
+	if other.hi != nil {
+		if result.hi == nil {
+			result.hi, result.hiInc = other.hi, other.hiInc
+		} else if c := compareValues(*other.hi, *result.hi); c < 0 || (c == 0 && !other.hiInc) {
+			result.hi, result.hiInc = other.hi, other.hiInc
+		}
+	}
+	return result
+}
+
+// bounds turn the range into the bounds of an index whose first column is in the range.
+// The key of an entry is longer than the key of its values, see record.EncodeKeyAfter.
+func (vr *valueRange) bounds() (start, end *btree.Bound) {
+	if vr.lo != nil {
+		values := []parser.ColumnValue{*vr.lo}
+		if vr.loInc {
+			start = &btree.Bound{Key: record.EncodeKey(values), Inclusive: true}
+		} else {
+			start = &btree.Bound{Key: record.EncodeKeyAfter(values), Inclusive: true}
+		}
+	}
+	if vr.hi != nil {
+		values := []parser.ColumnValue{*vr.hi}
+		if vr.hiInc {
+			end = &btree.Bound{Key: record.EncodeKeyAfter(values), Inclusive: true}
+		} else {
+			end = &btree.Bound{Key: record.EncodeKey(values), Inclusive: false}
+		}
+	}
+	return start, end
+}
+
+// scan walk the rows of the table along the plan, and call fn on every row matching
+// the WHERE clause. fn must not modify the table or its indexes.
+func scan(bs *btree.Shared, table *catalog.Table, p plan, where parser.Expr, fn func(rowid uint32, values []parser.ColumnValue) error) error {
+	if p.index == nil {
+		it, err := bs.OpenBtree(table.RootPageNo).Iterate(nil, nil, p.dir)
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+		for ; err == nil && it.Valid(); err = it.Next() {
+			data, err := it.Data()
+			if err != nil {
+				return err
+			}
+			if err := visitRow(table, it.Key(), data, where, fn); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+	it, err := bs.OpenBtree(p.index.RootPageNo).Iterate(p.start, p.end, p.dir)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	cursor := bs.OpenBtree(table.RootPageNo).Cursor()
+	defer cursor.Close()
+	for ; err == nil && it.Valid(); err = it.Next() {
+		// the integer key of an index entry is the rowid of the row
+		rowid := it.Key()
+		loc, err := cursor.MoveTo(rowid)
+		if err != nil {
+			return err
+		}
+		if loc != 0 {
+			return ErrorCorruptIndex
+		}
+		data, err := cursor.Data()
+		if err != nil {
+			return err
+		}
+		if err := visitRow(table, rowid, data, where, fn); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// visitRow decode the row and call fn on it if the row match the WHERE clause.
+func visitRow(table *catalog.Table, rowid uint32, data []byte, where parser.Expr, fn func(rowid uint32, values []parser.ColumnValue) error) error {
+	values, err := record.Decode(table.Types, data)
+	if err != nil {
+		return err
+	}
+	match, err := matchWhere(where, &row{table, values})
+	if err != nil || !match {
+		return err
+	}
+	return fn(rowid, values)
+}
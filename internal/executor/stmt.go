@@ -0,0 +1,154 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"godb/internal/parser"
+	"math"
+)
+
+var (
+	ErrorNoSuchParam      = errors.New("no such parameter")
+	ErrorUnsupportedValue = errors.New("unsupported parameter value")
+	ErrorValueOutOfRange  = errors.New("integer out of range")
+	ErrorNotQuery         = errors.New("statement does not return rows")
+	ErrorUnboundParams    = errors.New("statement has parameters, prepare it to bind them")
+)
+
+// Stmt is a prepared statement. It is parsed once and run many times, with the
+// values bound to its parameters in place of `?`, `?NNN` and `:name`. The values never
+// go through the SQL text. The table and the access path of the statement are kept
+// between the runs, they are chosen again when the schema change or when the values
+// bound to the WHERE clause change.
+type Stmt struct {
+	exec   *Executor
+	stmt   interface{}          // the parsed statement
+	bound  Executable           // the statement bound to the values, it fill result
+	result *Result              // the result of the current run
+	names  map[string]int       // the index of every named parameter
+	values []parser.ColumnValue // the bound values, values[0] is the value of index 1
+}
+
+// Prepare parse a statement to run it later.
+func (e *Executor) Prepare(sql string) (*Stmt, error) {
+	stmt, err := parser.Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	count, names := parser.Params(stmt)
+	s := &Stmt{exec: e, stmt: stmt, result: new(Result), names: names, values: make([]parser.ColumnValue, count)}
+	s.ClearBindings()
+	bound, err := parser.BindParams(stmt, s.values)
+	if err != nil {
+		return nil, err
+	}
+	if s.bound, err = e.prepare(bound, sql, s.result); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NumParams return the number of parameters, which is the largest index.
+func (s *Stmt) NumParams() int {
+	return len(s.values)
+}
+
+// Bind bind a value to the parameter at index, starting from 1. The value is nil, a
+// bool, an integer, a string, a []byte or a parser.ColumnValue.
+func (s *Stmt) Bind(index int, value interface{}) error {
+	if index < 1 || index > len(s.values) {
+		return parser.ErrorParamIndex
+	}
+	cv, err := columnValue(value)
+	if err != nil {
+		return err
+	}
+	s.values[index-1] = cv
+	return nil
+}
+
+// BindName bind a value to the parameter `:name`, name include the colon.
+func (s *Stmt) BindName(name string, value interface{}) error {
+	index, ok := s.names[name]
+	if !ok {
+		return ErrorNoSuchParam
+	}
+	return s.Bind(index, value)
+}
+
+// ClearBindings set all the parameters to NULL.
+func (s *Stmt) ClearBindings() {
+	for i := range s.values {
+		s.values[i] = parser.NullValue()
+	}
+}
+
+// Exec run the statement, see ExecContext.
+func (s *Stmt) Exec(args ...interface{}) (*Result, error) {
+	return s.ExecContext(context.Background(), args...)
+}
+
+// ExecContext run the statement, the args are bound to the parameters from index 1
+// first. The bindings are kept for the next run. The wait for the transaction of
+// another executor stop when ctx is done.
+func (s *Stmt) ExecContext(ctx context.Context, args ...interface{}) (*Result, error) {
+	for i, arg := range args {
+		if err := s.Bind(i+1, arg); err != nil {
+			return nil, err
+		}
+	}
+	// the result of a run is kept by the caller, the next run fill a new one
+	*s.result = Result{}
+	if err := s.exec.run(ctx, s.bound); err != nil {
+		return nil, err
+	}
+	result := *s.result
+	return &result, nil
+}
+
+// Query run a SELECT statement, see Exec.
+func (s *Stmt) Query(args ...interface{}) (*Result, error) {
+	return s.QueryContext(context.Background(), args...)
+}
+
+// QueryContext run a SELECT statement, see ExecContext.
+func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*Result, error) {
+	if _, ok := s.stmt.(parser.SelectStatement); !ok {
+		return nil, ErrorNotQuery
+	}
+	return s.ExecContext(ctx, args...)
+}
+
+// columnValue convert a Go value to a column value, a bool is 1 or 0.
+func columnValue(value interface{}) (parser.ColumnValue, error) {
+	switch v := value.(type) {
+	case nil:
+		return parser.NullValue(), nil
+	case parser.ColumnValue:
+		return v, nil
+	case bool:
+		if v {
+			return valueTrue, nil
+		}
+		return valueFalse, nil
+	case int:
+		return intValue(int64(v))
+	case int32:
+		return parser.IntegerValue(v), nil
+	case int64:
+		return intValue(v)
+	case string:
+		return parser.VarcharValue(v), nil
+	case []byte:
+		return parser.VarcharValue(string(v)), nil
+	default:
+		return parser.ColumnValue{}, ErrorUnsupportedValue
+	}
+}
+
+func intValue(v int64) (parser.ColumnValue, error) {
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return parser.ColumnValue{}, ErrorValueOutOfRange
+	}
+	return parser.IntegerValue(int32(v)), nil
+}
@@ -0,0 +1,150 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"godb/internal/btree"
+	"godb/internal/parser"
+	"strings"
+)
+
+var (
+	ErrorInTransaction   = errors.New("cannot start a transaction within a transaction")
+	ErrorNoTransaction   = errors.New("no transaction is active")
+	ErrorNoSuchSavepoint = errors.New("no such savepoint")
+)
+
+// transactionExec run BEGIN, COMMIT, ROLLBACK, SAVEPOINT and RELEASE. Outside an
+// explicit transaction every statement is committed on its own.
+type transactionExec struct {
+	exec *Executor
+	stmt interface{}
+}
+
+func (te *transactionExec) execute() error {
+	return te.executeContext(context.Background())
+}
+
+// executeContext run the statement, the wait for the transaction of another executor
+// stop when ctx is done.
+func (te *transactionExec) executeContext(ctx context.Context) error {
+	e := te.exec
+	switch st := te.stmt.(type) {
+	case parser.BeginStatement:
+		if e.InTxn {
+			return ErrorInTransaction
+		}
+		// a deferred transaction read along with the other executors until its first
+		// write, the others keep the other executors out until they end
+		if st.Mode == parser.TransactionDeferred {
+			if err := e.Conn.BeginContext(ctx, btree.TxnDeferred); err != nil {
+				return err
+			}
+			e.InTxn = true
+			return nil
+		}
+		if err := e.Conn.BeginContext(ctx, btree.TxnWrite); err != nil {
+			return err
+		}
+		if err := e.Conn.Do(true, func() error { return e.lockTransaction(st.Mode) }); err != nil {
+			e.Conn.Rollback()
+			return err
+		}
+		e.InTxn = true
+		return nil
+	case parser.CommitStatement:
+		if !e.InTxn {
+			return ErrorNoTransaction
+		}
+		return e.endTransaction(e.Conn.Commit())
+	case parser.RollbackStatement:
+		if !e.InTxn {
+			return ErrorNoTransaction
+		}
+		if st.Savepoint == "" {
+			return e.Rollback()
+		}
+		n := e.findSavepoint(st.Savepoint)
+		if n < 0 {
+			return ErrorNoSuchSavepoint
+		}
+		if err := e.Conn.Do(true, func() error { return e.Shared.RollbackTo(n) }); err != nil {
+			return err
+		}
+		e.Savepoints = e.Savepoints[:n+1]
+		return nil
+	case parser.SavepointStatement:
+		// a savepoint outside a transaction start one, which end when it is released
+		if !e.InTxn {
+			if err := e.Conn.BeginContext(ctx, btree.TxnWrite); err != nil {
+				return err
+			}
+			e.InTxn, e.autoTxn = true, true
+		}
+		if err := e.Conn.DoContext(ctx, true, e.Shared.Savepoint); err != nil {
+			return err
+		}
+		e.Savepoints = append(e.Savepoints, st.Name)
+		return nil
+	case parser.ReleaseStatement:
+		n := e.findSavepoint(st.Name)
+		if n < 0 {
+			return ErrorNoSuchSavepoint
+		}
+		if n == 0 && e.autoTxn {
+			return e.endTransaction(e.Conn.Commit())
+		}
+		if err := e.Conn.Do(true, func() error { return e.Shared.ReleaseSavepoint(n) }); err != nil {
+			return err
+		}
+		e.Savepoints = e.Savepoints[:n]
+		return nil
+	default:
+		return ErrorUnsupportedStatement
+	}
+}
+
+// findSavepoint return the position of the innermost savepoint with the name, or -1.
+func (e *Executor) findSavepoint(name string) int {
+	for i := len(e.Savepoints) - 1; i >= 0; i-- {
+		if strings.EqualFold(e.Savepoints[i], name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// lockTransaction take the locks an immediate or exclusive transaction start with, a
+// deferred transaction take them when it first write.
+func (e *Executor) lockTransaction(mode parser.TransactionMode) error {
+	if err := e.Shared.Begin(); err != nil {
+		return err
+	}
+	if mode == parser.TransactionExclusive {
+		return e.Shared.Lock(btree.LockExclusive)
+	}
+	return nil
+}
+
+// BeginReadOnly start an explicit transaction that can not write, it read along with
+// the other executors. The wait for the transaction of another executor stop when ctx
+// is done.
+func (e *Executor) BeginReadOnly(ctx context.Context) error {
+	if e.InTxn {
+		return ErrorInTransaction
+	}
+	if err := e.Conn.BeginContext(ctx, btree.TxnRead); err != nil {
+		return err
+	}
+	e.InTxn = true
+	return nil
+}
+
+// endTransaction leave the explicit transaction if it is committed or rolled back.
+func (e *Executor) endTransaction(err error) error {
+	if err != nil {
+		return err
+	}
+	e.InTxn, e.autoTxn, e.Savepoints = false, false, nil
+	return nil
+}
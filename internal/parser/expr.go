@@ -0,0 +1,507 @@
+package parser
+
+import (
+	"godb/internal/tokenizer"
+	"strconv"
+	"strings"
+)
+
+// Expr is a node of the expression tree.
+type Expr interface {
+	String() string
+}
+
+type Operator int
+
+const (
+	OpOr Operator = iota
+	OpAnd
+	OpNot
+	OpEq
+	OpNe
+	OpLt
+	OpLe
+	OpGt
+	OpGe
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpNeg
+)
+
+func (op Operator) String() string {
+	switch op {
+	case OpOr:
+		return "or"
+	case OpAnd:
+		return "and"
+	case OpNot:
+		return "not"
+	case OpEq:
+		return "="
+	case OpNe:
+		return "<>"
+	case OpLt:
+		return "<"
+	case OpLe:
+		return "<="
+	case OpGt:
+		return ">"
+	case OpGe:
+		return ">="
+	case OpAdd:
+		return "+"
+	case OpSub:
+		return "-"
+	case OpMul:
+		return "*"
+	case OpDiv:
+		return "/"
+	case OpMod:
+		return "%"
+	case OpNeg:
+		return "-"
+	default:
+		return "unknow"
+	}
+}
+
+// ColumnRef is a reference to a column, Table is empty if the column is not qualified.
+type ColumnRef struct {
+	Table  string
+	Column string
+}
+
+// Literal is a constant value.
+type Literal struct {
+	Value ColumnValue
+}
+
+// Param is a parameter of a prepared statement, `?`, `?NNN` or `:name`. It is bound
+// to its value before the statement run, see BindParams.
+type Param struct {
+	Index int    // the index of the bound value, starting from 1
+	Name  string // the name of `:name` with its colon, empty for `?` and `?NNN`
+}
+
+// BoundParam is a parameter bound to the value of a prepared statement. The value is
+// read every time the statement run, so that a new value is bound without binding
+// the statement again. Unlike a Literal, it is never the position of a column in
+// ORDER BY.
+type BoundParam struct {
+	Param
+	Value *ColumnValue
+}
+
+// UnaryExpr is NOT or unary minus.
+type UnaryExpr struct {
+	Op      Operator
+	Operand Expr
+}
+
+// BinaryExpr is a logical, comparison or arithmetic operation.
+type BinaryExpr struct {
+	Op    Operator
+	Left  Expr
+	Right Expr
+}
+
+// IsNullExpr is `operand IS [NOT] NULL`.
+type IsNullExpr struct {
+	Operand Expr
+	Not     bool
+}
+
+// InExpr is `operand [NOT] IN (list)`.
+type InExpr struct {
+	Operand Expr
+	List    []Expr
+	Not     bool
+}
+
+// BetweenExpr is `operand [NOT] BETWEEN low AND high`.
+type BetweenExpr struct {
+	Operand Expr
+	Low     Expr
+	High    Expr
+	Not     bool
+}
+
+// LikeExpr is `operand [NOT] LIKE pattern`.
+type LikeExpr struct {
+	Operand Expr
+	Pattern Expr
+	Not     bool
+}
+
+func (e ColumnRef) String() string {
+	if e.Table != "" {
+		return e.Table + "." + e.Column
+	}
+	return e.Column
+}
+
+func (e Literal) String() string {
+	if e.Value.Type() == VarTypeVarchar {
+		return "'" + e.Value.String() + "'"
+	}
+	return e.Value.String()
+}
+
+func (e Param) String() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return "?" + strconv.Itoa(e.Index)
+}
+
+func (e UnaryExpr) String() string {
+	if e.Op == OpNot {
+		return "(not " + e.Operand.String() + ")"
+	}
+	return "(" + e.Op.String() + e.Operand.String() + ")"
+}
+
+func (e BinaryExpr) String() string {
+	return "(" + e.Left.String() + " " + e.Op.String() + " " + e.Right.String() + ")"
+}
+
+func notString(not bool) string {
+	if not {
+		return "not "
+	}
+	return ""
+}
+
+func (e IsNullExpr) String() string {
+	return "(" + e.Operand.String() + " is " + notString(e.Not) + "null)"
+}
+
+func (e InExpr) String() string {
+	list := make([]string, len(e.List))
+	for i, item := range e.List {
+		list[i] = item.String()
+	}
+	return "(" + e.Operand.String() + " " + notString(e.Not) + "in (" + strings.Join(list, ", ") + "))"
+}
+
+func (e BetweenExpr) String() string {
+	return "(" + e.Operand.String() + " " + notString(e.Not) + "between " + e.Low.String() + " and " + e.High.String() + ")"
+}
+
+func (e LikeExpr) String() string {
+	return "(" + e.Operand.String() + " " + notString(e.Not) + "like " + e.Pattern.String() + ")"
+}
+
+// The precedence of the operators, from the lowest to the highest.
+const (
+	precOr = iota + 1
+	precAnd
+	precNot
+	precEquality // = <> IS IN LIKE BETWEEN
+	precCompare  // < <= > >=
+	precAdd      // + -
+	precMul      // * / %
+	precUnary    // unary -
+)
+
+// binaryOperator return the operator and the precedence of a binary operator token.
+// The precedence is 0 if the token is not a binary operator.
+func binaryOperator(token tokenizer.Token) (Operator, int) {
+	switch token.TokenType {
+	case tokenizer.TokenEq:
+		return OpEq, precEquality
+	case tokenizer.TokenNe:
+		return OpNe, precEquality
+	case tokenizer.TokenLt:
+		return OpLt, precCompare
+	case tokenizer.TokenLe:
+		return OpLe, precCompare
+	case tokenizer.TokenGt:
+		return OpGt, precCompare
+	case tokenizer.TokenGe:
+		return OpGe, precCompare
+	case tokenizer.TokenPlus:
+		return OpAdd, precAdd
+	case tokenizer.TokenMinus:
+		return OpSub, precAdd
+	case tokenizer.TokenStar:
+		return OpMul, precMul
+	case tokenizer.TokenSlash:
+		return OpDiv, precMul
+	case tokenizer.TokenPercent:
+		return OpMod, precMul
+	case tokenizer.TokenKeyword:
+		switch token.Value {
+		case "or":
+			return OpOr, precOr
+		case "and":
+			return OpAnd, precAnd
+		case "is", "in", "like", "between", "not":
+			// handled as postfix operators
+			return 0, precEquality
+		}
+	}
+	return 0, 0
+}
+
+// ParseExpr parse a whole string as an expression.
+func ParseExpr(str string) (Expr, error) {
+	tk := tokenizer.NewTokenizer(str)
+	expr, err := parseExpr(&tk, precOr)
+	if err != nil {
+		return nil, err
+	}
+	if !atEnd(&tk) {
+		return nil, ErrorInvaildStatement
+	}
+	return expr, nil
+}
+
+// atEnd return true if all the tokens are consumed.
+func atEnd(tk *tokenizer.Tokenizer) bool {
+	_, err := tk.PeekToken()
+	return err == tokenizer.ErrorEndofFile
+}
+
+// parseExpr parse an expression by precedence climbing. Only the operators whose
+// precedence is not lower than minPrec are consumed.
+func parseExpr(tk *tokenizer.Tokenizer, minPrec int) (Expr, error) {
+	left, err := parsePrefix(tk)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token, err := tk.PeekToken()
+		if err != nil {
+			// the end of the expression
+			return left, nil
+		}
+		op, prec := binaryOperator(token)
+		if prec == 0 || prec < minPrec {
+			return left, nil
+		}
+		if token.TokenType == tokenizer.TokenKeyword && prec == precEquality {
+			left, err = parsePostfix(tk, left)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		tk.PopToken()
+		// all the binary operators are left associative
+		right, err := parseExpr(tk, prec+1)
+		if err != nil {
+			return nil, err
+		}
+		left = BinaryExpr{op, left, right}
+	}
+}
+
+// parsePostfix parse IS [NOT] NULL, [NOT] IN, [NOT] BETWEEN and [NOT] LIKE.
+func parsePostfix(tk *tokenizer.Tokenizer, operand Expr) (Expr, error) {
+	token, _ := tk.PeekToken()
+	tk.PopToken()
+	if token.Value == "is" {
+		not := false
+		next, err := tk.PeekToken()
+		if err == nil && next.TokenType == tokenizer.TokenKeyword && next.Value == "not" {
+			not = true
+			tk.PopToken()
+		}
+		null, err := tk.PeekToken()
+		if err != nil || null.TokenType != tokenizer.TokenKeyword || null.Value != "null" {
+			return nil, ErrorInvaildStatement
+		}
+		tk.PopToken()
+		return IsNullExpr{operand, not}, nil
+	}
+	not := false
+	if token.Value == "not" {
+		not = true
+		next, err := tk.PeekToken()
+		if err != nil || next.TokenType != tokenizer.TokenKeyword {
+			return nil, ErrorInvaildStatement
+		}
+		token = next
+		tk.PopToken()
+	}
+	switch token.Value {
+	case "in":
+		list, err := parseExprList(tk)
+		if err != nil {
+			return nil, err
+		}
+		return InExpr{operand, list, not}, nil
+	case "between":
+		// the AND of BETWEEN is not a logical operator
+		low, err := parseExpr(tk, precEquality+1)
+		if err != nil {
+			return nil, err
+		}
+		and, err := tk.PeekToken()
+		if err != nil || and.TokenType != tokenizer.TokenKeyword || and.Value != "and" {
+			return nil, ErrorInvaildStatement
+		}
+		tk.PopToken()
+		high, err := parseExpr(tk, precEquality+1)
+		if err != nil {
+			return nil, err
+		}
+		return BetweenExpr{operand, low, high, not}, nil
+	case "like":
+		pattern, err := parseExpr(tk, precEquality+1)
+		if err != nil {
+			return nil, err
+		}
+		return LikeExpr{operand, pattern, not}, nil
+	default:
+		return nil, ErrorInvaildStatement
+	}
+}
+
+// parseExprList parse a parenthesized, comma separated list of expressions.
+func parseExprList(tk *tokenizer.Tokenizer) ([]Expr, error) {
+	lp, err := tk.PeekToken()
+	if err != nil || lp.TokenType != tokenizer.TokenLP {
+		return nil, ErrorInvaildStatement
+	}
+	tk.PopToken()
+	var list []Expr
+	for {
+		expr, err := parseExpr(tk, precOr)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, expr)
+		symbol, err := tk.PeekToken()
+		if err != nil {
+			return nil, ErrorInvaildStatement
+		}
+		tk.PopToken()
+		if symbol.TokenType == tokenizer.TokenRP {
+			return list, nil
+		} else if symbol.TokenType != tokenizer.TokenComma {
+			return nil, ErrorInvaildStatement
+		}
+	}
+}
+
+// parsePrefix parse a prefix operator or a primary expression.
+func parsePrefix(tk *tokenizer.Tokenizer) (Expr, error) {
+	token, err := tk.PeekToken()
+	if err != nil {
+		return nil, ErrorInvaildStatement
+	}
+	switch token.TokenType {
+	case tokenizer.TokenKeyword:
+		switch token.Value {
+		case "not":
+			tk.PopToken()
+			operand, err := parseExpr(tk, precNot)
+			if err != nil {
+				return nil, err
+			}
+			return UnaryExpr{OpNot, operand}, nil
+		case "null":
+			tk.PopToken()
+			return Literal{NullValue()}, nil
+		}
+		return nil, ErrorInvaildStatement
+	case tokenizer.TokenMinus, tokenizer.TokenPlus:
+		tk.PopToken()
+		// a negative number is a literal, the smallest int32 has no positive operand
+		digits, err := tk.PeekToken()
+		if token.TokenType == tokenizer.TokenMinus && err == nil && digits.TokenType == tokenizer.TokenDigit {
+			value, err := strconv.ParseInt("-"+digits.Value, 10, 32)
+			if err != nil {
+				return nil, ErrorInvaildStatement
+			}
+			tk.PopToken()
+			return Literal{IntegerValue(int32(value))}, nil
+		}
+		operand, err := parseExpr(tk, precUnary)
+		if err != nil {
+			return nil, err
+		}
+		if token.TokenType == tokenizer.TokenPlus {
+			return operand, nil
+		}
+		return UnaryExpr{OpNeg, operand}, nil
+	case tokenizer.TokenLP:
+		tk.PopToken()
+		expr, err := parseExpr(tk, precOr)
+		if err != nil {
+			return nil, err
+		}
+		rp, err := tk.PeekToken()
+		if err != nil || rp.TokenType != tokenizer.TokenRP {
+			return nil, ErrorInvaildStatement
+		}
+		tk.PopToken()
+		return expr, nil
+	case tokenizer.TokenDigit:
+		value, err := strconv.ParseInt(token.Value, 10, 32)
+		if err != nil {
+			return nil, ErrorInvaildStatement
+		}
+		tk.PopToken()
+		return Literal{IntegerValue(int32(value))}, nil
+	case tokenizer.TokenString:
+		tk.PopToken()
+		return Literal{VarcharValue(token.Value)}, nil
+	case tokenizer.TokenParam:
+		tk.PopToken()
+		return parseParam(token.Value)
+	case tokenizer.TokenIdentifier:
+		tk.PopToken()
+		dot, err := tk.PeekToken()
+		if err != nil || dot.TokenType != tokenizer.TokenDot {
+			return ColumnRef{Column: token.Value}, nil
+		}
+		// a qualified column 'table.column'. 'table.*' is only valid in the SELECT list
+		tk.PopToken()
+		column, err := tk.PeekToken()
+		if err != nil || (column.TokenType != tokenizer.TokenIdentifier && column.TokenType != tokenizer.TokenStar) {
+			return nil, ErrorInvaildStatement
+		}
+		tk.PopToken()
+		return ColumnRef{Table: token.Value, Column: column.Value}, nil
+	default:
+		return nil, ErrorInvaildStatement
+	}
+}
+
+// WalkExpr call fn on the expression and all its sub expressions, parents first.
+// The walk stop at the first error.
+func WalkExpr(expr Expr, fn func(Expr) error) error {
+	if expr == nil {
+		return nil
+	}
+	if err := fn(expr); err != nil {
+		return err
+	}
+	var children []Expr
+	switch e := expr.(type) {
+	case UnaryExpr:
+		children = []Expr{e.Operand}
+	case BinaryExpr:
+		children = []Expr{e.Left, e.Right}
+	case IsNullExpr:
+		children = []Expr{e.Operand}
+	case InExpr:
+		children = append([]Expr{e.Operand}, e.List...)
+	case BetweenExpr:
+		children = []Expr{e.Operand, e.Low, e.High}
+	case LikeExpr:
+		children = []Expr{e.Operand, e.Pattern}
+	}
+	for _, child := range children {
+		if err := WalkExpr(child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseExprPrecedence(t *testing.T) {
+	cases := map[string]string{
+		"a = 1 or b = 2 and c = 3":             "((a = 1) or ((b = 2) and (c = 3)))",
+		"not a = 1 and b":                      "((not (a = 1)) and b)",
+		"1 + 2 * 3 - 4":                        "((1 + (2 * 3)) - 4)",
+		"(1 + 2) * -x % 5":                     "(((1 + 2) * (-x)) % 5)",
+		"a < b = c >= d":                       "((a < b) = (c >= d))",
+		"a <> 1 and b != 2":                    "((a <> 1) and (b <> 2))",
+		"name is not null":                     "(name is not null)",
+		"x is null or y not in (1, 'a', null)": "((x is null) or (y not in (1, 'a', NULL)))",
+		"x between 1 + 1 and 10 and y":         "((x between (1 + 1) and 10) and y)",
+		"name not like 'a%' or NOT x":          "((name not like 'a%') or (not x))",
+		"x = -2147483648 or -x > - 2 * 3":      "((x = -2147483648) or ((-x) > (-2 * 3)))",
+	}
+	for str, expected := range cases {
+		expr, err := ParseExpr(str)
+		assert.Nil(t, err, str)
+		if err == nil {
+			assert.Equal(t, expected, expr.String(), str)
+		}
+	}
+}
+
+func TestParseExprError(t *testing.T) {
+	for _, str := range []string{"", "a =", "(a", "a in 1", "a between 1", "a is 1", "a not b", "1 2", "a ! b", "2147483648", "-2147483649", "-99999999999"} {
+		_, err := ParseExpr(str)
+		assert.NotNil(t, err, str)
+	}
+}
+
+func TestParseSelectWhere(t *testing.T) {
+	stmt, err := Parse("select * from users where id >= 10 and name like 'a%'")
+	assert.Nil(t, err)
+	sel := stmt.(SelectStatement)
+	assert.Equal(t, "users", sel.TableName)
+	assert.Equal(t, "((id >= 10) and (name like 'a%'))", sel.Where.String())
+
+	_, err = Parse("select * from users where")
+	assert.NotNil(t, err)
+	_, err = Parse("select * from users id = 1")
+	assert.NotNil(t, err)
+}
+
+func TestParseSelectColumns(t *testing.T) {
+	stmt, err := Parse("select *, u.*, u.id, name AS n, id + 1 total, 'x' from users where u.id = 1")
+	assert.Nil(t, err)
+	sel := stmt.(SelectStatement)
+	assert.Equal(t, "users", sel.TableName)
+	assert.Equal(t, 6, len(sel.Columns))
+	assert.Equal(t, ResultColumn{Star: true}, sel.Columns[0])
+	assert.Equal(t, ResultColumn{Star: true, StarTable: "u"}, sel.Columns[1])
+	assert.Equal(t, ResultColumn{Expr: ColumnRef{"u", "id"}}, sel.Columns[2])
+	assert.Equal(t, ResultColumn{Expr: ColumnRef{Column: "name"}, Alias: "n"}, sel.Columns[3])
+	assert.Equal(t, "total", sel.Columns[4].Alias)
+	assert.Equal(t, "(id + 1)", sel.Columns[4].Expr.String())
+	assert.Equal(t, "'x'", sel.Columns[5].Expr.String())
+	assert.Equal(t, "(u.id = 1)", sel.Where.String())
+
+	for _, sql := range []string{"select from users", "select id users", "select id, from users", "select id as from users", "select u. from users"} {
+		_, err = Parse(sql)
+		assert.NotNil(t, err, sql)
+	}
+}
+
+func TestParseSelectOrderBy(t *testing.T) {
+	stmt, err := Parse("select * from users where id between 1 and 10 order by id desc, name + 1, age asc")
+	assert.Nil(t, err)
+	sel := stmt.(SelectStatement)
+	assert.Equal(t, "(id between 1 and 10)", sel.Where.String())
+	assert.Equal(t, 3, len(sel.OrderBy))
+	assert.Equal(t, OrderingTerm{Expr: ColumnRef{Column: "id"}, Desc: true}, sel.OrderBy[0])
+	assert.Equal(t, "(name + 1)", sel.OrderBy[1].Expr.String())
+	assert.Equal(t, OrderingTerm{Expr: ColumnRef{Column: "age"}}, sel.OrderBy[2])
+	stmt, err = Parse("select id from users order by id")
+	assert.Nil(t, err)
+	assert.Nil(t, stmt.(SelectStatement).Where)
+
+	// the words of ORDER BY are not reserved
+	stmt, err = Parse("select order, by from asc where desc = 1 ORDER BY desc DESC, by")
+	assert.Nil(t, err)
+	sel = stmt.(SelectStatement)
+	assert.Equal(t, "asc", sel.TableName)
+	assert.Equal(t, "(desc = 1)", sel.Where.String())
+	assert.Equal(t, []OrderingTerm{{Expr: ColumnRef{Column: "desc"}, Desc: true}, {Expr: ColumnRef{Column: "by"}}}, sel.OrderBy)
+	stmt, err = Parse("create table asc (order integer, by integer, desc integer)")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"order", "by", "desc"}, stmt.(CreateTableStatement).FieldName)
+
+	for _, sql := range []string{"select * from users order id", "select * from users order by", "select * from users order by id,", "select * from users order by id desc asc", "select * from users order by id where id = 1"} {
+		_, err = Parse(sql)
+		assert.NotNil(t, err, sql)
+	}
+}
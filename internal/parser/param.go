@@ -0,0 +1,196 @@
+package parser
+
+import (
+	"errors"
+	"strconv"
+)
+
+// MaxParams is the largest index of a parameter.
+const MaxParams = 999
+
+var (
+	ErrorParamIndex = errors.New("parameter index out of range")
+)
+
+// parseParam parse the token of a parameter. The index of `?` and `:name` is given by
+// numberParams once the whole statement is parsed.
+func parseParam(value string) (Param, error) {
+	if value[0] == ':' {
+		return Param{Name: value}, nil
+	}
+	if len(value) == 1 {
+		return Param{}, nil
+	}
+	index, err := strconv.Atoi(value[1:])
+	if err != nil || index < 1 || index > MaxParams {
+		return Param{}, ErrorParamIndex
+	}
+	return Param{Index: index}, nil
+}
+
+// numberParams number the parameters in the order they appear in the statement. A `?`
+// take the index after the largest one so far, a `:name` take a new index the first
+// time the name appear, and the same index afterwards.
+func numberParams(stmt interface{}) (interface{}, error) {
+	count := 0
+	names := make(map[string]int)
+	return mapParams(stmt, func(p Param) (Expr, error) {
+		if p.Name != "" {
+			if index, ok := names[p.Name]; ok {
+				p.Index = index
+				return p, nil
+			}
+			count++
+			p.Index = count
+			names[p.Name] = p.Index
+		} else if p.Index == 0 {
+			count++
+			p.Index = count
+		} else if p.Index > count {
+			count = p.Index
+		}
+		if p.Index > MaxParams {
+			return nil, ErrorParamIndex
+		}
+		return p, nil
+	})
+}
+
+// Params return the number of parameters of the statement, which is the largest
+// index, and the index of every named parameter.
+func Params(stmt interface{}) (int, map[string]int) {
+	count := 0
+	names := make(map[string]int)
+	mapParams(stmt, func(p Param) (Expr, error) {
+		if p.Index > count {
+			count = p.Index
+		}
+		if p.Name != "" {
+			names[p.Name] = p.Index
+		}
+		return p, nil
+	})
+	return count, names
+}
+
+// BindParams return a copy of the statement with every parameter bound to its value
+// in values, values[0] is the value of index 1. The statement read the values every
+// time it run, values must have room for every parameter and must not be reallocated.
+func BindParams(stmt interface{}, values []ColumnValue) (interface{}, error) {
+	return mapParams(stmt, func(p Param) (Expr, error) {
+		if p.Index > len(values) {
+			return nil, ErrorParamIndex
+		}
+		return BoundParam{p, &values[p.Index-1]}, nil
+	})
+}
+
+// mapParams call fn on the parameters of the statement in the order they appear, and
+// return a copy of the statement with the parameters replaced by the results of fn.
+func mapParams(stmt interface{}, fn func(Param) (Expr, error)) (interface{}, error) {
+	var err error
+	switch st := stmt.(type) {
+	case InsertStatement:
+		if st.Params == nil {
+			return st, nil
+		}
+		params := make(map[int]Expr)
+		for i := range st.Values {
+			p, ok := st.Params[i].(Param)
+			if !ok {
+				continue
+			}
+			if params[i], err = fn(p); err != nil {
+				return nil, err
+			}
+		}
+		st.Params = params
+		return st, nil
+	case SelectStatement:
+		st.Columns = append([]ResultColumn(nil), st.Columns...)
+		for i := range st.Columns {
+			if st.Columns[i].Expr, err = mapExprParams(st.Columns[i].Expr, fn); err != nil {
+				return nil, err
+			}
+		}
+		if st.Where, err = mapExprParams(st.Where, fn); err != nil {
+			return nil, err
+		}
+		st.OrderBy = append([]OrderingTerm(nil), st.OrderBy...)
+		for i := range st.OrderBy {
+			if st.OrderBy[i].Expr, err = mapExprParams(st.OrderBy[i].Expr, fn); err != nil {
+				return nil, err
+			}
+		}
+		return st, nil
+	case UpdateStatement:
+		st.Values = append([]Expr(nil), st.Values...)
+		for i := range st.Values {
+			if st.Values[i], err = mapExprParams(st.Values[i], fn); err != nil {
+				return nil, err
+			}
+		}
+		if st.Where, err = mapExprParams(st.Where, fn); err != nil {
+			return nil, err
+		}
+		return st, nil
+	case DeleteStatement:
+		if st.Where, err = mapExprParams(st.Where, fn); err != nil {
+			return nil, err
+		}
+		return st, nil
+	default:
+		return stmt, nil
+	}
+}
+
+// mapExprParams return a copy of the expression with the parameters replaced by the
+// results of fn, the operands are visited from left to right.
+func mapExprParams(expr Expr, fn func(Param) (Expr, error)) (Expr, error) {
+	var err error
+	switch e := expr.(type) {
+	case Param:
+		return fn(e)
+	case UnaryExpr:
+		e.Operand, err = mapExprParams(e.Operand, fn)
+		return e, err
+	case BinaryExpr:
+		if e.Left, err = mapExprParams(e.Left, fn); err != nil {
+			return nil, err
+		}
+		e.Right, err = mapExprParams(e.Right, fn)
+		return e, err
+	case IsNullExpr:
+		e.Operand, err = mapExprParams(e.Operand, fn)
+		return e, err
+	case InExpr:
+		if e.Operand, err = mapExprParams(e.Operand, fn); err != nil {
+			return nil, err
+		}
+		list := make([]Expr, len(e.List))
+		for i, item := range e.List {
+			if list[i], err = mapExprParams(item, fn); err != nil {
+				return nil, err
+			}
+		}
+		e.List = list
+		return e, nil
+	case BetweenExpr:
+		if e.Operand, err = mapExprParams(e.Operand, fn); err != nil {
+			return nil, err
+		}
+		if e.Low, err = mapExprParams(e.Low, fn); err != nil {
+			return nil, err
+		}
+		e.High, err = mapExprParams(e.High, fn)
+		return e, err
+	case LikeExpr:
+		if e.Operand, err = mapExprParams(e.Operand, fn); err != nil {
+			return nil, err
+		}
+		e.Pattern, err = mapExprParams(e.Pattern, fn)
+		return e, err
+	default:
+		return expr, nil
+	}
+}
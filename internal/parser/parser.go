@@ -6,6 +6,7 @@ import (
 	"errors"
 	"godb/internal/tokenizer"
 	"strconv"
+	"strings"
 )
 
 var (
@@ -21,51 +22,65 @@ func Parse(statement string) (interface{}, error) {
 	}
 	switch token.TokenType {
 	case (tokenizer.TokenMetaCommand):
-		return parseMetaCommand(tk)
-	case (tokenizer.TokenKeyword):
-		return parseCommand(tk)
+		return parseMetaCommand(statement)
+	case tokenizer.TokenKeyword, tokenizer.TokenIdentifier:
+		stmt, err := parseCommand(tk)
+		if err != nil {
+			return nil, err
+		}
+		return numberParams(stmt)
 	default:
 		return nil, ErrorInvaildStatement
 	}
 }
 
-func parseMetaCommand(tk tokenizer.Tokenizer) (interface{}, error) {
-	var mt MetaCommandType
-	var tmp []string
-	for {
-		token, err := tk.PeekToken()
-		if err != nil {
-			break
-		}
-		tk.PopToken()
-		switch token.TokenType {
-		case tokenizer.TokenMetaCommand:
-			switch token.Value {
-			case "exit":
-				mt = MetaCommandExit
-			default:
-				return nil, ErrorInvaildStatement
-			}
-		default:
-			tmp = append(tmp, token.Value)
-		}
+// parseMetaCommand split the meta command by blanks. The parameters are kept as they
+// are, because a file name can not be tokenized.
+func parseMetaCommand(statement string) (interface{}, error) {
+	fields := strings.Fields(statement)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], ".") {
+		return nil, ErrorInvaildStatement
 	}
-	return ExitMetaStatement{mt, tmp}, nil
+	mt, ok := metaCommands[strings.ToLower(fields[0][1:])]
+	if !ok {
+		return nil, ErrorInvaildStatement
+	}
+	return MetaStatement{mt, fields[1:]}, nil
 }
 
 func parseCommand(tk tokenizer.Tokenizer) (interface{}, error) {
 	keyword, err := tk.PeekToken()
-	if err != nil || keyword.TokenType != tokenizer.TokenKeyword {
+	if err != nil || (keyword.TokenType != tokenizer.TokenKeyword && keyword.TokenType != tokenizer.TokenIdentifier) {
 		return nil, ErrorInvaildStatement
 	}
 	tk.PopToken()
-	switch keyword.Value {
+	switch strings.ToLower(keyword.Value) {
 	case "create":
+		token, err := tk.PeekToken()
+		if err == nil && (isWord(token, "index") || isWord(token, "unique")) {
+			return parseCreateIndexCommand(tk)
+		}
 		return parseCreateCommand(tk)
 	case "insert":
 		return parseInsertCommand(tk)
 	case "select":
 		return parseSelectCommand(tk)
+	case "update":
+		return parseUpdateCommand(tk)
+	case "delete":
+		return parseDeleteCommand(tk)
+	case "drop":
+		return parseDropCommand(tk)
+	case "begin":
+		return parseBeginCommand(tk)
+	case "commit", "end":
+		return parseCommitCommand(tk)
+	case "rollback":
+		return parseRollbackCommand(tk)
+	case "savepoint":
+		return parseSavepointCommand(tk)
+	case "release":
+		return parseReleaseCommand(tk)
 	default:
 		return nil, ErrorInvaildStatement
 	}
@@ -107,21 +122,82 @@ func parseCreateCommand(tk tokenizer.Tokenizer) (CreateTableStatement, error) {
 		if err != nil {
 			return CreateTableStatement{}, ErrorInvaildStatement
 		}
+		// the last column is followed by ')' instead of ','
+		ct.FieldName = append(ct.FieldName, varName.Value)
+		ct.FiledType = append(ct.FiledType, varType)
 		symbol, err := tk.PeekToken()
 		if err != nil {
 			return CreateTableStatement{}, ErrorInvaildStatement
 		} else if symbol.TokenType == tokenizer.TokenRP {
+			tk.PopToken()
 			break
 		} else if symbol.TokenType != tokenizer.TokenComma {
 			return CreateTableStatement{}, ErrorInvaildStatement
 		}
 		tk.PopToken()
-		ct.FieldName = append(ct.FieldName, varName.Value)
-		ct.FiledType = append(ct.FiledType, varType)
 	}
 	return ct, nil
 }
 
+// parseCreateIndexCommand parse 'CREATE [UNIQUE] INDEX name ON table (column, ...)'.
+func parseCreateIndexCommand(tk tokenizer.Tokenizer) (CreateIndexStatement, error) {
+	var ci CreateIndexStatement
+	token, err := tk.PeekToken()
+	if err == nil && isWord(token, "unique") {
+		tk.PopToken()
+		ci.Unique = true
+		token, err = tk.PeekToken()
+	}
+	if err != nil || !isWord(token, "index") {
+		return CreateIndexStatement{}, ErrorInvaildStatement
+	}
+	tk.PopToken()
+	indexName, err := tk.PeekToken()
+	if err != nil || indexName.TokenType != tokenizer.TokenIdentifier {
+		return CreateIndexStatement{}, ErrorInvaildStatement
+	}
+	ci.IndexName = indexName.Value
+	tk.PopToken()
+	on, err := tk.PeekToken()
+	if err != nil || !isWord(on, "on") {
+		return CreateIndexStatement{}, ErrorInvaildStatement
+	}
+	tk.PopToken()
+	tableName, err := tk.PeekToken()
+	if err != nil || tableName.TokenType != tokenizer.TokenIdentifier {
+		return CreateIndexStatement{}, ErrorInvaildStatement
+	}
+	ci.TableName = tableName.Value
+	tk.PopToken()
+	lp, err := tk.PeekToken()
+	if err != nil || lp.TokenType != tokenizer.TokenLP {
+		return CreateIndexStatement{}, ErrorInvaildStatement
+	}
+	tk.PopToken()
+	for {
+		column, err := tk.PeekToken()
+		if err != nil || column.TokenType != tokenizer.TokenIdentifier {
+			return CreateIndexStatement{}, ErrorInvaildStatement
+		}
+		ci.Columns = append(ci.Columns, column.Value)
+		tk.PopToken()
+		symbol, err := tk.PeekToken()
+		if err != nil {
+			return CreateIndexStatement{}, ErrorInvaildStatement
+		}
+		tk.PopToken()
+		if symbol.TokenType == tokenizer.TokenRP {
+			break
+		} else if symbol.TokenType != tokenizer.TokenComma {
+			return CreateIndexStatement{}, ErrorInvaildStatement
+		}
+	}
+	if !atEnd(&tk) {
+		return CreateIndexStatement{}, ErrorInvaildStatement
+	}
+	return ci, nil
+}
+
 func parseType(tk *tokenizer.Tokenizer) (ColumnType, error) {
 	token, err := tk.PeekToken()
 	if err != nil || token.TokenType != tokenizer.TokenKeyword {
@@ -148,7 +224,7 @@ func parseType(tk *tokenizer.Tokenizer) (ColumnType, error) {
 		tk.PopToken()
 		rp, err := tk.PeekToken()
 		if err != nil || rp.TokenType != tokenizer.TokenRP {
-			return ColumnType{}, nil
+			return ColumnType{}, ErrorInvaildStatement
 		}
 		tk.PopToken()
 		return ColumnType{VarTypeVarchar, varlen}, nil
@@ -189,9 +265,23 @@ func parseInsertCommand(tk tokenizer.Tokenizer) (InsertStatement, error) {
 			tk.PopToken()
 			break
 		}
+		negative := false
+		if token.TokenType == tokenizer.TokenMinus {
+			tk.PopToken()
+			token, err = tk.PeekToken()
+			if err != nil || token.TokenType != tokenizer.TokenDigit {
+				return InsertStatement{}, ErrorInvaildStatement
+			}
+			negative = true
+		}
 		switch token.TokenType {
 		case tokenizer.TokenDigit:
-			value, err := strconv.Atoi(token.Value)
+			// the sign is parsed with the digits, so that the smallest int32 is in range
+			digits := token.Value
+			if negative {
+				digits = "-" + digits
+			}
+			value, err := strconv.ParseInt(digits, 10, 32)
 			buf := new(bytes.Buffer)
 			if err != nil {
 				return InsertStatement{}, ErrorInvaildStatement
@@ -204,6 +294,21 @@ func parseInsertCommand(tk tokenizer.Tokenizer) (InsertStatement, error) {
 		case tokenizer.TokenString:
 			strBytes := []byte(token.Value)
 			cv.Values = append(cv.Values, ColumnValue{VarTypeVarchar, strBytes})
+		case tokenizer.TokenKeyword:
+			if token.Value != "null" {
+				return InsertStatement{}, ErrorInvaildStatement
+			}
+			cv.Values = append(cv.Values, NullValue())
+		case tokenizer.TokenParam:
+			param, err := parseParam(token.Value)
+			if err != nil {
+				return InsertStatement{}, ErrorInvaildStatement
+			}
+			if cv.Params == nil {
+				cv.Params = make(map[int]Expr)
+			}
+			cv.Params[len(cv.Values)] = param
+			cv.Values = append(cv.Values, NullValue())
 		default:
 			return InsertStatement{}, ErrorInvaildStatement
 		}
@@ -224,15 +329,323 @@ func parseInsertCommand(tk tokenizer.Tokenizer) (InsertStatement, error) {
 func parseSelectCommand(tk tokenizer.Tokenizer) (SelectStatement, error) {
 	var cv SelectStatement
 	for {
-		token, err := tk.PeekToken()
+		column, err := parseResultColumn(&tk)
+		if err != nil {
+			return SelectStatement{}, err
+		}
+		cv.Columns = append(cv.Columns, column)
+		symbol, err := tk.PeekToken()
+		if err != nil || symbol.TokenType != tokenizer.TokenComma {
+			break
+		}
+		tk.PopToken()
+	}
+	from, err := tk.PeekToken()
+	if err != nil || from.TokenType != tokenizer.TokenKeyword || from.Value != "from" {
+		return SelectStatement{}, ErrorInvaildStatement
+	}
+	tk.PopToken()
+	tableName, err := tk.PeekToken()
+	if err != nil || tableName.TokenType != tokenizer.TokenIdentifier {
+		return SelectStatement{}, ErrorInvaildStatement
+	}
+	cv.TableName = tableName.Value
+	tk.PopToken()
+	where, err := parseWhereClause(&tk)
+	if err != nil {
+		return SelectStatement{}, err
+	}
+	cv.Where = where
+	orderBy, err := parseOrderBy(&tk)
+	if err != nil {
+		return SelectStatement{}, err
+	}
+	cv.OrderBy = orderBy
+	if !atEnd(&tk) {
+		return SelectStatement{}, ErrorInvaildStatement
+	}
+	return cv, nil
+}
+
+func parseUpdateCommand(tk tokenizer.Tokenizer) (UpdateStatement, error) {
+	var cv UpdateStatement
+	tableName, err := tk.PeekToken()
+	if err != nil || tableName.TokenType != tokenizer.TokenIdentifier {
+		return UpdateStatement{}, ErrorInvaildStatement
+	}
+	cv.TableName = tableName.Value
+	tk.PopToken()
+	set, err := tk.PeekToken()
+	if err != nil || set.TokenType != tokenizer.TokenKeyword || set.Value != "set" {
+		return UpdateStatement{}, ErrorInvaildStatement
+	}
+	tk.PopToken()
+	for {
+		column, err := tk.PeekToken()
+		if err != nil || column.TokenType != tokenizer.TokenIdentifier {
+			return UpdateStatement{}, ErrorInvaildStatement
+		}
+		tk.PopToken()
+		eq, err := tk.PeekToken()
+		if err != nil || eq.TokenType != tokenizer.TokenEq {
+			return UpdateStatement{}, ErrorInvaildStatement
+		}
+		tk.PopToken()
+		value, err := parseExpr(&tk, precOr)
 		if err != nil {
-			return SelectStatement{}, ErrorInvaildStatement
+			return UpdateStatement{}, err
 		}
-		if token.TokenType == tokenizer.TokenIdentifier {
-			cv.TableName = token.Value
+		cv.Columns = append(cv.Columns, column.Value)
+		cv.Values = append(cv.Values, value)
+		symbol, err := tk.PeekToken()
+		if err != nil || symbol.TokenType != tokenizer.TokenComma {
 			break
 		}
 		tk.PopToken()
 	}
+	where, err := parseWhere(&tk)
+	if err != nil {
+		return UpdateStatement{}, err
+	}
+	cv.Where = where
 	return cv, nil
 }
+
+func parseDropCommand(tk tokenizer.Tokenizer) (DropTableStatement, error) {
+	var dt DropTableStatement
+	table, err := tk.PeekToken()
+	if err != nil || table.TokenType != tokenizer.TokenKeyword || table.Value != "table" {
+		return DropTableStatement{}, ErrorInvaildStatement
+	}
+	tk.PopToken()
+	token, err := tk.PeekToken()
+	if err == nil && token.TokenType == tokenizer.TokenKeyword && token.Value == "if" {
+		tk.PopToken()
+		exists, err := tk.PeekToken()
+		if err != nil || exists.TokenType != tokenizer.TokenKeyword || exists.Value != "exists" {
+			return DropTableStatement{}, ErrorInvaildStatement
+		}
+		tk.PopToken()
+		dt.IfExists = true
+	}
+	tableName, err := tk.PeekToken()
+	if err != nil || tableName.TokenType != tokenizer.TokenIdentifier {
+		return DropTableStatement{}, ErrorInvaildStatement
+	}
+	dt.TableName = tableName.Value
+	tk.PopToken()
+	if !atEnd(&tk) {
+		return DropTableStatement{}, ErrorInvaildStatement
+	}
+	return dt, nil
+}
+
+func parseDeleteCommand(tk tokenizer.Tokenizer) (DeleteStatement, error) {
+	var cv DeleteStatement
+	from, err := tk.PeekToken()
+	if err != nil || from.TokenType != tokenizer.TokenKeyword || from.Value != "from" {
+		return DeleteStatement{}, ErrorInvaildStatement
+	}
+	tk.PopToken()
+	tableName, err := tk.PeekToken()
+	if err != nil || tableName.TokenType != tokenizer.TokenIdentifier {
+		return DeleteStatement{}, ErrorInvaildStatement
+	}
+	cv.TableName = tableName.Value
+	tk.PopToken()
+	where, err := parseWhere(&tk)
+	if err != nil {
+		return DeleteStatement{}, err
+	}
+	cv.Where = where
+	return cv, nil
+}
+
+// parseBeginCommand parse 'BEGIN [DEFERRED | IMMEDIATE | EXCLUSIVE] [TRANSACTION]'.
+func parseBeginCommand(tk tokenizer.Tokenizer) (BeginStatement, error) {
+	var bs BeginStatement
+	mode, err := tk.PeekToken()
+	if err == nil && mode.TokenType == tokenizer.TokenIdentifier {
+		switch strings.ToLower(mode.Value) {
+		case "deferred":
+			bs.Mode = TransactionDeferred
+			tk.PopToken()
+		case "immediate":
+			bs.Mode = TransactionImmediate
+			tk.PopToken()
+		case "exclusive":
+			bs.Mode = TransactionExclusive
+			tk.PopToken()
+		}
+	}
+	skipKeyword(&tk, "transaction")
+	if !atEnd(&tk) {
+		return BeginStatement{}, ErrorInvaildStatement
+	}
+	return bs, nil
+}
+
+// parseCommitCommand parse 'COMMIT [TRANSACTION]' and 'END [TRANSACTION]'.
+func parseCommitCommand(tk tokenizer.Tokenizer) (CommitStatement, error) {
+	skipKeyword(&tk, "transaction")
+	if !atEnd(&tk) {
+		return CommitStatement{}, ErrorInvaildStatement
+	}
+	return CommitStatement{}, nil
+}
+
+// parseRollbackCommand parse 'ROLLBACK [TRANSACTION] [TO [SAVEPOINT] name]'.
+func parseRollbackCommand(tk tokenizer.Tokenizer) (RollbackStatement, error) {
+	var rs RollbackStatement
+	skipKeyword(&tk, "transaction")
+	if skipKeyword(&tk, "to") {
+		skipKeyword(&tk, "savepoint")
+		name, err := tk.PeekToken()
+		if err != nil || name.TokenType != tokenizer.TokenIdentifier {
+			return RollbackStatement{}, ErrorInvaildStatement
+		}
+		tk.PopToken()
+		rs.Savepoint = name.Value
+	}
+	if !atEnd(&tk) {
+		return RollbackStatement{}, ErrorInvaildStatement
+	}
+	return rs, nil
+}
+
+// parseSavepointCommand parse 'SAVEPOINT name'.
+func parseSavepointCommand(tk tokenizer.Tokenizer) (SavepointStatement, error) {
+	name, err := tk.PeekToken()
+	if err != nil || name.TokenType != tokenizer.TokenIdentifier {
+		return SavepointStatement{}, ErrorInvaildStatement
+	}
+	tk.PopToken()
+	if !atEnd(&tk) {
+		return SavepointStatement{}, ErrorInvaildStatement
+	}
+	return SavepointStatement{name.Value}, nil
+}
+
+// parseReleaseCommand parse 'RELEASE [SAVEPOINT] name'.
+func parseReleaseCommand(tk tokenizer.Tokenizer) (ReleaseStatement, error) {
+	skipKeyword(&tk, "savepoint")
+	name, err := tk.PeekToken()
+	if err != nil || name.TokenType != tokenizer.TokenIdentifier {
+		return ReleaseStatement{}, ErrorInvaildStatement
+	}
+	tk.PopToken()
+	if !atEnd(&tk) {
+		return ReleaseStatement{}, ErrorInvaildStatement
+	}
+	return ReleaseStatement{name.Value}, nil
+}
+
+// skipKeyword pop the next token if it is the keyword, return true if it is popped.
+func skipKeyword(tk *tokenizer.Tokenizer, keyword string) bool {
+	token, err := tk.PeekToken()
+	if err != nil || !isWord(token, keyword) {
+		return false
+	}
+	tk.PopToken()
+	return true
+}
+
+// isWord return true if the token is the word. A word which is not reserved is read
+// as an identifier, so that it can also be the name of a table or a column.
+func isWord(token tokenizer.Token, word string) bool {
+	if token.TokenType != tokenizer.TokenKeyword && token.TokenType != tokenizer.TokenIdentifier {
+		return false
+	}
+	return strings.EqualFold(token.Value, word)
+}
+
+// parseResultColumn parse '*', 'table.*' or an expression with an optional alias.
+func parseResultColumn(tk *tokenizer.Tokenizer) (ResultColumn, error) {
+	token, err := tk.PeekToken()
+	if err != nil {
+		return ResultColumn{}, ErrorInvaildStatement
+	}
+	if token.TokenType == tokenizer.TokenStar {
+		tk.PopToken()
+		return ResultColumn{Star: true}, nil
+	}
+	expr, err := parseExpr(tk, precOr)
+	if err != nil {
+		return ResultColumn{}, err
+	}
+	if ref, ok := expr.(ColumnRef); ok && ref.Column == "*" {
+		return ResultColumn{Star: true, StarTable: ref.Table}, nil
+	}
+	column := ResultColumn{Expr: expr}
+	alias, err := tk.PeekToken()
+	if err != nil {
+		return column, nil
+	}
+	if alias.TokenType == tokenizer.TokenKeyword && alias.Value == "as" {
+		tk.PopToken()
+		alias, err = tk.PeekToken()
+		if err != nil || alias.TokenType != tokenizer.TokenIdentifier {
+			return ResultColumn{}, ErrorInvaildStatement
+		}
+	}
+	if alias.TokenType == tokenizer.TokenIdentifier {
+		tk.PopToken()
+		column.Alias = alias.Value
+	}
+	return column, nil
+}
+
+// parseWhere parse the optional WHERE clause at the end of a statement.
+func parseWhere(tk *tokenizer.Tokenizer) (Expr, error) {
+	expr, err := parseWhereClause(tk)
+	if err != nil {
+		return nil, err
+	}
+	if !atEnd(tk) {
+		return nil, ErrorInvaildStatement
+	}
+	return expr, nil
+}
+
+// parseWhereClause parse the WHERE clause if the next token is WHERE.
+func parseWhereClause(tk *tokenizer.Tokenizer) (Expr, error) {
+	where, err := tk.PeekToken()
+	if err != nil || where.TokenType != tokenizer.TokenKeyword || where.Value != "where" {
+		return nil, nil
+	}
+	tk.PopToken()
+	return parseExpr(tk, precOr)
+}
+
+// parseOrderBy parse the ORDER BY clause if the next token is ORDER.
+func parseOrderBy(tk *tokenizer.Tokenizer) ([]OrderingTerm, error) {
+	order, err := tk.PeekToken()
+	if err != nil || !isWord(order, "order") {
+		return nil, nil
+	}
+	tk.PopToken()
+	by, err := tk.PeekToken()
+	if err != nil || !isWord(by, "by") {
+		return nil, ErrorInvaildStatement
+	}
+	tk.PopToken()
+	var terms []OrderingTerm
+	for {
+		expr, err := parseExpr(tk, precOr)
+		if err != nil {
+			return nil, err
+		}
+		term := OrderingTerm{Expr: expr}
+		token, err := tk.PeekToken()
+		if err == nil && (isWord(token, "asc") || isWord(token, "desc")) {
+			tk.PopToken()
+			term.Desc = isWord(token, "desc")
+			token, err = tk.PeekToken()
+		}
+		terms = append(terms, term)
+		if err != nil || token.TokenType != tokenizer.TokenComma {
+			return terms, nil
+		}
+		tk.PopToken()
+	}
+}
@@ -0,0 +1,169 @@
+package parser
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestParseCreateTable(t *testing.T) {
+	stmt, err := Parse("CREATE TABLE users (id INTEGER, name varchar(32))")
+	assert.Nil(t, err)
+	ct := stmt.(CreateTableStatement)
+	assert.Equal(t, "users", ct.TableName)
+	assert.Equal(t, []string{"id", "name"}, ct.FieldName)
+	assert.Equal(t, []ColumnType{NewColumnType(VarTypeInteger, 0), NewColumnType(VarTypeVarchar, 32)}, ct.FiledType)
+	_, err = Parse("create table users (name varchar(32)")
+	assert.NotNil(t, err)
+}
+
+func TestParseInsert(t *testing.T) {
+	stmt, err := Parse("insert into users values (-7, 'x', null)")
+	assert.Nil(t, err)
+	ins := stmt.(InsertStatement)
+	assert.Equal(t, "users", ins.TableName)
+	assert.Equal(t, []ColumnValue{IntegerValue(-7), VarcharValue("x"), NullValue()}, ins.Values)
+	_, err = Parse("insert into users values (-'x')")
+	assert.NotNil(t, err)
+	// the integers must fit in 32 bits
+	stmt, err = Parse("insert into users values (-2147483648, 2147483647)")
+	assert.Nil(t, err)
+	assert.Equal(t, []ColumnValue{IntegerValue(math.MinInt32), IntegerValue(math.MaxInt32)}, stmt.(InsertStatement).Values)
+	for _, sql := range []string{"insert into users values (2147483648)", "insert into users values (-2147483649)", "insert into users values (99999999999)"} {
+		_, err = Parse(sql)
+		assert.NotNil(t, err, sql)
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	stmt, err := Parse("select ?, :name from users where id = ?5 and name = :name or score > ?")
+	assert.Nil(t, err)
+	count, names := Params(stmt)
+	assert.Equal(t, 6, count)
+	assert.Equal(t, map[string]int{":name": 2}, names)
+	sel := stmt.(SelectStatement)
+	assert.Equal(t, Param{Index: 1}, sel.Columns[0].Expr)
+	assert.Equal(t, "(((id = ?5) and (name = :name)) or (score > ?6))", sel.Where.String())
+
+	stmt, err = Parse("insert into users values (?, 'x', :v)")
+	assert.Nil(t, err)
+	values := []ColumnValue{IntegerValue(3), NullValue()}
+	bound, err := BindParams(stmt, values)
+	assert.Nil(t, err)
+	params := bound.(InsertStatement).Params
+	assert.Equal(t, 2, len(params))
+	assert.Equal(t, Param{Index: 2, Name: ":v"}, params[2].(BoundParam).Param)
+	// the bound statement read the values when it run
+	values[0] = IntegerValue(4)
+	assert.Equal(t, IntegerValue(4), *params[0].(BoundParam).Value)
+	// the parsed statement is not changed by the binding
+	assert.Equal(t, Param{Index: 1}, stmt.(InsertStatement).Params[0])
+	_, err = BindParams(stmt, values[:1])
+	assert.Equal(t, ErrorParamIndex, err)
+
+	for _, sql := range []string{"select ?0", "select ?1000", "select ?x", "select :", "select :1"} {
+		_, err = Parse(sql)
+		assert.NotNil(t, err, sql)
+	}
+}
+
+func TestParseUpdateDelete(t *testing.T) {
+	stmt, err := Parse("update users set name = 'x', score = score + 1 where id = 3")
+	assert.Nil(t, err)
+	upd := stmt.(UpdateStatement)
+	assert.Equal(t, "users", upd.TableName)
+	assert.Equal(t, []string{"name", "score"}, upd.Columns)
+	assert.Equal(t, "'x'", upd.Values[0].String())
+	assert.Equal(t, "(score + 1)", upd.Values[1].String())
+	assert.Equal(t, "(id = 3)", upd.Where.String())
+
+	stmt, err = Parse("delete from users")
+	assert.Nil(t, err)
+	assert.Equal(t, DeleteStatement{TableName: "users"}, stmt)
+	stmt, err = Parse("delete from users where id in (1, 2)")
+	assert.Nil(t, err)
+	assert.Equal(t, "(id in (1, 2))", stmt.(DeleteStatement).Where.String())
+
+	for _, sql := range []string{"update users", "update users set", "update users set a 1", "update users set a = 1 b = 2", "delete users", "delete from users id = 1"} {
+		_, err = Parse(sql)
+		assert.NotNil(t, err, sql)
+	}
+}
+
+func TestParseMetaCommand(t *testing.T) {
+	stmt, err := Parse(".open /tmp/test.db")
+	assert.Nil(t, err)
+	assert.Equal(t, MetaStatement{MetaCommandOpen, []string{"/tmp/test.db"}}, stmt)
+	_, err = Parse(".unknown")
+	assert.Equal(t, ErrorInvaildStatement, err)
+}
+
+func TestParseDropTable(t *testing.T) {
+	stmt, err := Parse("drop table users")
+	assert.Nil(t, err)
+	assert.Equal(t, DropTableStatement{TableName: "users"}, stmt)
+	stmt, err = Parse("DROP TABLE IF EXISTS users")
+	assert.Nil(t, err)
+	assert.Equal(t, DropTableStatement{TableName: "users", IfExists: true}, stmt)
+	for _, sql := range []string{"drop users", "drop table", "drop table if users", "drop table users users"} {
+		_, err = Parse(sql)
+		assert.NotNil(t, err, sql)
+	}
+}
+
+func TestParseCreateIndex(t *testing.T) {
+	stmt, err := Parse("create index idx_name on users (name)")
+	assert.Nil(t, err)
+	assert.Equal(t, CreateIndexStatement{IndexName: "idx_name", TableName: "users", Columns: []string{"name"}}, stmt)
+	stmt, err = Parse("CREATE UNIQUE INDEX idx ON users(name, age)")
+	assert.Nil(t, err)
+	assert.Equal(t, CreateIndexStatement{IndexName: "idx", TableName: "users", Columns: []string{"name", "age"}, Unique: true}, stmt)
+	// the words of the indexes are not reserved
+	stmt, err = Parse("create index on on index (unique, on)")
+	assert.Nil(t, err)
+	assert.Equal(t, CreateIndexStatement{IndexName: "on", TableName: "index", Columns: []string{"unique", "on"}}, stmt)
+	stmt, err = Parse("create table index (unique integer, on integer)")
+	assert.Nil(t, err)
+	assert.Equal(t, "index", stmt.(CreateTableStatement).TableName)
+	for _, sql := range []string{"create unique table t (a integer)", "create index idx users (name)", "create index idx on users ()", "create index idx on users (name", "create index on users (name)"} {
+		_, err = Parse(sql)
+		assert.NotNil(t, err, sql)
+	}
+}
+
+func TestParseTransaction(t *testing.T) {
+	cases := map[string]interface{}{
+		"begin":                                 BeginStatement{},
+		"BEGIN DEFERRED TRANSACTION":            BeginStatement{TransactionDeferred},
+		"begin immediate":                       BeginStatement{TransactionImmediate},
+		"begin exclusive transaction":           BeginStatement{TransactionExclusive},
+		"commit":                                CommitStatement{},
+		"end transaction":                       CommitStatement{},
+		"rollback":                              RollbackStatement{},
+		"rollback transaction":                  RollbackStatement{},
+		"rollback to sp1":                       RollbackStatement{"sp1"},
+		"ROLLBACK TRANSACTION TO SAVEPOINT sp1": RollbackStatement{"sp1"},
+		"savepoint sp1":                         SavepointStatement{"sp1"},
+		"release sp1":                           ReleaseStatement{"sp1"},
+		"release savepoint sp1":                 ReleaseStatement{"sp1"},
+		"Commit Transaction":                    CommitStatement{},
+		"savepoint Begin":                       SavepointStatement{"Begin"},
+		"rollback to savepoint end":             RollbackStatement{"end"},
+	}
+	for sql, expected := range cases {
+		stmt, err := Parse(sql)
+		assert.Nil(t, err, sql)
+		assert.Equal(t, expected, stmt, sql)
+	}
+	// the words of the transactions are not reserved
+	stmt, err := Parse("create table transaction (end integer, to varchar(8))")
+	assert.Nil(t, err)
+	assert.Equal(t, "transaction", stmt.(CreateTableStatement).TableName)
+	stmt, err = Parse("select end, to from transaction where release = 1")
+	assert.Nil(t, err)
+	assert.Equal(t, "transaction", stmt.(SelectStatement).TableName)
+	for _, sql := range []string{"begin later", "commit now", "rollback to", "rollback sp1", "savepoint", "release", "savepoint a b"} {
+		_, err := Parse(sql)
+		assert.NotNil(t, err, sql)
+	}
+}
@@ -1,14 +1,44 @@
 package parser
 
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
 type MetaCommandType int
 type VarType int
 
 const (
 	MetaCommandExit MetaCommandType = iota
+	MetaCommandTables
+	MetaCommandSchema
+	MetaCommandOpen
+	MetaCommandRead
+	MetaCommandMode
+	MetaCommandHeaders
+	MetaCommandTimer
+	MetaCommandHistory
+	MetaCommandTimeout
 )
+
+var metaCommands = map[string]MetaCommandType{
+	"exit":    MetaCommandExit,
+	"tables":  MetaCommandTables,
+	"schema":  MetaCommandSchema,
+	"open":    MetaCommandOpen,
+	"read":    MetaCommandRead,
+	"mode":    MetaCommandMode,
+	"headers": MetaCommandHeaders,
+	"timer":   MetaCommandTimer,
+	"history": MetaCommandHistory,
+	"timeout": MetaCommandTimeout,
+}
+
 const (
 	VarTypeInteger VarType = iota
 	VarTypeVarchar
+	VarTypeNull // the type of the NULL value, not a column type
 )
 
 func (vty VarType) String() string {
@@ -17,6 +47,8 @@ func (vty VarType) String() string {
 		return "integer"
 	case VarTypeVarchar:
 		return "varchar"
+	case VarTypeNull:
+		return "null"
 	default:
 		return "unknow"
 	}
@@ -32,7 +64,7 @@ type ColumnValue struct {
 	value   []byte
 }
 
-type ExitMetaStatement struct {
+type MetaStatement struct {
 	CommandType MetaCommandType
 	Parameters  []string
 }
@@ -43,11 +75,157 @@ type CreateTableStatement struct {
 	FiledType []ColumnType
 }
 
+type CreateIndexStatement struct {
+	IndexName string
+	TableName string
+	Columns   []string // the indexed columns, in key order
+	Unique    bool     // true if no two rows may have the same values in the columns
+}
+
 type InsertStatement struct {
 	TableName string
 	Values    []ColumnValue
+	Params    map[int]Expr // the Param or BoundParam by position in Values, nil if there is none
+}
+
+// ResultColumn is an item of the SELECT list.
+type ResultColumn struct {
+	Expr      Expr   // the expression, nil for '*'
+	Alias     string // the name given by AS, empty if there is no alias
+	Star      bool   // true for '*' or 'table.*'
+	StarTable string // the table of 'table.*', empty for '*'
+}
+
+// OrderingTerm is an item of the ORDER BY clause.
+type OrderingTerm struct {
+	Expr Expr
+	Desc bool // true if the rows are sorted in descending order
 }
 
 type SelectStatement struct {
+	Columns   []ResultColumn
+	TableName string
+	Where     Expr           // nil if there is no WHERE clause
+	OrderBy   []OrderingTerm // empty if there is no ORDER BY clause
+}
+
+type UpdateStatement struct {
 	TableName string
+	Columns   []string // the columns to set
+	Values    []Expr   // the new value of every column in Columns
+	Where     Expr     // nil if there is no WHERE clause
+}
+
+type DropTableStatement struct {
+	TableName string
+	IfExists  bool // true if a missing table is not an error
+}
+
+// TransactionMode is the way BEGIN start a transaction.
+type TransactionMode int
+
+const (
+	TransactionDeferred  TransactionMode = iota // the write transaction start at the first write
+	TransactionImmediate                        // the write transaction start at BEGIN
+	TransactionExclusive                        // the write transaction start at BEGIN, and no one else may read
+)
+
+type BeginStatement struct {
+	Mode TransactionMode
+}
+
+// CommitStatement is COMMIT or END.
+type CommitStatement struct{}
+
+// RollbackStatement is ROLLBACK, or ROLLBACK TO a savepoint.
+type RollbackStatement struct {
+	Savepoint string // empty if the whole transaction is rolled back
+}
+
+type SavepointStatement struct {
+	Name string
+}
+
+type ReleaseStatement struct {
+	Name string
+}
+
+type DeleteStatement struct {
+	TableName string
+	Where     Expr // nil if there is no WHERE clause
+}
+
+// NewColumnType return a column type, length is the max length of a varchar column.
+func NewColumnType(varType VarType, length int) ColumnType {
+	return ColumnType{varType, length}
+}
+
+func (ct ColumnType) Type() VarType {
+	return ct.varType
+}
+
+// Len return the max length of a varchar column.
+func (ct ColumnType) Len() int {
+	return ct.len
+}
+
+func (ct ColumnType) String() string {
+	if ct.varType == VarTypeVarchar {
+		return fmt.Sprintf("%v(%d)", ct.varType, ct.len)
+	}
+	return ct.varType.String()
+}
+
+// NewColumnValue return a column value. An integer value is a 4 bytes little endian int32.
+func NewColumnValue(varType VarType, value []byte) ColumnValue {
+	return ColumnValue{varType, value}
+}
+
+func (cv ColumnValue) Type() VarType {
+	return cv.varType
+}
+
+func (cv ColumnValue) Value() []byte {
+	return cv.value
+}
+
+// IntegerValue return the column value of an integer.
+func IntegerValue(v int32) ColumnValue {
+	value := make([]byte, 4)
+	binary.LittleEndian.PutUint32(value, uint32(v))
+	return ColumnValue{VarTypeInteger, value}
+}
+
+// NullValue return the NULL value.
+func NullValue() ColumnValue {
+	return ColumnValue{VarTypeNull, nil}
+}
+
+// IsNull return true if the value is NULL.
+func (cv ColumnValue) IsNull() bool {
+	return cv.varType == VarTypeNull
+}
+
+// VarcharValue return the column value of a string.
+func VarcharValue(s string) ColumnValue {
+	return ColumnValue{VarTypeVarchar, []byte(s)}
+}
+
+// Int return the value of an integer column.
+func (cv ColumnValue) Int() int32 {
+	if cv.varType != VarTypeInteger || len(cv.value) < 4 {
+		return 0
+	}
+	return int32(binary.LittleEndian.Uint32(cv.value))
+}
+
+func (cv ColumnValue) String() string {
+	switch cv.varType {
+	case VarTypeInteger:
+		return strconv.Itoa(int(cv.Int()))
+	case VarTypeNull:
+		return "NULL"
+	default:
+		return string(cv.value)
+	}
 }
@@ -0,0 +1,62 @@
+package record
+
+import (
+	"encoding/binary"
+	"godb/internal/parser"
+)
+
+// An index key is the encoded column values followed by the rowid. The encoding
+// preserve the order of the values, so that two keys are compared byte by byte:
+//
+//	NULL     0x00
+//	integer  0x01, the int32 with the sign bit flipped in 4 bytes big endian
+//	varchar  0x02, the bytes of the string with 0x00 escaped as 0x00 0xff, then 0x00 0x00
+//
+// NULL sort before any integer, and integers sort before any varchar. The rowid is
+// appended in 4 bytes big endian.
+
+// The tags of the values in an index key.
+const (
+	keyTagNull    byte = 0x00
+	keyTagInteger byte = 0x01
+	keyTagVarchar byte = 0x02
+)
+
+// EncodeKey encode the values into an order preserving key without the rowid. The
+// key is a prefix of the IndexKey of every row with the same values.
+func EncodeKey(values []parser.ColumnValue) []byte {
+	var key []byte
+	for _, value := range values {
+		switch {
+		case value.IsNull():
+			key = append(key, keyTagNull)
+		case value.Type() == parser.VarTypeInteger:
+			key = append(key, keyTagInteger)
+			key = binary.BigEndian.AppendUint32(key, uint32(value.Int())^0x80000000)
+		default:
+			key = append(key, keyTagVarchar)
+			for _, b := range value.Value() {
+				if b == 0x00 {
+					key = append(key, 0x00, 0xff)
+				} else {
+					key = append(key, b)
+				}
+			}
+			key = append(key, 0x00, 0x00)
+		}
+	}
+	return key
+}
+
+// IndexKey return the key of a row in an index, the values are the indexed columns of the row.
+func IndexKey(values []parser.ColumnValue, rowid uint32) []byte {
+	return binary.BigEndian.AppendUint32(EncodeKey(values), rowid)
+}
+
+// EncodeKeyAfter return a key bigger than every key that start with EncodeKey(values),
+// but smaller than the keys of any bigger values. It bound a range of an index from
+// above, or from below to leave out the values themselves.
+func EncodeKeyAfter(values []parser.ColumnValue) []byte {
+	// a tag or a rowid follow the encoded values, 0xff is bigger than all of them
+	return append(EncodeKey(values), 0xff, 0xff, 0xff, 0xff, 0xff)
+}
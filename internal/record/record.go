@@ -0,0 +1,153 @@
+package record
+
+import (
+	"errors"
+	"godb/internal/parser"
+	"godb/internal/utils"
+)
+
+// A record is the payload of a table row. The header describe every column,
+// the column bodies follow the header in the same order.
+//
+// Record layout:
+//
+// OFFSET	SIZE	DATA
+//    0       2     number of columns N
+//    2      5*N    type code (1 byte) and body length (4 bytes) of every column
+//  2+5*N     -     column bodies
+//
+// An integer body is a 4 bytes little endian int32, a varchar body is the raw bytes of the string.
+// A NULL value has no body, any column may hold NULL.
+
+// The type codes of the columns stored in the record header.
+const (
+	TypeCodeNull    uint8 = 0
+	TypeCodeInteger uint8 = 1
+	TypeCodeVarchar uint8 = 2
+)
+
+const recordHeaderSize = 2
+const columnHeaderSize = 5
+const integerSize = 4
+
+var (
+	ErrorColumnCount   = errors.New("number of values does not match number of columns")
+	ErrorTypeMismatch  = errors.New("value type does not match column type")
+	ErrorValueTooLong  = errors.New("value too long for varchar column")
+	ErrorCorruptRecord = errors.New("malformed record")
+)
+
+func typeCode(varType parser.VarType) (uint8, error) {
+	switch varType {
+	case parser.VarTypeInteger:
+		return TypeCodeInteger, nil
+	case parser.VarTypeVarchar:
+		return TypeCodeVarchar, nil
+	case parser.VarTypeNull:
+		return TypeCodeNull, nil
+	default:
+		return 0, ErrorTypeMismatch
+	}
+}
+
+// Validate check that the values match the column types of the table.
+func Validate(types []parser.ColumnType, values []parser.ColumnValue) error {
+	if len(types) != len(values) {
+		return ErrorColumnCount
+	}
+	for i, value := range values {
+		if value.IsNull() {
+			continue
+		}
+		if value.Type() != types[i].Type() {
+			return ErrorTypeMismatch
+		}
+		switch value.Type() {
+		case parser.VarTypeInteger:
+			if len(value.Value()) != integerSize {
+				return ErrorTypeMismatch
+			}
+		case parser.VarTypeVarchar:
+			if len(value.Value()) > types[i].Len() {
+				return ErrorValueTooLong
+			}
+		}
+	}
+	return nil
+}
+
+// Encode validate the values against the column types and serialize them into a record.
+func Encode(types []parser.ColumnType, values []parser.ColumnValue) ([]byte, error) {
+	if err := Validate(types, values); err != nil {
+		return nil, err
+	}
+	size := recordHeaderSize + columnHeaderSize*len(values)
+	for _, value := range values {
+		size += len(value.Value())
+	}
+	raw := make([]byte, size)
+	utils.SetUint16(raw, uint16(len(values)))
+	hdr := raw[recordHeaderSize:]
+	body := raw[recordHeaderSize+columnHeaderSize*len(values):]
+	for i, value := range values {
+		code, err := typeCode(value.Type())
+		if err != nil {
+			return nil, err
+		}
+		hdr[columnHeaderSize*i] = code
+		utils.SetUint32(hdr[columnHeaderSize*i+1:], uint32(len(value.Value())))
+		body = body[copy(body, value.Value()):]
+	}
+	return raw, nil
+}
+
+// Decode deserialize a record into column values and check them against the column types.
+func Decode(types []parser.ColumnType, raw []byte) ([]parser.ColumnValue, error) {
+	if len(raw) < recordHeaderSize {
+		return nil, ErrorCorruptRecord
+	}
+	n := int(utils.GetUint16(raw))
+	if n != len(types) {
+		return nil, ErrorColumnCount
+	}
+	if len(raw) < recordHeaderSize+columnHeaderSize*n {
+		return nil, ErrorCorruptRecord
+	}
+	hdr := raw[recordHeaderSize:]
+	body := raw[recordHeaderSize+columnHeaderSize*n:]
+	values := make([]parser.ColumnValue, n)
+	for i := range values {
+		code := hdr[columnHeaderSize*i]
+		length := utils.GetUint32(hdr[columnHeaderSize*i+1:])
+		if uint32(len(body)) < length {
+			return nil, ErrorCorruptRecord
+		}
+		var varType parser.VarType
+		switch code {
+		case TypeCodeNull:
+			if length != 0 {
+				return nil, ErrorCorruptRecord
+			}
+			values[i] = parser.NullValue()
+			continue
+		case TypeCodeInteger:
+			if length != integerSize {
+				return nil, ErrorCorruptRecord
+			}
+			varType = parser.VarTypeInteger
+		case TypeCodeVarchar:
+			varType = parser.VarTypeVarchar
+		default:
+			return nil, ErrorCorruptRecord
+		}
+		if varType != types[i].Type() {
+			return nil, ErrorTypeMismatch
+		}
+		values[i] = parser.NewColumnValue(varType, append([]byte{}, body[:length]...))
+		body = body[length:]
+	}
+	if len(body) != 0 {
+		return nil, ErrorCorruptRecord
+	}
+	return values, nil
+}
@@ -0,0 +1,97 @@
+package record
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"godb/internal/parser"
+	"math"
+	"testing"
+)
+
+var testTypes = []parser.ColumnType{
+	parser.NewColumnType(parser.VarTypeInteger, 0),
+	parser.NewColumnType(parser.VarTypeVarchar, 8),
+	parser.NewColumnType(parser.VarTypeVarchar, 8),
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	values := []parser.ColumnValue{
+		parser.IntegerValue(-42),
+		parser.VarcharValue("godb"),
+		parser.VarcharValue(""),
+	}
+	raw, err := Encode(testTypes, values)
+	assert.Nil(t, err)
+	assert.Equal(t, recordHeaderSize+3*columnHeaderSize+4+4, len(raw))
+	decoded, err := Decode(testTypes, raw)
+	assert.Nil(t, err)
+	assert.Equal(t, values, decoded)
+	assert.Equal(t, int32(-42), decoded[0].Int())
+	assert.Equal(t, "godb", decoded[1].String())
+
+	// the values are parsed from an insert statement
+	stmt, err := parser.Parse("insert into t values (7, 'abc', 'defghijk')")
+	assert.Nil(t, err)
+	raw, err = Encode(testTypes, stmt.(parser.InsertStatement).Values)
+	assert.Nil(t, err)
+	decoded, err = Decode(testTypes, raw)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(7), decoded[0].Int())
+	assert.Equal(t, "defghijk", decoded[2].String())
+}
+
+func TestRecordValidate(t *testing.T) {
+	_, err := Encode(testTypes, []parser.ColumnValue{parser.IntegerValue(1)})
+	assert.Equal(t, ErrorColumnCount, err)
+	_, err = Encode(testTypes, []parser.ColumnValue{
+		parser.VarcharValue("1"), parser.VarcharValue("a"), parser.VarcharValue("b"),
+	})
+	assert.Equal(t, ErrorTypeMismatch, err)
+	_, err = Encode(testTypes, []parser.ColumnValue{
+		parser.IntegerValue(1), parser.VarcharValue("a"), parser.VarcharValue("123456789"),
+	})
+	assert.Equal(t, ErrorValueTooLong, err)
+}
+
+func TestDecodeCorruptRecord(t *testing.T) {
+	raw, err := Encode(testTypes, []parser.ColumnValue{
+		parser.IntegerValue(1), parser.VarcharValue("a"), parser.VarcharValue("b"),
+	})
+	assert.Nil(t, err)
+	_, err = Decode(testTypes, raw[:len(raw)-1])
+	assert.Equal(t, ErrorCorruptRecord, err)
+	_, err = Decode(testTypes[:2], raw)
+	assert.Equal(t, ErrorColumnCount, err)
+	raw[recordHeaderSize] = 9
+	_, err = Decode(testTypes, raw)
+	assert.Equal(t, ErrorCorruptRecord, err)
+}
+
+func TestEncodeKeyOrder(t *testing.T) {
+	// the values are listed in ascending order
+	values := []parser.ColumnValue{
+		parser.NullValue(),
+		parser.IntegerValue(math.MinInt32),
+		parser.IntegerValue(-1),
+		parser.IntegerValue(0),
+		parser.IntegerValue(255),
+		parser.IntegerValue(math.MaxInt32),
+		parser.VarcharValue(""),
+		parser.VarcharValue("a"),
+		parser.VarcharValue("a\x00"),
+		parser.VarcharValue("a\x00b"),
+		parser.VarcharValue("ab"),
+		parser.VarcharValue("b"),
+	}
+	for i := 1; i < len(values); i++ {
+		prev := EncodeKey([]parser.ColumnValue{values[i-1], parser.IntegerValue(9)})
+		next := EncodeKey([]parser.ColumnValue{values[i], parser.IntegerValue(1)})
+		assert.True(t, bytes.Compare(prev, next) < 0, values[i].String())
+	}
+	// the key of a row start with the key of its values
+	prefix := EncodeKey(values[7:9])
+	key := IndexKey(values[7:9], 42)
+	assert.True(t, bytes.HasPrefix(key, prefix))
+	assert.Equal(t, []byte{0, 0, 0, 42}, key[len(prefix):])
+	assert.True(t, bytes.Compare(IndexKey(values[7:8], 1), IndexKey(values[7:8], 256)) < 0)
+}
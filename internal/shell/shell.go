@@ -0,0 +1,428 @@
+package shell
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"godb/internal/catalog"
+	"godb/internal/executor"
+	"godb/internal/parser"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	prompt         = "db > "
+	continuePrompt = "   ...> "
+)
+
+// maxReadDepth is the max nesting of .read commands.
+const maxReadDepth = 16
+
+// The output modes of the query results.
+const (
+	ModeList   = "list"   // values separated by '|'
+	ModeColumn = "column" // values aligned in columns
+	ModeLine   = "line"   // one value per line
+)
+
+var (
+	ErrorUnknownCommand = errors.New("unknown command or invalid arguments")
+	ErrorReadTooDeep    = errors.New(".read nested too deeply")
+)
+
+// Shell is the interactive shell. Statements may span several lines and end with ';',
+// a meta command start with '.' and take a single line.
+type Shell struct {
+	Exec        *executor.Executor // the database the statements run against
+	FileName    string             // name of the database file, empty for an in-memory database
+	Out         io.Writer          // where the results and errors are printed
+	Mode        string             // output mode of the query results
+	Headers     bool               // true if the column names are printed
+	Timer       bool               // true if the run time of every statement is printed
+	History     []string           // the statements and meta commands entered
+	HistoryFile string             // file the history is appended to, empty for no history file
+	BusyTimeout time.Duration      // how long a statement wait for the locks of other processes
+
+	buf       strings.Builder // the statement not terminated by ';' yet
+	readDepth int             // nesting of the .read commands
+}
+
+// New create a shell on the database.
+func New(exec *executor.Executor, fileName string, out io.Writer) *Shell {
+	return &Shell{Exec: exec, FileName: fileName, Out: out, Mode: ModeList}
+}
+
+// LoadHistory read the history file, a missing file is not an error.
+func (sh *Shell) LoadHistory(fileName string) error {
+	sh.HistoryFile = fileName
+	raw, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		if line != "" {
+			sh.History = append(sh.History, line)
+		}
+	}
+	return nil
+}
+
+// Run read the input line by line until .exit or the end of input. If interactive
+// is true, a prompt is printed before every line. The lines have no length limit.
+func (sh *Shell) Run(in io.Reader, interactive bool) {
+	reader := bufio.NewReader(in)
+	for {
+		if interactive {
+			if sh.buf.Len() == 0 {
+				fmt.Fprint(sh.Out, prompt)
+			} else {
+				fmt.Fprint(sh.Out, continuePrompt)
+			}
+		}
+		line, err := reader.ReadString('\n')
+		// the last line may have no '\n'
+		if line != "" && sh.ProcessLine(strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")) {
+			return
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			sh.printError(err)
+			break
+		}
+	}
+	// a statement without ';' at the end of input is still run
+	if rest := strings.TrimSpace(sh.buf.String()); rest != "" {
+		sh.buf.Reset()
+		sh.runStatement(rest)
+	}
+}
+
+// ProcessLine handle one line of input. return true if the shell should exit.
+func (sh *Shell) ProcessLine(line string) bool {
+	if sh.buf.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), ".") {
+		sh.addHistory(strings.TrimSpace(line))
+		return sh.runMetaCommand(strings.TrimSpace(line))
+	}
+	sh.buf.WriteString(line)
+	sh.buf.WriteString("\n")
+	statements, rest := splitStatements(sh.buf.String())
+	sh.buf.Reset()
+	sh.buf.WriteString(rest)
+	for _, stmt := range statements {
+		sh.addHistory(stmt + ";")
+		sh.runStatement(stmt)
+	}
+	return false
+}
+
+// splitStatements split the input at every ';' outside a quoted string.
+// return the complete statements and the text after the last ';'.
+func splitStatements(input string) ([]string, string) {
+	var statements []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(input); i++ {
+		b := input[i]
+		switch {
+		case quote != 0:
+			if b == quote {
+				quote = 0
+			}
+		case b == '\'':
+			quote = b
+		case b == ';':
+			if stmt := strings.TrimSpace(input[start:i]); stmt != "" {
+				statements = append(statements, stmt)
+			}
+			start = i + 1
+		}
+	}
+	rest := input[start:]
+	if strings.TrimSpace(rest) == "" {
+		rest = ""
+	}
+	return statements, rest
+}
+
+func (sh *Shell) addHistory(line string) {
+	sh.History = append(sh.History, line)
+	if sh.HistoryFile == "" || sh.readDepth > 0 {
+		return
+	}
+	f, err := os.OpenFile(sh.HistoryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, strings.ReplaceAll(line, "\n", " "))
+}
+
+func (sh *Shell) printError(err error) {
+	fmt.Fprintf(sh.Out, "Error: %v\n", err)
+}
+
+// runStatement run a statement and print its result.
+func (sh *Shell) runStatement(sql string) {
+	start := time.Now()
+	result, err := sh.execute(sql)
+	if err != nil {
+		sh.printError(err)
+	} else {
+		sh.printResult(result)
+	}
+	if sh.Timer {
+		fmt.Fprintf(sh.Out, "Run Time: real %.6f\n", time.Since(start).Seconds())
+	}
+}
+
+// execute run the statement, a panic is turned into an error and the changes are rolled back.
+func (sh *Shell) execute(sql string) (result *executor.Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("internal error: %v", r)
+			sh.Exec.Rollback()
+		}
+	}()
+	return sh.Exec.Execute(sql)
+}
+
+func (sh *Shell) printResult(result *executor.Result) {
+	if len(result.Columns) == 0 {
+		return
+	}
+	rows := make([][]string, len(result.Rows))
+	for i, row := range result.Rows {
+		rows[i] = make([]string, len(row))
+		for j, value := range row {
+			rows[i][j] = value.String()
+		}
+	}
+	switch sh.Mode {
+	case ModeColumn:
+		sh.printColumns(result.Columns, rows)
+	case ModeLine:
+		sh.printLines(result.Columns, rows)
+	default:
+		if sh.Headers {
+			fmt.Fprintln(sh.Out, strings.Join(result.Columns, "|"))
+		}
+		for _, row := range rows {
+			fmt.Fprintln(sh.Out, strings.Join(row, "|"))
+		}
+	}
+}
+
+func (sh *Shell) printColumns(columns []string, rows [][]string) {
+	widths := make([]int, len(columns))
+	for i, column := range columns {
+		if sh.Headers {
+			widths[i] = len(column)
+		}
+		for _, row := range rows {
+			if i < len(row) && len(row[i]) > widths[i] {
+				widths[i] = len(row[i])
+			}
+		}
+	}
+	printRow := func(values []string) {
+		cells := make([]string, len(values))
+		for i, value := range values {
+			cells[i] = fmt.Sprintf("%-*s", widths[i], value)
+		}
+		fmt.Fprintln(sh.Out, strings.TrimRight(strings.Join(cells, "  "), " "))
+	}
+	if sh.Headers {
+		printRow(columns)
+		dashes := make([]string, len(columns))
+		for i := range columns {
+			dashes[i] = strings.Repeat("-", widths[i])
+		}
+		printRow(dashes)
+	}
+	for _, row := range rows {
+		printRow(row)
+	}
+}
+
+func (sh *Shell) printLines(columns []string, rows [][]string) {
+	width := 0
+	for _, column := range columns {
+		if len(column) > width {
+			width = len(column)
+		}
+	}
+	for i, row := range rows {
+		if i > 0 {
+			fmt.Fprintln(sh.Out)
+		}
+		for j, value := range row {
+			fmt.Fprintf(sh.Out, "%*s = %s\n", width, columns[j], value)
+		}
+	}
+}
+
+// runMetaCommand run a meta command. return true if the shell should exit.
+func (sh *Shell) runMetaCommand(line string) bool {
+	stmt, err := parser.Parse(line)
+	if err != nil {
+		sh.printError(ErrorUnknownCommand)
+		return false
+	}
+	meta := stmt.(parser.MetaStatement)
+	if meta.CommandType == parser.MetaCommandExit {
+		return true
+	}
+	if err := sh.metaCommand(meta); err != nil {
+		sh.printError(err)
+	}
+	return false
+}
+
+func (sh *Shell) metaCommand(meta parser.MetaStatement) error {
+	args := meta.Parameters
+	switch meta.CommandType {
+	case parser.MetaCommandTables:
+		tables, err := sh.Exec.ListTables()
+		if err != nil {
+			return err
+		}
+		names := make([]string, len(tables))
+		for i, table := range tables {
+			names[i] = table.Name
+		}
+		if len(names) > 0 {
+			fmt.Fprintln(sh.Out, strings.Join(names, "  "))
+		}
+	case parser.MetaCommandSchema:
+		if len(args) > 1 {
+			return ErrorUnknownCommand
+		}
+		if len(args) == 1 {
+			table, err := sh.Exec.GetTable(args[0])
+			if err != nil {
+				return err
+			}
+			sh.printSchema(table)
+			return nil
+		}
+		tables, err := sh.Exec.ListTables()
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			sh.printSchema(table)
+		}
+	case parser.MetaCommandOpen:
+		if len(args) > 1 {
+			return ErrorUnknownCommand
+		}
+		fileName := ""
+		if len(args) == 1 {
+			fileName = args[0]
+		}
+		exec, err := executor.Open(fileName)
+		if err != nil {
+			return err
+		}
+		exec.SetBusyTimeout(sh.BusyTimeout)
+		old := sh.Exec
+		sh.Exec, sh.FileName = exec, fileName
+		return old.Close()
+	case parser.MetaCommandRead:
+		if len(args) != 1 {
+			return ErrorUnknownCommand
+		}
+		return sh.readScript(args[0])
+	case parser.MetaCommandMode:
+		if len(args) == 0 {
+			fmt.Fprintf(sh.Out, "current output mode: %s\n", sh.Mode)
+			return nil
+		}
+		switch strings.ToLower(args[0]) {
+		case ModeList, ModeColumn, ModeLine:
+			sh.Mode = strings.ToLower(args[0])
+		default:
+			return ErrorUnknownCommand
+		}
+	case parser.MetaCommandHeaders:
+		on, err := parseSwitch(args)
+		if err != nil {
+			return err
+		}
+		sh.Headers = on
+	case parser.MetaCommandTimer:
+		on, err := parseSwitch(args)
+		if err != nil {
+			return err
+		}
+		sh.Timer = on
+	case parser.MetaCommandHistory:
+		for i, line := range sh.History {
+			fmt.Fprintf(sh.Out, "%5d  %s\n", i+1, line)
+		}
+	case parser.MetaCommandTimeout:
+		// the busy timeout in milliseconds
+		if len(args) != 1 {
+			return ErrorUnknownCommand
+		}
+		ms, err := strconv.Atoi(args[0])
+		if err != nil || ms < 0 {
+			return ErrorUnknownCommand
+		}
+		sh.BusyTimeout = time.Duration(ms) * time.Millisecond
+		sh.Exec.SetBusyTimeout(sh.BusyTimeout)
+	default:
+		return ErrorUnknownCommand
+	}
+	return nil
+}
+
+// printSchema print the statement that create the table, followed by its indexes.
+func (sh *Shell) printSchema(table *catalog.Table) {
+	fmt.Fprintln(sh.Out, table.SQL+";")
+	for _, index := range table.Indexes {
+		fmt.Fprintln(sh.Out, index.SQL+";")
+	}
+}
+
+func parseSwitch(args []string) (bool, error) {
+	if len(args) != 1 {
+		return false, ErrorUnknownCommand
+	}
+	switch strings.ToLower(args[0]) {
+	case "on", "yes", "1":
+		return true, nil
+	case "off", "no", "0":
+		return false, nil
+	default:
+		return false, ErrorUnknownCommand
+	}
+}
+
+// readScript run the statements and meta commands in the file.
+func (sh *Shell) readScript(fileName string) error {
+	if sh.readDepth >= maxReadDepth {
+		return ErrorReadTooDeep
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// the statement being typed is kept aside while the script runs
+	pending := sh.buf.String()
+	sh.buf.Reset()
+	sh.readDepth++
+	sh.Run(f, false)
+	sh.readDepth--
+	sh.buf.Reset()
+	sh.buf.WriteString(pending)
+	return nil
+}
@@ -0,0 +1,104 @@
+package shell
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"godb/internal/executor"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newShell(t *testing.T) (*Shell, *bytes.Buffer) {
+	exec, err := executor.Open("")
+	assert.Nil(t, err)
+	out := new(bytes.Buffer)
+	sh := New(exec, "", out)
+	t.Cleanup(func() { sh.Exec.Close() })
+	return sh, out
+}
+
+func TestSplitStatements(t *testing.T) {
+	statements, rest := splitStatements("select * from t; insert into t values ('a;b');\nselect")
+	assert.Equal(t, []string{"select * from t", "insert into t values ('a;b')"}, statements)
+	assert.Equal(t, "\nselect", rest)
+	statements, rest = splitStatements("insert into t values ('a;\n")
+	assert.Empty(t, statements)
+	assert.Equal(t, "insert into t values ('a;\n", rest)
+}
+
+func TestShellRun(t *testing.T) {
+	sh, out := newShell(t)
+	input := strings.Join([]string{
+		"create table users (",
+		"  id integer, name varchar(8)",
+		");",
+		"insert into users values (1, 'alice'); insert into users values (2, 'bob');",
+		"select * from users;",
+		".headers on",
+		".mode column",
+		"select * from users;",
+		".mode line",
+		"select * from users;",
+		".tables",
+		".schema users",
+		"select * from missing;",
+		".nothing",
+		".timeout 250",
+		".timeout soon",
+		".exit",
+		"select * from users;",
+	}, "\n")
+	sh.Run(strings.NewReader(input), false)
+	expected := strings.Join([]string{
+		"1|alice",
+		"2|bob",
+		"id  name",
+		"--  -----",
+		"1   alice",
+		"2   bob",
+		"  id = 1",
+		"name = alice",
+		"",
+		"  id = 2",
+		"name = bob",
+		"users",
+		"create table users (\n  id integer, name varchar(8)\n);",
+		"Error: no such table",
+		"Error: unknown command or invalid arguments",
+		"Error: unknown command or invalid arguments",
+		"",
+	}, "\n")
+	assert.Equal(t, expected, out.String())
+	assert.Equal(t, ".exit", sh.History[len(sh.History)-1])
+	assert.Equal(t, 250*time.Millisecond, sh.BusyTimeout)
+}
+
+func TestShellLongLine(t *testing.T) {
+	sh, out := newShell(t)
+	// the lines are longer than the 64KB of a bufio.Scanner
+	ids := strings.Repeat("1, ", 40000)
+	input := strings.Join([]string{
+		"create table users (id integer, name varchar(8));",
+		"insert into users values (1, 'alice');",
+		"select name from users where id in (" + ids + "2);",
+		"insert into users values (2, '" + strings.Repeat("a", 100000) + "');",
+		"select count from users where id in (" + ids + "2);",
+		"select id from users;",
+	}, "\r\n")
+	sh.Run(strings.NewReader(input), false)
+	assert.Equal(t, "alice\nError: value too long for varchar column\nError: no such column\n1\n", out.String())
+}
+
+func TestShellReadAndOpen(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.sql")
+	assert.Nil(t, os.WriteFile(script, []byte("create table t (id integer);\ninsert into t values (7);\n"), 0644))
+	fileName := filepath.Join(dir, "test.db")
+	sh, out := newShell(t)
+	sh.Run(strings.NewReader(".open "+fileName+"\n.read "+script+"\n.open\n.tables\n.open "+fileName+"\nselect * from t;\n"), false)
+	assert.Equal(t, "7\n", out.String())
+	assert.Equal(t, fileName, sh.FileName)
+}
@@ -17,10 +17,21 @@ var keywordMap = map[string]bool{
 	"integer": true,
 	"varchar": true,
 	"drop":    true,
+	"and":     true,
+	"or":      true,
+	"not":     true,
+	"is":      true,
+	"null":    true,
+	"in":      true,
+	"between": true,
+	"like":    true,
+	"as":      true,
+	"if":      true,
+	"exists":  true,
 }
 
 func isBlank(b byte) bool {
-	return b == ' ' || b == '\n' || b == '\f'
+	return b == ' ' || b == '\n' || b == '\f' || b == '\t' || b == '\r'
 }
 
 func isAlphaBeta(b byte) bool {
@@ -1,6 +1,7 @@
 package tokenizer
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 )
@@ -9,7 +10,7 @@ type tokenType int
 
 var (
 	errorInvaildState = errors.New("invaild state")
-	errorEndofFile    = errors.New("eof")
+	ErrorEndofFile    = errors.New("eof")
 )
 
 const (
@@ -18,12 +19,23 @@ const (
 	TokenIdentifier
 	TokenDigit
 	TokenString
-	TokenEq    // =
-	TokenLP    // (
-	TokenRP    // )
-	TokenComma // ,
-	TokenStar  // *
-	TokenNull  // special token when a error occured or no more str to tokenize
+	TokenEq      // =
+	TokenLP      // (
+	TokenRP      // )
+	TokenComma   // ,
+	TokenStar    // *
+	TokenNe      // <> or !=
+	TokenLt      // <
+	TokenLe      // <=
+	TokenGt      // >
+	TokenGe      // >=
+	TokenPlus    // +
+	TokenMinus   // -
+	TokenSlash   // /
+	TokenPercent // %
+	TokenDot     // .
+	TokenParam   // ?, ?NNN or :name
+	TokenNull    // special token when a error occured or no more str to tokenize
 )
 
 func (t tokenType) String() string {
@@ -48,6 +60,28 @@ func (t tokenType) String() string {
 		return "comma"
 	case TokenStar:
 		return "star"
+	case TokenNe:
+		return "notEqual"
+	case TokenLt:
+		return "less"
+	case TokenLe:
+		return "lessEqual"
+	case TokenGt:
+		return "greater"
+	case TokenGe:
+		return "greaterEqual"
+	case TokenPlus:
+		return "plus"
+	case TokenMinus:
+		return "minus"
+	case TokenSlash:
+		return "slash"
+	case TokenPercent:
+		return "percent"
+	case TokenDot:
+		return "dot"
+	case TokenParam:
+		return "parameter"
 	case TokenNull:
 		return "nullString"
 	default:
@@ -85,6 +119,16 @@ func (tk *Tokenizer) peekByte() (byte, bool) {
 	return tk.str[tk.pos], false
 }
 
+// atStart return true if there is only blanks before the current byte.
+func (tk *Tokenizer) atStart() bool {
+	for _, b := range tk.str[:tk.pos-1] {
+		if !isBlank(b) {
+			return false
+		}
+	}
+	return true
+}
+
 func (tk *Tokenizer) popByte() {
 	if tk.pos < len(tk.str) {
 		tk.pos++
@@ -123,7 +167,7 @@ func (tk *Tokenizer) nextMetaState() (Token, error) {
 	for {
 		b, eof := tk.peekByte()
 		if eof {
-			return Token{TokenNull, ""}, errorEndofFile
+			return Token{TokenNull, ""}, ErrorEndofFile
 		}
 		if !isBlank(b) {
 			break
@@ -134,7 +178,11 @@ func (tk *Tokenizer) nextMetaState() (Token, error) {
 	switch b {
 	case '.':
 		tk.popByte()
-		return tk.nextMetaCommandState()
+		// only the first token of the input can be a meta command
+		if tk.atStart() {
+			return tk.nextMetaCommandState()
+		}
+		return Token{TokenDot, "."}, nil
 	case '=':
 		tk.popByte()
 		return Token{TokenEq, "="}, nil
@@ -153,6 +201,45 @@ func (tk *Tokenizer) nextMetaState() (Token, error) {
 	case '*':
 		tk.popByte()
 		return Token{TokenStar, "*"}, nil
+	case '<':
+		tk.popByte()
+		if next, _ := tk.peekByte(); next == '>' {
+			tk.popByte()
+			return Token{TokenNe, "<>"}, nil
+		} else if next == '=' {
+			tk.popByte()
+			return Token{TokenLe, "<="}, nil
+		}
+		return Token{TokenLt, "<"}, nil
+	case '>':
+		tk.popByte()
+		if next, _ := tk.peekByte(); next == '=' {
+			tk.popByte()
+			return Token{TokenGe, ">="}, nil
+		}
+		return Token{TokenGt, ">"}, nil
+	case '!':
+		tk.popByte()
+		if next, _ := tk.peekByte(); next == '=' {
+			tk.popByte()
+			return Token{TokenNe, "!="}, nil
+		}
+		tk.err = errorInvaildState
+		return Token{TokenNull, ""}, tk.err
+	case '+':
+		tk.popByte()
+		return Token{TokenPlus, "+"}, nil
+	case '-':
+		tk.popByte()
+		return Token{TokenMinus, "-"}, nil
+	case '/':
+		tk.popByte()
+		return Token{TokenSlash, "/"}, nil
+	case '%':
+		tk.popByte()
+		return Token{TokenPercent, "%"}, nil
+	case '?', ':':
+		return tk.nextParamState()
 	default:
 		if isAlphaBeta(b) || isDigital(b) {
 			return tk.nextTokenState()
@@ -197,6 +284,31 @@ func (tk *Tokenizer) nextQuoteState() (Token, error) {
 	return Token{TokenString, string(tmp)}, nil
 }
 
+// nextParamState read a parameter. '?' may be followed by a number, ':' must be
+// followed by a name.
+func (tk *Tokenizer) nextParamState() (Token, error) {
+	prefix, _ := tk.peekByte()
+	tk.popByte()
+	tmp := []byte{prefix}
+	for {
+		b, eof := tk.peekByte()
+		if eof || !isIndentifier(b) {
+			break
+		}
+		if prefix == '?' && !isDigital(b) {
+			tk.err = errorInvaildState
+			return Token{TokenNull, ""}, tk.err
+		}
+		tmp = append(tmp, b)
+		tk.popByte()
+	}
+	if prefix == ':' && (len(tmp) == 1 || isDigital(tmp[1])) {
+		tk.err = errorInvaildState
+		return Token{TokenNull, ""}, tk.err
+	}
+	return Token{TokenParam, string(tmp)}, nil
+}
+
 func (tk *Tokenizer) nextTokenState() (Token, error) {
 	var tmp []byte
 	is_number := true
@@ -212,7 +324,8 @@ func (tk *Tokenizer) nextTokenState() (Token, error) {
 			if is_number {
 				return Token{TokenDigit, string(tmp)}, nil
 			} else if isKeyword(tmp) {
-				return Token{TokenKeyword, string(tmp)}, nil
+				// keywords are case insensitive
+				return Token{TokenKeyword, string(bytes.ToLower(tmp))}, nil
 			} else {
 				return Token{TokenIdentifier, string(tmp)}, nil
 			}
@@ -0,0 +1,80 @@
+package tokenizer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// tokens read all the tokens of the string, the error is the one that stop it.
+func tokens(str string) ([]Token, error) {
+	tk := NewTokenizer(str)
+	var result []Token
+	for {
+		token, err := tk.PeekToken()
+		if err == ErrorEndofFile {
+			return result, nil
+		} else if err != nil {
+			return result, err
+		}
+		result = append(result, token)
+		tk.PopToken()
+	}
+}
+
+func TestOperators(t *testing.T) {
+	result, err := tokens("a<>b!=c<=d>=e<f>g=h")
+	assert.Nil(t, err)
+	assert.Equal(t, []Token{
+		{TokenIdentifier, "a"}, {TokenNe, "<>"}, {TokenIdentifier, "b"}, {TokenNe, "!="},
+		{TokenIdentifier, "c"}, {TokenLe, "<="}, {TokenIdentifier, "d"}, {TokenGe, ">="},
+		{TokenIdentifier, "e"}, {TokenLt, "<"}, {TokenIdentifier, "f"}, {TokenGt, ">"},
+		{TokenIdentifier, "g"}, {TokenEq, "="}, {TokenIdentifier, "h"},
+	}, result)
+	result, err = tokens("(1 + 2) * -3 / 4 % 5, t.c")
+	assert.Nil(t, err)
+	assert.Equal(t, []Token{
+		{TokenLP, "("}, {TokenDigit, "1"}, {TokenPlus, "+"}, {TokenDigit, "2"}, {TokenRP, ")"},
+		{TokenStar, "*"}, {TokenMinus, "-"}, {TokenDigit, "3"}, {TokenSlash, "/"}, {TokenDigit, "4"},
+		{TokenPercent, "%"}, {TokenDigit, "5"}, {TokenComma, ","},
+		{TokenIdentifier, "t"}, {TokenDot, "."}, {TokenIdentifier, "c"},
+	}, result)
+	_, err = tokens("a ! b")
+	assert.NotNil(t, err)
+}
+
+func TestParams(t *testing.T) {
+	result, err := tokens("? ?12 :name :a_1,?")
+	assert.Nil(t, err)
+	assert.Equal(t, []Token{
+		{TokenParam, "?"}, {TokenParam, "?12"}, {TokenParam, ":name"},
+		{TokenParam, ":a_1"}, {TokenComma, ","}, {TokenParam, "?"},
+	}, result)
+	for _, str := range []string{"?x", ":", ": name", ":1"} {
+		_, err := tokens(str)
+		assert.NotNil(t, err, str)
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	// keywords are case insensitive, the identifiers keep their case
+	result, err := tokens("SELECT Name from Users WHERE id IS NOT null")
+	assert.Nil(t, err)
+	assert.Equal(t, []Token{
+		{TokenKeyword, "select"}, {TokenIdentifier, "Name"}, {TokenKeyword, "from"},
+		{TokenIdentifier, "Users"}, {TokenKeyword, "where"}, {TokenIdentifier, "id"},
+		{TokenKeyword, "is"}, {TokenKeyword, "not"}, {TokenKeyword, "null"},
+	}, result)
+	// the words which are not reserved are identifiers
+	for _, word := range []string{"Begin", "COMMIT", "end", "Rollback", "savepoint", "release", "to",
+		"Transaction", "deferred", "immediate", "exclusive", "Index", "unique", "ON", "order", "By", "asc", "DESC"} {
+		result, err := tokens(word)
+		assert.Nil(t, err)
+		assert.Equal(t, []Token{{TokenIdentifier, word}}, result)
+	}
+	result, err = tokens("user_1 42 'a b'")
+	assert.Nil(t, err)
+	assert.Equal(t, []Token{{TokenIdentifier, "user_1"}, {TokenDigit, "42"}, {TokenString, "a b"}}, result)
+	result, err = tokens(".tables users")
+	assert.Nil(t, err)
+	assert.Equal(t, []Token{{TokenMetaCommand, "tables"}, {TokenIdentifier, "users"}}, result)
+}
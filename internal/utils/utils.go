@@ -9,7 +9,7 @@ func SetUint16(raw []byte, data uint16) {
 	buf := bytes.NewBuffer([]byte{})
 	buf.Reset()
 	binary.Write(buf, binary.LittleEndian, data)
-	copy(raw[:4], buf.Bytes())
+	copy(raw[:2], buf.Bytes())
 }
 
 func GetUint16(raw []byte) uint16 {
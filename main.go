@@ -1,34 +1,38 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
-
-	"godb/internal/parser"
 	"os"
+	"path/filepath"
+
+	"godb/internal/executor"
+	"godb/internal/shell"
 )
 
-func print_prompt() {
-	fmt.Print("db > ")
-}
+const historyFileName = ".godb_history"
 
 func main() {
-	reader := bufio.NewReader(os.Stdin)
-	print_prompt()
-	if text, err := reader.ReadString('\n'); err == nil {
-		p, err := parser.Parse(text)
-		if err != nil {
-			panic(err)
-		}
-		switch ct := p.(type) {
-		case parser.CreateTableStatement:
-			fmt.Println(ct)
-		case parser.InsertStatement:
-			fmt.Println(ct)
-		case parser.SelectStatement:
-			fmt.Println(ct)
-		}
-	} else {
-		fmt.Println(err)
+	fileName := ""
+	if len(os.Args) > 1 {
+		fileName = os.Args[1]
+	}
+	exec, err := executor.Open(fileName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	sh := shell.New(exec, fileName, os.Stdout)
+	if home, err := os.UserHomeDir(); err == nil {
+		sh.LoadHistory(filepath.Join(home, historyFileName))
+	}
+	interactive := true
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice == 0 {
+		// the input is piped in, no prompt is printed
+		interactive = false
+	}
+	sh.Run(os.Stdin, interactive)
+	if err := sh.Exec.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
 }